@@ -3,9 +3,12 @@ package common
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ian-kent/go-log/log"
@@ -15,8 +18,11 @@ import (
 
 var AliasPathOverride = ""
 
-// Artificially limits the number of aliases - can easily handle more, but the search needs to be updated
-const maxAliasCount = 100
+// Aliases are loaded a page at a time
+const aliasPageSize = 100
+
+// How many times a new alias is tried when another indexer takes the same one first
+const aliasCreateAttempts = 10
 
 const AliasTypeName = "alias"
 
@@ -27,7 +33,9 @@ type AliasDocument struct {
 	DateLastIndexed time.Time `json:"datetimelastindexed"`
 }
 
+// The list is replaced, never modified in place, so the slice from currentAliases can be read without the lock
 var aliasAndPath []AliasDocument
+var aliasLock sync.RWMutex
 
 // Load all aliases
 func InitializeAliases(client *elastic.Client) error {
@@ -35,8 +43,9 @@ func InitializeAliases(client *elastic.Client) error {
 	return loadAliases(client)
 }
 
+// The callback is given a snapshot, so it's free to add, remove or relocate aliases
 func VisitAllPaths(callback func(alias AliasDocument)) {
-	for _, ab := range aliasAndPath {
+	for _, ab := range currentAliases() {
 		callback(ab)
 	}
 }
@@ -126,11 +135,15 @@ func UpdateLastIndexed(alias string) error {
 			Index(AliasIndexName).
 			Type(AliasTypeName).
 			Id(aliasDocument.Alias).
-			Doc(aliasDocument).
+			Doc(map[string]interface{}{"datetimelastindexed": aliasDocument.DateLastIndexed}).
 			Do(context.TODO())
 		if err != nil {
 			return err
 		}
+
+		replaceAlias(aliasDocument.Alias, func(ad *AliasDocument) {
+			ad.DateLastIndexed = aliasDocument.DateLastIndexed
+		})
 	} else {
 		return fmt.Errorf("Failed updating alias: Cannot find alias '%s'", alias)
 	}
@@ -138,6 +151,77 @@ func UpdateLastIndexed(alias string) error {
 	return nil
 }
 
+// Adds an alias for the path, unless there already is one. Returns the path's alias document
+func AddAlias(path string) (AliasDocument, error) {
+	alias, err := AliasForPath(path)
+	if err != nil {
+		return AliasDocument{}, err
+	}
+
+	ad := findViaAlias(alias)
+	if ad == nil {
+		return AliasDocument{}, fmt.Errorf("Can't find just added alias for '%s'", path)
+	}
+	return *ad, nil
+}
+
+// Removes the alias document; the media, duplicates and thumbnails of the alias are left to the caller
+func RemoveAlias(alias string) error {
+	ad := findViaAlias(alias)
+	if ad == nil {
+		return fmt.Errorf("Failed removing alias: Cannot find alias '%s'", alias)
+	}
+
+	log.Warn("Removing alias '%s' for '%s'", ad.Alias, ad.Path)
+	client := CreateClient()
+	_, err := client.Delete().
+		Index(AliasIndexName).
+		Type(AliasTypeName).
+		Id(ad.Alias).
+		Refresh("true").
+		Do(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	aliasLock.Lock()
+	defer aliasLock.Unlock()
+	aliasList := make([]AliasDocument, 0, len(aliasAndPath))
+	for _, existing := range aliasAndPath {
+		if existing.Alias != ad.Alias {
+			aliasList = append(aliasList, existing)
+		}
+	}
+	aliasAndPath = aliasList
+	return nil
+}
+
+// Points the alias at a new path. The media is indexed by alias, so it needn't be indexed again
+func RelocateAlias(alias string, path string) error {
+	ad := findViaAlias(alias)
+	if ad == nil {
+		return fmt.Errorf("Failed relocating alias: Cannot find alias '%s'", alias)
+	}
+
+	log.Warn("Relocating alias '%s' from '%s' to '%s'", ad.Alias, ad.Path, path)
+	client := CreateClient()
+	_, err := client.Update().
+		Index(AliasIndexName).
+		Type(AliasTypeName).
+		Id(ad.Alias).
+		Doc(map[string]interface{}{"aliaspath": path}).
+		Refresh("true").
+		Do(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	replaceAlias(ad.Alias, func(existing *AliasDocument) {
+		existing.Path = path
+	})
+	return nil
+}
+
 func extactAlias(aliasAndPath string) (string, string) {
 	pos := strings.Index(aliasAndPath, "\\")
 	if pos == -1 {
@@ -148,8 +232,42 @@ func extactAlias(aliasAndPath string) (string, string) {
 	return aliasAndPath[0:pos], partialPath
 }
 
+func currentAliases() []AliasDocument {
+	aliasLock.RLock()
+	defer aliasLock.RUnlock()
+	return aliasAndPath
+}
+
+// Replaces the list with one where the alias has been changed by the update
+func replaceAlias(alias string, update func(ad *AliasDocument)) {
+	aliasLock.Lock()
+	defer aliasLock.Unlock()
+	aliasList := make([]AliasDocument, 0, len(aliasAndPath))
+	for _, existing := range aliasAndPath {
+		if existing.Alias == alias {
+			update(&existing)
+		}
+		aliasList = append(aliasList, existing)
+	}
+	aliasAndPath = aliasList
+}
+
+// Adds the alias to the list, unless it's already there
+func appendAlias(ad AliasDocument) {
+	aliasLock.Lock()
+	defer aliasLock.Unlock()
+	for _, existing := range aliasAndPath {
+		if existing.Alias == ad.Alias {
+			return
+		}
+	}
+	aliasList := make([]AliasDocument, 0, len(aliasAndPath)+1)
+	aliasList = append(aliasList, aliasAndPath...)
+	aliasAndPath = append(aliasList, ad)
+}
+
 func findViaPath(path string) *AliasDocument {
-	for _, ad := range aliasAndPath {
+	for _, ad := range currentAliases() {
 		if strings.EqualFold(path, ad.Path) {
 			return &ad
 		}
@@ -160,7 +278,7 @@ func findViaPath(path string) *AliasDocument {
 
 func findViaAlias(alias string) *AliasDocument {
 	// Given an alias, return the associated path
-	for _, ad := range aliasAndPath {
+	for _, ad := range currentAliases() {
 		if strings.EqualFold(alias, ad.Alias) {
 			return &ad
 		}
@@ -170,25 +288,22 @@ func findViaAlias(alias string) *AliasDocument {
 }
 
 func loadAliases(client *elastic.Client) error {
-	search := client.Search().
-		Index(AliasIndexName).
+	scrollService := client.Scroll(AliasIndexName).
 		Type(AliasTypeName).
-		Pretty(true).
 		Query(elastic.NewMatchAllQuery()).
-		Size(maxAliasCount) //.
-		// Sort("datetime", false) // Sort by created date, descending
-	result, err := search.Do(context.TODO())
-	if err != nil {
-		return err
-	}
-
-	numAliases := result.TotalHits()
-	if numAliases > maxAliasCount {
-		log.Fatalf("There are more aliases than can currently be handled: %d", numAliases)
-	}
+		Size(aliasPageSize)
+	defer scrollService.Clear(context.TODO())
 
 	aliasList := make([]AliasDocument, 0)
-	if numAliases > 0 {
+	for {
+		result, err := scrollService.Do(context.TODO())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
 		for _, hit := range result.Hits.Hits {
 			alias := &AliasDocument{}
 			err := json.Unmarshal(*hit.Source, alias)
@@ -205,53 +320,74 @@ func loadAliases(client *elastic.Client) error {
 		}
 	}
 
+	aliasLock.Lock()
+	defer aliasLock.Unlock()
 	aliasAndPath = aliasList
 	return nil
 }
 
+// Allocates the next alias for the path. The alias document is created only if no other indexer has
+// taken that alias; if one has, the aliases are reloaded and the following one is tried
 func addNewAlias(path string) error {
 	client := CreateClient()
-	err := loadAliases(client)
-	if err != nil {
-		return err
-	}
+	for attempt := 1; attempt <= aliasCreateAttempts; attempt++ {
+		err := loadAliases(client)
+		if err != nil {
+			return err
+		}
 
-	var newAliasNumber int
-	if len(aliasAndPath) == 0 {
-		newAliasNumber = 1
-	} else {
-		latest := aliasAndPath[0]
-		for _, ad := range aliasAndPath {
-			if ad.DateAdded.Unix() > latest.DateAdded.Unix() {
-				latest = ad
-			}
+		// Another indexer may have added the path in the meantime
+		if findViaPath(path) != nil {
+			return nil
+		}
+
+		ad := &AliasDocument{
+			Path:      path,
+			Alias:     fmt.Sprintf("%d", nextAliasNumber()),
+			DateAdded: time.Now()}
+
+		log.Warn("Adding alias '%s' for '%s'", ad.Alias, ad.Path)
+		response, err := client.Index().
+			Index(AliasIndexName).
+			Type(AliasTypeName).
+			Id(ad.Alias).
+			OpType("create").
+			BodyJson(ad).
+			Refresh("true").
+			Do(context.TODO())
+		if isConflict(err) {
+			log.Warn("Alias '%s' was taken by another indexer, trying again", ad.Alias)
+			continue
 		}
-		newAliasNumber, err = strconv.Atoi(latest.Alias)
 		if err != nil {
 			return err
 		}
-		newAliasNumber++
+		if !response.Created {
+			return fmt.Errorf("Failed creating alias entry for new path '%s'", path)
+		}
+
+		appendAlias(*ad)
+		return nil
 	}
 
-	ad := &AliasDocument{
-		Path:      path,
-		Alias:     fmt.Sprintf("%d", newAliasNumber),
-		DateAdded: time.Now()}
+	return fmt.Errorf("Failed creating alias entry for new path '%s': gave up after %d attempts", path, aliasCreateAttempts)
+}
 
-	log.Warn("Adding alias '%s' for '%s'", ad.Alias, ad.Path)
-	response, err := client.Index().
-		Index(AliasIndexName).
-		Type(AliasTypeName).
-		Id(ad.Alias).
-		BodyJson(ad).
-		Do(context.TODO())
-	if err != nil {
-		return err
-	}
-	if !response.Created {
-		return fmt.Errorf("Failed creating alias entry for new path '%s'", path)
+// One more than the largest alias in use
+func nextAliasNumber() int {
+	largest := 0
+	for _, ad := range currentAliases() {
+		number, err := strconv.Atoi(ad.Alias)
+		if err == nil && number > largest {
+			largest = number
+		}
 	}
+	return largest + 1
+}
 
-	aliasAndPath = append(aliasAndPath, *ad)
-	return nil
+func isConflict(err error) bool {
+	if e, ok := err.(*elastic.Error); ok {
+		return e.Status == http.StatusConflict
+	}
+	return false
 }
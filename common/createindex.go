@@ -2,6 +2,7 @@ package common
 
 import (
 	"errors"
+	"strconv"
 
 	"github.com/ian-kent/go-log/log"
 	"golang.org/x/net/context"
@@ -28,6 +29,74 @@ func CreateClarifaiClassifyIndex(client *elastic.Client) error {
 	return nil
 }
 
+func CreateIndexRunIndex(client *elastic.Client) error {
+	log.Warn("Creating index '%s'", IndexRunIndexName)
+
+	mapping := `{
+		"settings": {
+			"number_of_shards": 1,
+			"number_of_replicas": 0
+		},
+		"mappings": {
+			"run" : {
+				"_all": {
+					"enabled": false
+				},
+				"properties" : {
+					"alias" : {
+						"type" : "keyword"
+					},
+					"path" : {
+						"type" : "keyword"
+					},
+					"aliases" : {
+						"type" : "keyword"
+					},
+					"paths" : {
+						"type" : "keyword"
+					},
+					"watch" : {
+						"type" : "boolean"
+					},
+					"active" : {
+						"type" : "boolean"
+					},
+					"starttime" : {
+						"type" : "date"
+					},
+					"endtime" : {
+						"type" : "date"
+					},
+					"durationseconds" : {
+						"type" : "double"
+					},
+					"counters" : {
+						"type" : "object",
+						"dynamic" : true
+					},
+					"errors" : {
+						"type" : "text",
+						"index" : false
+					},
+					"errorcount" : {
+						"type" : "long"
+					}
+				}
+			}
+		}
+	}`
+
+	response, err := client.CreateIndex(IndexRunIndexName).BodyString(mapping).Do(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	if response.Acknowledged != true {
+		return errors.New("Index creation not acknowledged")
+	}
+	return nil
+}
+
 func CreateAliasIndex(client *elastic.Client) error {
 	log.Warn("Creating index '%s'", AliasIndexName)
 
@@ -82,8 +151,52 @@ func CreateAliasIndex(client *elastic.Client) error {
 	return nil
 }
 
+// The params vary by code, they're kept with the document but not indexed
+const warningEntriesMapping = `"warningentries" : {
+					  "properties" : {
+						"code" : {
+						  "type" : "keyword"
+						},
+						"message" : {
+						  "type" : "text",
+						  "fields": {
+							"value": {
+							  "type":  "keyword"
+							}
+						  }
+						},
+						"params" : {
+						  "type" : "object",
+						  "enabled" : false
+						}
+					  }
+					}`
+
+// Adds the structured warnings to the mapping of an index created before they existed
+func AddWarningEntriesMapping(client *elastic.Client) error {
+	_, err := client.PutMapping().
+		Index(MediaIndexName).
+		Type(MediaTypeName).
+		BodyString(`{ "properties" : { ` + warningEntriesMapping + ` } }`).
+		Do(context.TODO())
+	return err
+}
+
+// Creates the index of the current schema version, reached through the MediaIndexName alias
 func CreateMediaIndex(client *elastic.Client) error {
-	log.Warn("Creating index '%s'", MediaIndexName)
+	index := VersionedMediaIndexName(MediaSchemaVersion)
+	err := CreateVersionedMediaIndex(client, index)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Alias().Add(index, MediaIndexName).Do(context.TODO())
+	return err
+}
+
+// Creates an index with the mapping of the current schema version, recording the version in its metadata
+func CreateVersionedMediaIndex(client *elastic.Client, index string) error {
+	log.Warn("Creating index '%s' (schema version %d)", index, MediaSchemaVersion)
 
 	mapping := `{
 		"settings": {
@@ -93,6 +206,9 @@ func CreateMediaIndex(client *elastic.Client) error {
 		},
 		"mappings": {
 			"media": {
+				"_meta": {
+					"schemaversion": ` + strconv.Itoa(MediaSchemaVersion) + `
+				},
 				"_all": {
 					"enabled": false
 			    },
@@ -104,6 +220,20 @@ func CreateMediaIndex(client *elastic.Client) error {
 					  "type" : "date",
 					  "format": "yyyy-MM-dd'T'HH:mm:ssZ"
 					},
+					"localdatetime" : {
+					  "type" : "date",
+					  "format": "yyyy-MM-dd'T'HH:mm:ss"
+					},
+					"utcdatetime" : {
+					  "type" : "date",
+					  "format": "yyyy-MM-dd'T'HH:mm:ssZ"
+					},
+					"timezone" : {
+					  "type" : "keyword"
+					},
+					"timezonemethod" : {
+					  "type" : "keyword"
+					},
 					"keywords" : {
 					  "type" : "text",
 			          "fields": {
@@ -128,6 +258,7 @@ func CreateMediaIndex(client *elastic.Client) error {
 			            }
 					  }
 					},
+					` + warningEntriesMapping + `,
 					"placename" : {
 					  "type" : "text",
 			          "fields": {
@@ -179,6 +310,15 @@ func CreateMediaIndex(client *elastic.Client) error {
 					"location" : {
 					  "type" : "geo_point"
 					},
+					"altitude" : {
+					  "type" : "float"
+					},
+					"imagedirection" : {
+					  "type" : "float"
+					},
+					"locationsource" : {
+					  "type" : "keyword"
+					},
 					"lengthinbytes" : {
 					  "type" : "long"
 					},
@@ -193,6 +333,87 @@ func CreateMediaIndex(client *elastic.Client) error {
 					"signature" : {
 					  "type" : "keyword"
 					},
+					"fullsignature" : {
+					  "type" : "keyword"
+					},
+					"perceptualhash" : {
+					  "type" : "keyword"
+					},
+					"container" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword"
+			            }
+					  }
+					},
+					"videocodec" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword"
+			            }
+					  }
+					},
+					"videoresolution" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword"
+			            }
+					  }
+					},
+					"framerate" : {
+					  "type" : "float"
+					},
+					"frameratename" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword"
+			            }
+					  }
+					},
+					"bitrate" : {
+					  "type" : "long"
+					},
+					"rotation" : {
+					  "type" : "integer"
+					},
+					"audiocodec" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword"
+			            }
+					  }
+					},
+					"audiochannels" : {
+					  "type" : "integer"
+					},
+					"sidecarsignature" : {
+					  "type" : "keyword"
+					},
+					"rating" : {
+					  "type" : "integer"
+					},
+					"title" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword"
+			            }
+					  }
+					},
+					"description" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword",
+			              "ignore_above" : 256
+			            }
+					  }
+					},
 					
 					"aperture" : {
 					  "type" : "float"
@@ -289,6 +510,54 @@ func CreateMediaIndex(client *elastic.Client) error {
 			            }
 					  }
 					},
+					"cameraserialnumber" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword"
+			            }
+					  }
+					},
+					"lensserialnumber" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword"
+			            }
+					  }
+					},
+					"orientation" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword"
+			            }
+					  }
+					},
+					"artist" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword"
+			            }
+					  }
+					},
+					"copyright" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword"
+			            }
+					  }
+					},
+					"software" : {
+					  "type" : "text",
+			          "fields": {
+			            "value": { 
+			              "type":  "keyword"
+			            }
+					  }
+					},
 
 					"cityname" : {
 					  "type" : "text",
@@ -343,7 +612,7 @@ func CreateMediaIndex(client *elastic.Client) error {
 		}
 	}`
 
-	response, err := client.CreateIndex(MediaIndexName).BodyString(mapping).Do(context.TODO())
+	response, err := client.CreateIndex(index).BodyString(mapping).Do(context.TODO())
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,28 @@
+package common
+
+import (
+	"time"
+)
+
+// What an indexer dry run (IndexMakeNoChanges) found it would do
+type DryRunReport struct {
+	Alias      string            `json:"alias"`
+	Path       string            `json:"path"`
+	Started    time.Time         `json:"started"`
+	Completed  time.Time         `json:"completed"`
+	Added      []string          `json:"added"`
+	Updated    []DryRunUpdate    `json:"updated"`
+	Removed    []string          `json:"removed"`
+	Duplicates []DryRunDuplicate `json:"duplicates"`
+	Thumbnails []string          `json:"thumbnails"`
+}
+
+type DryRunUpdate struct {
+	Path          string   `json:"path"`
+	ChangedFields []string `json:"changedFields"`
+}
+
+type DryRunDuplicate struct {
+	Path        string `json:"path"`
+	DuplicateOf string `json:"duplicateOf"`
+}
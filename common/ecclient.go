@@ -14,10 +14,6 @@ import klog "github.com/ian-kent/go-log/log"
 
 var ElasticSearchServer = "http://localhost:9200"
 
-type warningUpdate struct {
-	Warnings []string `json:"warnings,omitempty"`
-}
-
 func CreateClient() *elastic.Client {
 	client, err := elastic.NewSimpleClient(
 		elastic.SetURL(ElasticSearchServer),
@@ -33,9 +29,13 @@ func CreateClient() *elastic.Client {
 	return client
 }
 
-func AddWarning(client *elastic.Client, id string, newWarnings []string) {
+func AddWarning(client *elastic.Client, id string, newWarnings []Warning) {
 
-	joinedWarnings := strings.Join(newWarnings, "; ")
+	messages := []string{}
+	for _, w := range newWarnings {
+		messages = append(messages, w.Message)
+	}
+	joinedWarnings := strings.Join(messages, "; ")
 
 	// Load existing document
 	searchResult, err := client.Search().
@@ -60,10 +60,13 @@ func AddWarning(client *elastic.Client, id string, newWarnings []string) {
 			return
 		}
 
-		var updatedDoc warningUpdate
-		updatedDoc.Warnings = append(media.Warnings, joinedWarnings)
+		media.MigrateLegacyWarnings()
 
-		// Update document with full warning array
+		// Update document with full warning array; the legacy warnings were moved into it
+		updatedDoc := map[string]interface{}{
+			"warningentries": append(media.Warnings, newWarnings...),
+			"warnings":       nil,
+		}
 		_, err = client.Update().
 			Fields().
 			Index(MediaIndexName).
@@ -0,0 +1,376 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/context"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// The media index in Elasticsearch
+type ElasticMediaStore struct{}
+
+func NewElasticMediaStore() *ElasticMediaStore {
+	return &ElasticMediaStore{}
+}
+
+func (es *ElasticMediaStore) GetMedia(id string) (*Media, error) {
+	result, err := CreateClient().Get().
+		Index(MediaIndexName).
+		Type(MediaTypeName).
+		Id(id).
+		Do(context.TODO())
+	if elastic.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !result.Found || result.Source == nil {
+		return nil, nil
+	}
+
+	media := &Media{}
+	err = json.Unmarshal(*result.Source, media)
+	if err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+func (es *ElasticMediaStore) Search(options *MediaSearchOptions) (*MediaSearchResult, error) {
+	query := elasticQuery(&options.Query)
+	search := CreateClient().Search().
+		Index(MediaIndexName).
+		Type(MediaTypeName).
+		From(options.From).
+		Size(options.Size)
+
+	switch options.Sort {
+	case SortNewestFirst:
+		search.Sort("datetime", false)
+	case SortDayOfYearAscending:
+		search.Sort("dayofyear", true)
+	case SortDayOfYearDescending:
+		search.Sort("dayofyear", false)
+	case SortNearestFirst:
+		if near := options.Query.Near; near != nil {
+			search.SortBy(elastic.NewGeoDistanceSort("location").Point(near.Latitude, near.Longitude).Order(true).Unit("km"))
+		}
+	case SortRandom:
+		query = elastic.NewFunctionScoreQuery().
+			Query(query).
+			AddScoreFunc(elastic.NewRandomFunction())
+	}
+	search.Query(query)
+
+	for _, aggregation := range options.Aggregations {
+		search.Aggregation(aggregation.Name, elasticAggregation(aggregation))
+	}
+
+	result, err := search.Do(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &MediaSearchResult{TotalHits: result.TotalHits(), Aggregations: make(map[string][]*TermsBucket)}
+	if result.Hits != nil {
+		for _, hit := range result.Hits.Hits {
+			msh, err := toMediaSearchHit(hit)
+			if err != nil {
+				return nil, err
+			}
+
+			// For the geo sort, the returned sort value is the distance from the given point, in kilometers
+			if options.Sort == SortNearestFirst && len(hit.Sort) > 0 {
+				if v, ok := hit.Sort[0].(float64); ok {
+					msh.DistanceKm = &v
+				}
+			}
+			sr.Hits = append(sr.Hits, msh)
+		}
+	}
+
+	for _, aggregation := range options.Aggregations {
+		if terms, found := result.Aggregations.Terms(aggregation.Name); found {
+			sr.Aggregations[aggregation.Name] = toTermsBuckets(terms, aggregation.SubAggregation)
+		}
+	}
+	return sr, nil
+}
+
+func (es *ElasticMediaStore) IndexMedia(id string, media *Media) error {
+	_, err := CreateClient().Index().
+		Index(MediaIndexName).
+		Type(MediaTypeName).
+		Id(id).
+		BodyJson(media).
+		Do(context.TODO())
+	return err
+}
+
+func (es *ElasticMediaStore) UpdateMedia(id string, fields map[string]interface{}) error {
+	_, err := CreateClient().Update().
+		Index(MediaIndexName).
+		Type(MediaTypeName).
+		Id(id).
+		Doc(fields).
+		Do(context.TODO())
+	return err
+}
+
+func (es *ElasticMediaStore) DeleteMedia(id string) error {
+	_, err := CreateClient().Delete().
+		Index(MediaIndexName).
+		Type(MediaTypeName).
+		Id(id).
+		Do(context.TODO())
+	return err
+}
+
+func (es *ElasticMediaStore) DeleteMatching(query *MediaQuery) (int64, error) {
+	response, err := CreateClient().DeleteByQuery().
+		Index(MediaIndexName).
+		Type(MediaTypeName).
+		Query(elasticQuery(query)).
+		Refresh("true").
+		Do(context.TODO())
+	if err != nil {
+		return 0, err
+	}
+	return response.Deleted, nil
+}
+
+func (es *ElasticMediaStore) Scroll(query *MediaQuery, fields []string, pageSize int, callback func(hits []*MediaSearchHit) bool) error {
+	scrollService := CreateClient().Scroll(MediaIndexName).
+		Type(MediaTypeName).
+		Query(elasticQuery(query)).
+		Size(pageSize)
+	if len(fields) > 0 {
+		scrollService.FetchSourceContext(elastic.NewFetchSourceContext(true).Include(fields...))
+	}
+	defer scrollService.Clear(context.TODO())
+
+	for {
+		result, err := scrollService.Do(context.TODO())
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		hits := make([]*MediaSearchHit, 0, len(result.Hits.Hits))
+		for _, hit := range result.Hits.Hits {
+			msh, err := toMediaSearchHit(hit)
+			if err != nil {
+				return err
+			}
+			hits = append(hits, msh)
+		}
+		if !callback(hits) {
+			return nil
+		}
+	}
+}
+
+func (es *ElasticMediaStore) Duplicates(from int, size int) ([]*DuplicateItem, int64, error) {
+	result, err := CreateClient().Search().
+		Index(MediaIndexName).
+		Type(DuplicateTypeName).
+		Query(elastic.NewMatchAllQuery()).
+		From(from).
+		Size(size).
+		Do(context.TODO())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	duplicates := make([]*DuplicateItem, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		di := &DuplicateItem{}
+		err := json.Unmarshal(*hit.Source, di)
+		if err != nil {
+			return nil, 0, err
+		}
+		duplicates = append(duplicates, di)
+	}
+	return duplicates, result.TotalHits(), nil
+}
+
+func toMediaSearchHit(hit *elastic.SearchHit) (*MediaSearchHit, error) {
+	msh := &MediaSearchHit{Id: hit.Id, Media: &Media{}}
+	err := json.Unmarshal(*hit.Source, msh.Media)
+	if err != nil {
+		return nil, err
+	}
+	return msh, nil
+}
+
+func toTermsBuckets(terms *elastic.AggregationBucketKeyItems, subAggregation *TermsAggregation) []*TermsBucket {
+	buckets := make([]*TermsBucket, 0, len(terms.Buckets))
+	for _, bucket := range terms.Buckets {
+		tb := &TermsBucket{Key: bucket.Key, Count: bucket.DocCount}
+		if subAggregation != nil {
+			if subTerms, found := bucket.Aggregations.Terms(subAggregation.Name); found {
+				tb.SubAggregationName = subAggregation.Name
+				tb.SubBuckets = toTermsBuckets(subTerms, subAggregation.SubAggregation)
+			}
+		}
+		buckets = append(buckets, tb)
+	}
+	return buckets
+}
+
+func elasticAggregation(aggregation *TermsAggregation) *elastic.TermsAggregation {
+	terms := elastic.NewTermsAggregation().Size(aggregation.Size)
+	if IsDrilldownDateField(aggregation.Field) {
+		terms.Script(elastic.NewScriptInline(dateScript(aggregation.Field)).Lang("painless"))
+	} else {
+		terms.Field(aggregation.Field)
+	}
+
+	if aggregation.SubAggregation != nil {
+		terms.SubAggregation(aggregation.SubAggregation.Name, elasticAggregation(aggregation.SubAggregation))
+	}
+	return terms
+}
+
+func elasticQuery(mq *MediaQuery) elastic.Query {
+	query := elastic.NewBoolQuery()
+	if mq.Text == "" {
+		query.Must(elastic.NewMatchAllQuery())
+	} else {
+		textQuery := elastic.NewQueryStringQuery(mq.Text)
+		for _, field := range mq.TextFields {
+			textQuery.Field(field)
+		}
+		query.Must(textQuery)
+	}
+
+	if mq.DayOfYearFrom > 0 && mq.DayOfYearFrom == mq.DayOfYearTo {
+		query.Must(elastic.NewTermQuery("dayofyear", mq.DayOfYearFrom))
+	} else if mq.DayOfYearFrom > 0 || mq.DayOfYearTo > 0 {
+		dayRange := elastic.NewRangeQuery("dayofyear")
+		if mq.DayOfYearFrom > 0 {
+			dayRange.Gte(mq.DayOfYearFrom)
+		}
+		if mq.DayOfYearTo > 0 {
+			dayRange.Lte(mq.DayOfYearTo)
+		}
+		query.Must(dayRange)
+	}
+
+	if mq.Near != nil {
+		query.Must(elastic.NewGeoDistanceQuery("location").
+			Lat(mq.Near.Latitude).
+			Lon(mq.Near.Longitude).
+			Distance(fmt.Sprintf("%fkm", mq.Near.Kilometers)))
+	}
+
+	for _, field := range mq.Exists {
+		query.Must(elastic.NewExistsQuery(field))
+	}
+
+	if len(mq.Ids) > 0 {
+		query.Must(elastic.NewIdsQuery(MediaTypeName).Ids(mq.Ids...))
+	}
+
+	if mq.PathPrefix != "" {
+		query.Must(elastic.NewPrefixQuery("path.value", mq.PathPrefix))
+	}
+
+	addElasticDrilldown(query, mq.Drilldown)
+	return query
+}
+
+func addElasticDrilldown(query *elastic.BoolQuery, drilldown map[string][]string) {
+	if len(drilldown) < 1 {
+		return
+	}
+
+	locationQuery := elastic.NewBoolQuery()
+	hasLocation := false
+	dateQuery := elastic.NewBoolQuery()
+	hasDate := false
+
+	for key, values := range drilldown {
+		fieldName := key
+		keyGroup := strings.Split(key, "~")
+		isHierarchical := len(keyGroup) > 1
+		if isHierarchical {
+			fieldName = keyGroup[0]
+		}
+
+		if IsDrilldownLocationField(fieldName) {
+			hasLocation = true
+			for _, value := range values {
+				if isHierarchical {
+					valueGroup := strings.Split(value, "~")
+					q := elastic.NewBoolQuery()
+					for index := range keyGroup {
+						q.Must(elastic.NewTermQuery(DrilldownLocationFieldName(keyGroup[index]), drilldownValue(valueGroup, index)))
+					}
+					locationQuery.Should(q)
+				} else {
+					locationQuery.Should(elastic.NewTermQuery(DrilldownLocationFieldName(fieldName), value))
+				}
+			}
+		} else if IsDrilldownDateField(fieldName) {
+			hasDate = true
+			for _, value := range values {
+				if isHierarchical {
+					valueGroup := strings.Split(value, "~")
+					q := elastic.NewBoolQuery()
+					for index := range keyGroup {
+						q.Must(dateFieldQuery(keyGroup[index], drilldownValue(valueGroup, index)))
+					}
+					dateQuery.Should(q)
+				} else {
+					dateQuery.Should(dateFieldQuery(fieldName, value))
+				}
+			}
+		} else {
+			fieldQuery := elastic.NewBoolQuery()
+			for _, value := range values {
+				indexFieldName, overridden := GetIndexFieldName(fieldName)
+				if !overridden {
+					value = strings.ToLower(value)
+				}
+				fieldQuery.Should(elastic.NewTermQuery(indexFieldName, value))
+			}
+			query.Must(fieldQuery)
+		}
+	}
+
+	if hasLocation {
+		query.Must(locationQuery)
+	}
+	if hasDate {
+		query.Must(dateQuery)
+	}
+}
+
+func dateFieldQuery(name string, value string) elastic.Query {
+	script := elastic.NewScriptInline(dateScript(name)+" == params.dateValue").Lang("painless").Param("dateValue", value)
+	return elastic.NewScriptQuery(script)
+}
+
+// The date where the media was captured, or the date in UTC for media indexed before the local time was recorded
+func dateScript(name string) string {
+	format := ""
+	switch strings.ToLower(name) {
+	case DateYearField:
+		format = "YYYY"
+	case DateMonthField:
+		format = "MMMM"
+	case DateDayField:
+		format = "dd"
+	default:
+		return ""
+	}
+	return "(doc['localdatetime'].empty ? doc['datetime'] : doc['localdatetime']).date.toString('" + format + "')"
+}
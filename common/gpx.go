@@ -0,0 +1,16 @@
+package common
+
+import (
+	"path"
+)
+
+// Where a media's location came from
+const (
+	LocationSourceExif = "exif" // The file or its sidecar
+	LocationSourceGpx  = "gpx"  // Interpolated from a GPX track log, by the capture time
+)
+
+// The folder GPX track logs are read from (the indexer) and uploaded to (the server)
+func DefaultGpxDirectory() string {
+	return path.Join(LocationCacheDirectory, "gpx")
+}
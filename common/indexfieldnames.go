@@ -5,30 +5,44 @@ import (
 )
 
 var fieldsOverride = map[string]string{
+	"artist":              "artist.value",
+	"audiocodec":          "audiocodec.value",
 	"cameramake":          "cameramake.value",
 	"cameramodel":         "cameramodel.value",
+	"cameraserialnumber":  "cameraserialnumber.value",
 	"cityname":            "cityname.value",
+	"container":           "container.value",
+	"copyright":           "copyright.value",
 	"countrycode":         "countrycode.value",
 	"countryname":         "countryname.value",
 	"dayname":             "dayname.value",
+	"description":         "description.value",
 	"displayname":         "displayname.value",
 	"exposureprogram":     "exposureprogram.value",
 	"exposuretimestring":  "exposuretimestring.value",
 	"filename":            "filename.value",
 	"flash":               "flash.value",
+	"frameratename":       "frameratename.value",
 	"hierarchicalname":    "hierarchicalname.value",
 	"keywords":            "keywords.value",
 	"lensinfo":            "lensinfo.value",
 	"lensmodel":           "lensmodel.value",
+	"lensserialnumber":    "lensserialnumber.value",
 	"mimetype":            "mimetype.value",
 	"monthname":           "monthname.value",
+	"orientation":         "orientation.value",
 	"originalcameramake":  "originalcameramake.value",
 	"originalcameramodel": "originalcameramodel.value",
 	"path":                "path.value",
 	"placename":           "placename.value",
 	"sitename":            "sitename.value",
+	"software":            "software.value",
 	"statename":           "statename.value",
 	"tags":                "tags.value",
+	"title":               "title.value",
+	"videocodec":          "videocodec.value",
+	"videoresolution":     "videoresolution.value",
+	"warningcode":         "warningentries.code",
 	"warnings":            "warnings.value",
 	"whitebalance":        "whitebalance.value",
 }
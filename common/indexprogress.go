@@ -0,0 +1,26 @@
+package common
+
+const IndexProgressType = "progress"
+
+// Written by the indexer as a single line of JSON to stdout, read by the server to
+// report on the current run. Other stdout content (logging) is ignored by the reader.
+type IndexProgress struct {
+	Type           string  `json:"type"`
+	Alias          string  `json:"alias"`
+	Path           string  `json:"path"`
+	Completed      bool    `json:"completed"`
+	ScanCompleted  bool    `json:"scanCompleted"`
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+	EtaSeconds     float64 `json:"etaSeconds,omitempty"`
+
+	DirectoriesScanned  int64 `json:"directoriesScanned"`
+	FilesScanned        int64 `json:"filesScanned"`
+	SupportedFilesFound int64 `json:"supportedFilesFound"`
+	FilesChecked        int64 `json:"filesChecked"`
+	FilesIndexed        int64 `json:"filesIndexed"`
+	DuplicatesIgnored   int64 `json:"duplicatesIgnored"`
+	MediaRemoved        int64 `json:"mediaRemoved"`
+
+	Failures    map[string]int64 `json:"failures"`
+	QueueDepths map[string]int   `json:"queueDepths"`
+}
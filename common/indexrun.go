@@ -0,0 +1,64 @@
+package common
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+var IndexRunIndexName = "fp-index-runs"
+
+const IndexRunTypeName = "run"
+
+// The most errors kept for a single run; the rest are only counted
+const MaxIndexRunErrors = 500
+
+// The statistics of a single indexer run. In watch mode, the run lasts until the indexer stops and
+// covers every watched root, listed in Aliases & Paths rather than Alias & Path.
+// Counters are grouped by step (scanner, checkindex, ...), and are only for this run.
+// A run is stored, Active, when it starts - and stored again when it ends.
+type IndexRun struct {
+	Alias           string                      `json:"alias"`
+	Path            string                      `json:"path"`
+	Aliases         []string                    `json:"aliases,omitempty"`
+	Paths           []string                    `json:"paths,omitempty"`
+	Watch           bool                        `json:"watch"`
+	Active          bool                        `json:"active"`
+	StartTime       time.Time                   `json:"starttime"`
+	EndTime         time.Time                   `json:"endtime"`
+	DurationSeconds float64                     `json:"durationseconds"`
+	Counters        map[string]map[string]int64 `json:"counters"`
+	Errors          []string                    `json:"errors"`
+	ErrorCount      int64                       `json:"errorcount"`
+}
+
+// Replaces the run with the given id, or adds it if 'id' is empty. Returns the id of the run
+func StoreIndexRun(client *elastic.Client, id string, run *IndexRun) (string, error) {
+	service := client.Index().
+		Index(IndexRunIndexName).
+		Type(IndexRunTypeName).
+		BodyJson(run)
+	if len(id) > 0 {
+		service = service.Id(id)
+	}
+
+	response, err := service.Do(context.TODO())
+	if err != nil {
+		return "", err
+	}
+	return response.Id, nil
+}
+
+// The number of active runs covering the alias. A run that was killed, rather than stopped, is
+// left active
+func ActiveIndexRuns(client *elastic.Client, alias string) (int64, error) {
+	return client.Count(IndexRunIndexName).
+		Type(IndexRunTypeName).
+		Query(elastic.NewBoolQuery().
+			Filter(elastic.NewTermQuery("active", true)).
+			Should(elastic.NewTermQuery("alias", alias)).
+			Should(elastic.NewTermQuery("aliases", alias)).
+			MinimumNumberShouldMatch(1)).
+		Do(context.TODO())
+}
@@ -1,10 +1,13 @@
 package common
 
 import (
+	"fmt"
 	"path"
+	"sync"
 
 	"github.com/ian-kent/go-log/appenders"
 	"github.com/ian-kent/go-log/layout"
+	"github.com/ian-kent/go-log/levels"
 	"github.com/ian-kent/go-log/log"
 )
 
@@ -30,3 +33,53 @@ func ConfigureLogging(logDirectory, appName string) {
 
 	logger.SetAppender(appenders.Multiple(lyt, rolling, console))
 }
+
+// Keeps the errors that are logged, so they can be reported elsewhere
+type ErrorCollector struct {
+	maxErrors int
+	lock      sync.Mutex
+	errors    []string
+	count     int64
+	layout    layout.Layout
+}
+
+// Add an appender that keeps (up to 'maxErrors') error messages, in addition to the existing appenders
+func CollectLoggedErrors(maxErrors int) *ErrorCollector {
+	collector := &ErrorCollector{maxErrors: maxErrors, errors: make([]string, 0), layout: layout.Basic()}
+	logger := log.Logger("")
+	logger.SetAppender(appenders.Multiple(logger.Appender().Layout(), logger.Appender(), collector))
+	return collector
+}
+
+func (ec *ErrorCollector) Write(level levels.LogLevel, message string, args ...interface{}) {
+	if level != levels.ERROR && level != levels.FATAL {
+		return
+	}
+
+	ec.lock.Lock()
+	defer ec.lock.Unlock()
+
+	ec.count++
+	if len(ec.errors) < ec.maxErrors {
+		ec.errors = append(ec.errors, fmt.Sprintf(message, args...))
+	}
+}
+
+func (ec *ErrorCollector) Layout() layout.Layout {
+	return ec.layout
+}
+
+func (ec *ErrorCollector) SetLayout(layout layout.Layout) {
+	ec.layout = layout
+}
+
+// Returns the errors kept and the total number of errors logged, then starts over
+func (ec *ErrorCollector) Reset() ([]string, int64) {
+	ec.lock.Lock()
+	defer ec.lock.Unlock()
+
+	errors, count := ec.errors, ec.count
+	ec.errors = make([]string, 0)
+	ec.count = 0
+	return errors, count
+}
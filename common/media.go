@@ -1,7 +1,6 @@
 package common
 
 import (
-	"strings"
 	"time"
 )
 
@@ -19,23 +18,12 @@ const (
 )
 
 func (m *Media) MediaType() string {
-	var mediaType = strings.Split(m.MimeType, "/")
-	if len(mediaType) < 1 {
-		return MediaTypeUnknown
-	}
-
-	switch strings.ToLower(mediaType[0]) {
-	case "video":
-		return MediaTypeVideo
-	case "image":
-		return MediaTypeImage
-	default:
-		return MediaTypeUnknown
-	}
+	return MediaTypeOf(m.MimeType, m.Filename)
 }
 
 type Media struct {
 	Signature     string `json:"signature"`
+	FullSignature string `json:"fullsignature,omitempty"` // Of the entire file; 'signature' covers only the start
 	Filename      string `json:"filename"`
 	Path          string `json:"path"`
 	LengthInBytes int64  `json:"lengthinbytes"`
@@ -44,6 +32,24 @@ type Media struct {
 	Width           int     `json:"width,omitempty"`
 	Height          int     `json:"height,omitempty"`
 	DurationSeconds float32 `json:"durationseconds,omitempty"`
+	PerceptualHash  string  `json:"perceptualhash,omitempty"` // dHash of the thumbnail, for finding near-duplicates
+
+	// Video details, from ffprobe
+	Container       string  `json:"container,omitempty"` // 'mp4', 'mov', 'avi'...
+	VideoCodec      string  `json:"videocodec,omitempty"`
+	VideoResolution string  `json:"videoresolution,omitempty"` // '4K', '1080p'... by the short side
+	FrameRate       float32 `json:"framerate,omitempty"`
+	FrameRateName   string  `json:"frameratename,omitempty"` // Rounded, for grouping: '60 fps'
+	BitRate         int64   `json:"bitrate,omitempty"`       // Bits per second, for the whole file
+	Rotation        int     `json:"rotation,omitempty"`      // Degrees clockwise
+	AudioCodec      string  `json:"audiocodec,omitempty"`
+	AudioChannels   int     `json:"audiochannels,omitempty"`
+
+	// From the XMP sidecar, if there is one, otherwise embedded
+	SidecarSignature string `json:"sidecarsignature,omitempty"` // Changes to the sidecar alone cause the media to be re-indexed
+	Rating           int    `json:"rating,omitempty"`
+	Title            string `json:"title,omitempty"`
+	Description      string `json:"description,omitempty"`
 
 	// EXIF info
 	ApertureValue       float32 `json:"aperture,omitempty"`
@@ -61,6 +67,12 @@ type Media struct {
 	CameraModel         string  `json:"cameramodel,omitempty"`
 	OriginalCameraMake  string  `json:"originalcameramake,omitempty"`
 	OriginalCameraModel string  `json:"originalcameramodel,omitempty"`
+	CameraSerialNumber  string  `json:"cameraserialnumber,omitempty"`
+	LensSerialNumber    string  `json:"lensserialnumber,omitempty"`
+	Orientation         string  `json:"orientation,omitempty"` // As exiftool describes it, such as 'Rotate 90 CW'
+	Artist              string  `json:"artist,omitempty"`
+	Copyright           string  `json:"copyright,omitempty"`
+	Software            string  `json:"software,omitempty"`
 
 	// For arrays - see here for mappings & searching: http://stackoverflow.com/questions/26258292/querystring-search-on-array-elements-in-elastic-search
 	Keywords []string `json:"keywords,omitempty"`
@@ -69,7 +81,10 @@ type Media struct {
 	Tags *[]string `json:"tags,omitempty"`
 
 	// Location
-	Location *GeoPoint `json:"location,omitempty"`
+	Location       *GeoPoint `json:"location,omitempty"`
+	Altitude       *float64  `json:"altitude,omitempty"`       // Meters, negative for below sea level
+	ImageDirection *float64  `json:"imagedirection,omitempty"` // Degrees; 0 is north, which is why these are pointers
+	LocationSource string    `json:"locationsource,omitempty"` // Where the location came from, one of the LocationSource constants
 
 	// Placename, from the reverse coding of the location
 	LocationCountryName          string `json:"countryname,omitempty"`
@@ -83,13 +98,19 @@ type Media struct {
 	CachedLocationDistanceMeters int    `json:"cachedlocationdistancemeters,omitempty"` // # of meters away from stored location the placename came from (due to using caching server)
 
 	// Date related fields
-	DateTime  time.Time `json:"datetime"`  // 2009-06-15T13:45:30.0000000-07:00 'round trip pattern'
+	DateTime  time.Time `json:"datetime"`  // 2009-06-15T13:45:30.0000000-07:00 'round trip pattern', in the time zone it was captured in
 	Date      string    `json:"date"`      // yyyyMMdd - for aggregating by date
 	DayName   string    `json:"dayname"`   // (Wed, Wednesday)
 	MonthName string    `json:"monthname"` // (Apr, April)
 	DayOfYear int       `json:"dayofyear"` // Index of the day in the year, to help with byday searches (1-366; Jan/1 = 1, Feb/29 =60, Mar/1 = 61)
 
-	Warnings []string `json:"warnings,omitempty"`
+	LocalDateTime  string    `json:"localdatetime"`  // 2009-06-15T13:45:30 - the wall-clock time where it was captured
+	UTCDateTime    time.Time `json:"utcdatetime"`    // The same instant as DateTime, in UTC
+	TimeZone       string    `json:"timezone"`       // 'Europe/Paris' if known, otherwise the offset ('+02:00')
+	TimeZoneMethod string    `json:"timezonemethod"` // How the time zone was decided, one of the TimeZoneMethod constants
+
+	Warnings       []Warning `json:"warningentries,omitempty"`
+	LegacyWarnings []string  `json:"warnings,omitempty"` // Messages from before warnings had codes; see MigrateLegacyWarnings
 }
 
 type GeoPoint struct {
@@ -98,12 +119,16 @@ type GeoPoint struct {
 }
 
 type CandidateFile struct {
-	FullPath      string
-	AliasedPath   string
-	Signature     string
-	LengthInBytes int64
-	Exif          ExifOutput
-	Warnings      []string
+	FullPath         string
+	AliasedPath      string
+	Signature        string
+	FullSignature    string
+	LengthInBytes    int64
+	SidecarPath      string // Empty if there's no XMP sidecar
+	SidecarSignature string
+	Watched          bool // Changed while watching, rather than found by a scan
+	Exif             ExifOutput
+	Warnings         []Warning
 }
 
 type ExifOutput struct {
@@ -137,12 +162,26 @@ type ExifOutputExif struct {
 	GPSLatitude      string
 	GPSLongitudeRef  string
 	GPSLongitude     string
+	GPSAltitudeRef   string
+	GPSAltitude      interface{} // "52.3 m", or a number
+	GPSImgDirection  interface{}
 	ISO              interface{} // Most cameras use an int, some a string (!)
 	LensInfo         string
 	LensModel        string
+	LensSerialNumber interface{} // Serial numbers of only digits come back as numbers
 	Make             string
 	Model            string
+	SerialNumber     interface{}
+	Orientation      string
+	Artist           string
+	Copyright        string
+	Software         string
+	ImageDescription string
 	WhiteBalance     string
+
+	OffsetTime          string // The time zone offsets ("+02:00") for ModifyDate, DateTimeOriginal & CreateDate
+	OffsetTimeOriginal  string
+	OffsetTimeDigitized string
 }
 
 type ExifOutputQuicktime struct {
@@ -155,19 +194,33 @@ type ExifOutputQuicktime struct {
 }
 
 type ExifOutputXmp struct {
-	Subject interface{} // Some are []string - others are string. Exiftool seems to be the source
-	Make    string
-	Model   string
+	Subject      interface{} // Some are []string - others are string. Exiftool seems to be the source
+	Keywords     interface{}
+	Make         string
+	Model        string
+	Rating       interface{} // Numbers and strings are returned as-is by exiftool; titles can be numbers
+	Title        interface{}
+	Description  interface{}
+	Creator      interface{}
+	Rights       interface{}
+	GPSLatitude  string
+	GPSLongitude string
 }
 
 type ExifOutputComposite struct {
 	GPSPosition string
+	GPSAltitude interface{} // "52.3 m Above Sea Level"
 }
 
 type ExifOutputIptc struct {
-	Keywords interface{} // Some are []string - others are string. Exiftool seems to be the source
+	Keywords        interface{} // Some are []string - others are string. Exiftool seems to be the source
+	ObjectName      interface{}
+	CaptionAbstract interface{} `json:"Caption-Abstract"`
+	ByLine          interface{} `json:"By-line"`
+	CopyrightNotice interface{}
 }
 
-func (cf *CandidateFile) AddWarning(warning string) {
-	cf.Warnings = append(cf.Warnings, warning)
+// The params are name, value pairs
+func (cf *CandidateFile) AddWarning(code, message string, params ...string) {
+	cf.Warnings = append(cf.Warnings, NewWarning(code, message, params...))
 }
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// The file extensions indexed by default (upper case, with the leading '.') and the media type of each
+var DefaultSupportedExtensions = map[string]string{
+	".BMP":  MediaTypeImage,
+	".GIF":  MediaTypeImage,
+	".JPEG": MediaTypeImage,
+	".JPG":  MediaTypeImage,
+	".PNG":  MediaTypeImage,
+	".TIF":  MediaTypeImage,
+	".TIFF": MediaTypeImage,
+
+	".HEIC": MediaTypeImage,
+	".HEIF": MediaTypeImage,
+
+	".ARW": MediaTypeImage,
+	".CR2": MediaTypeImage,
+	".CR3": MediaTypeImage,
+	".DNG": MediaTypeImage,
+	".NEF": MediaTypeImage,
+	".ORF": MediaTypeImage,
+	".RAF": MediaTypeImage,
+	".RW2": MediaTypeImage,
+
+	".M4V": MediaTypeVideo,
+	".MOV": MediaTypeVideo,
+	".MP4": MediaTypeVideo,
+}
+
+// Raw and HEIC images often can't be decoded by vips (or Go), but contain a JPEG preview
+var embeddedPreviewExtensions = map[string]bool{
+	".ARW":  true,
+	".CR2":  true,
+	".CR3":  true,
+	".DNG":  true,
+	".HEIC": true,
+	".HEIF": true,
+	".NEF":  true,
+	".ORF":  true,
+	".RAF":  true,
+	".RW2":  true,
+}
+
+// Largest first - the first one found is used
+var embeddedPreviewTags = []string{"JpgFromRaw", "PreviewImage", "OtherImage", "ThumbnailImage"}
+
+// The media type from the mime type, falling back to the file extension for the
+// mime types exiftool doesn't classify as image or video
+func MediaTypeOf(mimeType, filename string) string {
+	switch strings.ToLower(strings.Split(mimeType, "/")[0]) {
+	case "video":
+		return MediaTypeVideo
+	case "image":
+		return MediaTypeImage
+	}
+
+	if mediaType, ok := DefaultSupportedExtensions[strings.ToUpper(path.Ext(filename))]; ok {
+		return mediaType
+	}
+	return MediaTypeUnknown
+}
+
+func HasEmbeddedPreview(filename string) bool {
+	_, ok := embeddedPreviewExtensions[strings.ToUpper(path.Ext(filename))]
+	return ok
+}
+
+// Write the largest embedded JPEG preview from the image to 'previewFilename', using exiftool
+func ExtractEmbeddedPreview(imageFilename, previewFilename string) error {
+	for _, tag := range embeddedPreviewTags {
+		out, err := exec.Command(ExifToolPath, "-b", "-"+tag, imageFilename).Output()
+		if err == nil && len(out) > 0 {
+			return ioutil.WriteFile(previewFilename, out, 0644)
+		}
+	}
+
+	return fmt.Errorf("No embedded preview found in '%s'", imageFilename)
+}
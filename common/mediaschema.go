@@ -0,0 +1,133 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ian-kent/go-log/log"
+	"golang.org/x/net/context"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// The version of the media index mapping. When the mapping changes, increment this and add any
+// transforms the existing documents need to MediaFieldTransforms; indexes are moved to the new
+// version with the indexer's '--migrate-index'
+const MediaSchemaVersion = 1
+
+// A change made to each media document as it's copied to an index of a newer version
+type FieldTransform struct {
+	Field  string
+	Script string // Painless, evaluating to the new value; 'ctx._source' is the document. Empty removes the field
+}
+
+// The transforms for migrating to each version, from the one before it
+var MediaFieldTransforms = map[int][]FieldTransform{}
+
+// The concrete index the media index was read from, and its schema version. Indexes created before
+// schema versions were recorded are version 0, and aren't behind an alias
+type MediaIndexInfo struct {
+	Index   string
+	Version int
+	IsAlias bool
+}
+
+func VersionedMediaIndexName(version int) string {
+	return fmt.Sprintf("%s-v%d", MediaIndexName, version)
+}
+
+func GetMediaIndexInfo(client *elastic.Client) (*MediaIndexInfo, error) {
+	results, err := client.GetMapping().
+		Index(MediaIndexName).
+		Type(MediaTypeName).
+		Do(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("'%s' refers to %d indexes, rather than one", MediaIndexName, len(results))
+	}
+
+	info := &MediaIndexInfo{}
+	for index, value := range results {
+		info.Index = index
+		info.IsAlias = index != MediaIndexName
+		info.Version = schemaVersionOf(value)
+	}
+	return info, nil
+}
+
+// Logs a warning when the index isn't the current schema version
+func CheckMediaIndexVersion(client *elastic.Client) {
+	info, err := GetMediaIndexInfo(client)
+	if err != nil {
+		log.Error("Unable to get the schema version of '%s': %s", MediaIndexName, err.Error())
+		return
+	}
+
+	if info.Version != MediaSchemaVersion {
+		log.Warn("The index '%s' (%s) is schema version %d, version %d is expected; run the indexer with --migrate-index",
+			MediaIndexName, info.Index, info.Version, MediaSchemaVersion)
+	} else if !info.IsAlias {
+		log.Warn("The index '%s' isn't an alias; run the indexer with --migrate-index", MediaIndexName)
+	}
+}
+
+// The painless script applying the transforms to media documents, empty if there are none
+func FieldTransformScript(transforms []FieldTransform) string {
+	if len(transforms) == 0 {
+		return ""
+	}
+
+	statements := make([]string, 0, len(transforms))
+	for _, t := range transforms {
+		field := strings.Replace(t.Field, "'", "\\'", -1)
+		if t.Script == "" {
+			statements = append(statements, fmt.Sprintf("ctx._source.remove('%s');", field))
+		} else {
+			statements = append(statements, fmt.Sprintf("ctx._source['%s'] = (%s);", field, t.Script))
+		}
+	}
+	return fmt.Sprintf("if (ctx._type == '%s') { %s }", MediaTypeName, strings.Join(statements, " "))
+}
+
+// The transforms for migrating from one version to another, in version order
+func MediaFieldTransformsBetween(from int, to int) []FieldTransform {
+	versions := make([]int, 0)
+	for version := range MediaFieldTransforms {
+		if version > from && version <= to {
+			versions = append(versions, version)
+		}
+	}
+	sort.Ints(versions)
+
+	transforms := make([]FieldTransform, 0)
+	for _, version := range versions {
+		transforms = append(transforms, MediaFieldTransforms[version]...)
+	}
+	return transforms
+}
+
+func schemaVersionOf(indexMapping interface{}) int {
+	index, ok := indexMapping.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	mappings, ok := index["mappings"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	media, ok := mappings[MediaTypeName].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	meta, ok := media["_meta"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	version, ok := meta["schemaversion"].(float64)
+	if !ok {
+		return 0
+	}
+	return int(version)
+}
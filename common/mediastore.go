@@ -0,0 +1,168 @@
+package common
+
+import (
+	"strings"
+	"time"
+)
+
+// Where media documents are kept and searched. Elasticsearch is the store; the in-memory one
+// lets searches, and the API, be tested without it
+type MediaStore interface {
+	// Returns nil, without an error, if there's no media with the id
+	GetMedia(id string) (*Media, error)
+	Search(options *MediaSearchOptions) (*MediaSearchResult, error)
+	IndexMedia(id string, media *Media) error
+
+	// Changes only the given fields of the document; a nil value removes the field
+	UpdateMedia(id string, fields map[string]interface{}) error
+	DeleteMedia(id string) error
+
+	// Returns the number of media removed
+	DeleteMatching(query *MediaQuery) (int64, error)
+
+	// Calls the callback with each page of the media matching the query, until there are no more
+	// or the callback returns false. Only the given fields of the media are returned; all of them
+	// if there are none
+	Scroll(query *MediaQuery, fields []string, pageSize int, callback func(hits []*MediaSearchHit) bool) error
+
+	// The files the indexer skipped as duplicates of indexed media, along with the total number
+	Duplicates(from int, size int) ([]*DuplicateItem, int64, error)
+}
+
+var ActiveMediaStore MediaStore = NewElasticMediaStore()
+
+// The media a search matches; every condition given must match
+type MediaQuery struct {
+	// Query string syntax, matched against TextFields; empty or '*' matches everything
+	Text       string
+	TextFields []string
+
+	// Media captured on a day of the year (1-366) in the range, inclusive; zero is unbounded
+	DayOfYearFrom int
+	DayOfYearTo   int
+
+	// Media within the distance of the point
+	Near *GeoDistance
+
+	// Media with a value for each of the fields
+	Exists []string
+
+	// Only the media with these ids, if any are given
+	Ids []string
+
+	// Media whose path ('alias\folder\name') starts with the prefix
+	PathPrefix string
+
+	// Field values to narrow the media to, as given to the API. Location fields (countryName,
+	// stateName, cityName, siteName) are ORed together, as are the date fields (dateYear, dateMonth,
+	// dateDay); the values of any other field are ORed, and each of those sets is ANDed. Fields joined
+	// with '~' ('countryName~stateName') are a hierarchy, with values joined the same way
+	Drilldown map[string][]string
+}
+
+type GeoDistance struct {
+	Latitude   float64
+	Longitude  float64
+	Kilometers float64
+}
+
+const (
+	SortNone = iota
+	SortNewestFirst
+	SortDayOfYearAscending
+	SortDayOfYearDescending
+	SortNearestFirst // From Query.Near
+	SortRandom
+)
+
+type MediaSearchOptions struct {
+	Query        MediaQuery
+	From         int
+	Size         int
+	Sort         int
+	Aggregations []*TermsAggregation
+}
+
+// Counts the media by the values of a field, most common first. Field is an index field name, such
+// as 'keywords.value', or one of the capture date fields: dateyear, datemonth & dateday
+type TermsAggregation struct {
+	Name           string
+	Field          string
+	Size           int
+	SubAggregation *TermsAggregation
+}
+
+type MediaSearchResult struct {
+	TotalHits    int64
+	Hits         []*MediaSearchHit
+	Aggregations map[string][]*TermsBucket
+}
+
+type MediaSearchHit struct {
+	Id         string
+	Media      *Media
+	DistanceKm *float64 // Only when sorted by distance
+}
+
+type TermsBucket struct {
+	Key                interface{} // A string, or a float64 for numeric fields
+	Count              int64
+	SubAggregationName string
+	SubBuckets         []*TermsBucket
+}
+
+// The fields the capture date is drilled into & aggregated by
+const (
+	DateYearField  = "dateyear"
+	DateMonthField = "datemonth"
+	DateDayField   = "dateday"
+)
+
+func IsDrilldownLocationField(name string) bool {
+	switch strings.ToLower(name) {
+	case "countryname", "statename", "cityname", "sitename":
+		return true
+	default:
+		return false
+	}
+}
+
+func IsDrilldownDateField(name string) bool {
+	switch strings.ToLower(name) {
+	case DateYearField, DateMonthField, DateDayField:
+		return true
+	default:
+		return false
+	}
+}
+
+func DrilldownLocationFieldName(name string) string {
+	return strings.ToLower(name + ".value")
+}
+
+// A hierarchical value missing a level matches nothing at that level
+func drilldownValue(valueGroup []string, index int) string {
+	if index < len(valueGroup) {
+		return valueGroup[index]
+	}
+	return ""
+}
+
+// The value of the capture date field. As with Elasticsearch, it's the date where the media was
+// captured, or the date in UTC for media indexed before the local time was recorded
+func DateFieldValue(name string, media *Media) string {
+	dateTime := media.DateTime.UTC()
+	if localDateTime, err := time.Parse("2006-01-02T15:04:05", media.LocalDateTime); err == nil {
+		dateTime = localDateTime
+	}
+
+	switch strings.ToLower(name) {
+	case DateYearField:
+		return dateTime.Format("2006")
+	case DateMonthField:
+		return dateTime.Format("January")
+	case DateDayField:
+		return dateTime.Format("02")
+	}
+	return ""
+}
@@ -0,0 +1,541 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Media kept in memory, searched with the same semantics as the Elasticsearch store - for tests
+type MemoryMediaStore struct {
+	lock       sync.RWMutex
+	documents  map[string]*memoryDocument
+	duplicates []*DuplicateItem
+}
+
+type memoryDocument struct {
+	id     string
+	media  *Media
+	fields map[string]interface{} // The media as it's indexed
+}
+
+// The mean radius Elasticsearch uses for distances
+const earthRadiusKm = 6371.0088
+
+func NewMemoryMediaStore() *MemoryMediaStore {
+	return &MemoryMediaStore{documents: make(map[string]*memoryDocument)}
+}
+
+func (ms *MemoryMediaStore) GetMedia(id string) (*Media, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+	doc, ok := ms.documents[id]
+	if !ok {
+		return nil, nil
+	}
+	return doc.copyMedia()
+}
+
+func (ms *MemoryMediaStore) Search(options *MediaSearchOptions) (*MediaSearchResult, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	matches := ms.matching(&options.Query)
+	sortDocuments(matches, options.Sort, options.Query.Near)
+
+	result := &MediaSearchResult{TotalHits: int64(len(matches)), Aggregations: make(map[string][]*TermsBucket)}
+	for _, aggregation := range options.Aggregations {
+		result.Aggregations[aggregation.Name] = aggregate(matches, aggregation)
+	}
+
+	for index := options.From; index < len(matches) && index < options.From+options.Size; index++ {
+		hit, err := matches[index].toHit()
+		if err != nil {
+			return nil, err
+		}
+		if options.Sort == SortNearestFirst && options.Query.Near != nil && hit.Media.Location != nil {
+			distance := distanceKm(options.Query.Near.Latitude, options.Query.Near.Longitude, hit.Media.Location)
+			hit.DistanceKm = &distance
+		}
+		result.Hits = append(result.Hits, hit)
+	}
+	return result, nil
+}
+
+func (ms *MemoryMediaStore) IndexMedia(id string, media *Media) error {
+	doc := &memoryDocument{id: id}
+	err := doc.setFields(media)
+	if err != nil {
+		return err
+	}
+
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ms.documents[id] = doc
+	return nil
+}
+
+func (ms *MemoryMediaStore) UpdateMedia(id string, fields map[string]interface{}) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	doc, ok := ms.documents[id]
+	if !ok {
+		return fmt.Errorf("No media with the id '%s'", id)
+	}
+
+	// The fields are merged as they would be indexed
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	changes := make(map[string]interface{})
+	err = json.Unmarshal(data, &changes)
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[string]interface{})
+	for name, value := range doc.fields {
+		merged[name] = value
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = value
+		}
+	}
+
+	updated := &memoryDocument{id: id}
+	err = updated.setFields(merged)
+	if err != nil {
+		return err
+	}
+	ms.documents[id] = updated
+	return nil
+}
+
+func (ms *MemoryMediaStore) DeleteMedia(id string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	if _, ok := ms.documents[id]; !ok {
+		return fmt.Errorf("No media with the id '%s'", id)
+	}
+	delete(ms.documents, id)
+	return nil
+}
+
+func (ms *MemoryMediaStore) DeleteMatching(query *MediaQuery) (int64, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	matches := ms.matching(query)
+	for _, doc := range matches {
+		delete(ms.documents, doc.id)
+	}
+	return int64(len(matches)), nil
+}
+
+// Pages are in id order
+func (ms *MemoryMediaStore) Scroll(query *MediaQuery, fields []string, pageSize int, callback func(hits []*MediaSearchHit) bool) error {
+	if pageSize < 1 {
+		return fmt.Errorf("The page size must be at least 1, not %d", pageSize)
+	}
+
+	ms.lock.RLock()
+	matches := ms.matching(query)
+	ms.lock.RUnlock()
+
+	for start := 0; start < len(matches); start += pageSize {
+		hits := make([]*MediaSearchHit, 0, pageSize)
+		for index := start; index < len(matches) && index < start+pageSize; index++ {
+			hit, err := matches[index].toPartialHit(fields)
+			if err != nil {
+				return err
+			}
+			hits = append(hits, hit)
+		}
+		if !callback(hits) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// The duplicates are in the order they were added
+func (ms *MemoryMediaStore) Duplicates(from int, size int) ([]*DuplicateItem, int64, error) {
+	ms.lock.RLock()
+	defer ms.lock.RUnlock()
+
+	duplicates := make([]*DuplicateItem, 0)
+	for index := from; index < len(ms.duplicates) && index < from+size; index++ {
+		di := *ms.duplicates[index]
+		duplicates = append(duplicates, &di)
+	}
+	return duplicates, int64(len(ms.duplicates)), nil
+}
+
+// The indexer adds duplicates as it finds them; this is for tests
+func (ms *MemoryMediaStore) AddDuplicate(item *DuplicateItem) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	di := *item
+	ms.duplicates = append(ms.duplicates, &di)
+}
+
+// In id order
+func (ms *MemoryMediaStore) matching(query *MediaQuery) []*memoryDocument {
+	text := parseQueryString(query.Text)
+	matches := make([]*memoryDocument, 0)
+	for _, doc := range ms.documents {
+		if doc.matches(query, text) {
+			matches = append(matches, doc)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].id < matches[j].id })
+	return matches
+}
+
+func (doc *memoryDocument) setFields(source interface{}) error {
+	data, err := json.Marshal(source)
+	if err != nil {
+		return err
+	}
+
+	doc.fields = make(map[string]interface{})
+	err = json.Unmarshal(data, &doc.fields)
+	if err != nil {
+		return err
+	}
+	doc.media = &Media{}
+	return json.Unmarshal(data, doc.media)
+}
+
+// Callers get their own copy, so changing it doesn't change the store
+func (doc *memoryDocument) copyMedia() (*Media, error) {
+	data, err := json.Marshal(doc.fields)
+	if err != nil {
+		return nil, err
+	}
+	media := &Media{}
+	err = json.Unmarshal(data, media)
+	if err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// Only the given top level fields, or all of them if there are none
+func (doc *memoryDocument) toPartialHit(fields []string) (*MediaSearchHit, error) {
+	if len(fields) < 1 {
+		return doc.toHit()
+	}
+
+	partial := make(map[string]interface{})
+	for _, name := range fields {
+		if value, ok := doc.fields[strings.ToLower(name)]; ok {
+			partial[strings.ToLower(name)] = value
+		}
+	}
+	data, err := json.Marshal(partial)
+	if err != nil {
+		return nil, err
+	}
+	hit := &MediaSearchHit{Id: doc.id, Media: &Media{}}
+	err = json.Unmarshal(data, hit.Media)
+	if err != nil {
+		return nil, err
+	}
+	return hit, nil
+}
+
+func (doc *memoryDocument) toHit() (*MediaSearchHit, error) {
+	media, err := doc.copyMedia()
+	if err != nil {
+		return nil, err
+	}
+	return &MediaSearchHit{Id: doc.id, Media: media}, nil
+}
+
+func (doc *memoryDocument) matches(query *MediaQuery, text *queryString) bool {
+	if !text.matches(doc, query.TextFields) {
+		return false
+	}
+
+	if query.DayOfYearFrom > 0 && doc.media.DayOfYear < query.DayOfYearFrom {
+		return false
+	}
+	if query.DayOfYearTo > 0 && doc.media.DayOfYear > query.DayOfYearTo {
+		return false
+	}
+
+	if query.Near != nil {
+		if doc.media.Location == nil {
+			return false
+		}
+		if distanceKm(query.Near.Latitude, query.Near.Longitude, doc.media.Location) > query.Near.Kilometers {
+			return false
+		}
+	}
+
+	for _, field := range query.Exists {
+		if len(doc.values(field)) < 1 {
+			return false
+		}
+	}
+
+	if len(query.Ids) > 0 {
+		found := false
+		for _, id := range query.Ids {
+			found = found || id == doc.id
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if query.PathPrefix != "" && !strings.HasPrefix(doc.media.Path, query.PathPrefix) {
+		return false
+	}
+
+	return doc.matchesDrilldown(query.Drilldown)
+}
+
+// As with Elasticsearch: locations are ORed, dates are ORed, the values of each other field are
+// ORed, and those sets are ANDed
+func (doc *memoryDocument) matchesDrilldown(drilldown map[string][]string) bool {
+	hasLocation, locationMatched := false, false
+	hasDate, dateMatched := false, false
+
+	for key, values := range drilldown {
+		fieldName := key
+		keyGroup := strings.Split(key, "~")
+		isHierarchical := len(keyGroup) > 1
+		if isHierarchical {
+			fieldName = keyGroup[0]
+		}
+
+		if IsDrilldownLocationField(fieldName) || IsDrilldownDateField(fieldName) {
+			isLocation := IsDrilldownLocationField(fieldName)
+			matched := false
+			for _, value := range values {
+				valueGroup := []string{value}
+				if isHierarchical {
+					valueGroup = strings.Split(value, "~")
+				}
+
+				allMatched := true
+				for index := range keyGroup {
+					if !doc.matchesDrilldownField(keyGroup[index], drilldownValue(valueGroup, index)) {
+						allMatched = false
+						break
+					}
+				}
+				matched = matched || allMatched
+			}
+
+			if isLocation {
+				hasLocation = true
+				locationMatched = locationMatched || matched
+			} else {
+				hasDate = true
+				dateMatched = dateMatched || matched
+			}
+		} else {
+			matched := false
+			for _, value := range values {
+				indexFieldName, overridden := GetIndexFieldName(fieldName)
+				if !overridden {
+					value = strings.ToLower(value)
+				}
+				if doc.hasTerm(indexFieldName, value, overridden) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		}
+	}
+
+	return (!hasLocation || locationMatched) && (!hasDate || dateMatched)
+}
+
+func (doc *memoryDocument) matchesDrilldownField(name string, value string) bool {
+	if IsDrilldownDateField(name) {
+		return DateFieldValue(name, doc.media) == value
+	}
+	return doc.hasTerm(DrilldownLocationFieldName(name), value, true)
+}
+
+// A term matches a keyword field's whole value; for other fields, any of the words in its value
+func (doc *memoryDocument) hasTerm(field string, term string, keyword bool) bool {
+	for _, value := range doc.values(field) {
+		s := valueString(value)
+		if s == term {
+			return true
+		}
+		if !keyword {
+			for _, word := range analyze(s) {
+				if word == term {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// The values of the field, with arrays flattened. The '.value' & '.keyword' sub-fields are the
+// values of the field itself; nested fields are given with a '.' ('warningentries.code')
+func (doc *memoryDocument) values(field string) []interface{} {
+	field = strings.ToLower(field)
+	for _, suffix := range []string{".value", ".keyword"} {
+		if strings.HasSuffix(field, suffix) {
+			field = strings.TrimSuffix(field, suffix)
+			break
+		}
+	}
+
+	values := []interface{}{doc.fields}
+	for _, name := range strings.Split(field, ".") {
+		next := make([]interface{}, 0)
+		for _, value := range flatten(values) {
+			if object, ok := value.(map[string]interface{}); ok {
+				if child, ok := object[name]; ok && child != nil {
+					next = append(next, child)
+				}
+			}
+		}
+		values = next
+	}
+	return flatten(values)
+}
+
+func flatten(values []interface{}) []interface{} {
+	flattened := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		if array, ok := value.([]interface{}); ok {
+			flattened = append(flattened, flatten(array)...)
+		} else {
+			flattened = append(flattened, value)
+		}
+	}
+	return flattened
+}
+
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func sortDocuments(docs []*memoryDocument, order int, near *GeoDistance) {
+	switch order {
+	case SortNewestFirst:
+		sort.SliceStable(docs, func(i, j int) bool { return docs[i].media.DateTime.After(docs[j].media.DateTime) })
+	case SortDayOfYearAscending:
+		sort.SliceStable(docs, func(i, j int) bool { return docs[i].media.DayOfYear < docs[j].media.DayOfYear })
+	case SortDayOfYearDescending:
+		sort.SliceStable(docs, func(i, j int) bool { return docs[i].media.DayOfYear > docs[j].media.DayOfYear })
+	case SortNearestFirst:
+		if near != nil {
+			distance := func(doc *memoryDocument) float64 {
+				if doc.media.Location == nil {
+					return math.MaxFloat64
+				}
+				return distanceKm(near.Latitude, near.Longitude, doc.media.Location)
+			}
+			sort.SliceStable(docs, func(i, j int) bool { return distance(docs[i]) < distance(docs[j]) })
+		}
+	case SortRandom:
+		rand.Shuffle(len(docs), func(i, j int) { docs[i], docs[j] = docs[j], docs[i] })
+	}
+}
+
+// The buckets are ordered by count, then by key - as Elasticsearch orders them
+func aggregate(docs []*memoryDocument, aggregation *TermsAggregation) []*TermsBucket {
+	keys := make(map[string]interface{})
+	docsByKey := make(map[string][]*memoryDocument)
+	for _, doc := range docs {
+		seen := make(map[string]bool)
+		for _, key := range doc.aggregationKeys(aggregation.Field) {
+			s := valueString(key)
+			if seen[s] {
+				continue
+			}
+			seen[s] = true
+			keys[s] = key
+			docsByKey[s] = append(docsByKey[s], doc)
+		}
+	}
+
+	buckets := make([]*TermsBucket, 0, len(keys))
+	for s, key := range keys {
+		bucket := &TermsBucket{Key: key, Count: int64(len(docsByKey[s]))}
+		if aggregation.SubAggregation != nil {
+			bucket.SubAggregationName = aggregation.SubAggregation.Name
+			bucket.SubBuckets = aggregate(docsByKey[s], aggregation.SubAggregation)
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Count != buckets[j].Count {
+			return buckets[i].Count > buckets[j].Count
+		}
+		fi, iNumber := buckets[i].Key.(float64)
+		fj, jNumber := buckets[j].Key.(float64)
+		if iNumber && jNumber {
+			return fi < fj
+		}
+		return valueString(buckets[i].Key) < valueString(buckets[j].Key)
+	})
+
+	if aggregation.Size > 0 && len(buckets) > aggregation.Size {
+		buckets = buckets[:aggregation.Size]
+	}
+	return buckets
+}
+
+// Dates are keyed by milliseconds since the epoch, as Elasticsearch keys them
+func (doc *memoryDocument) aggregationKeys(field string) []interface{} {
+	if IsDrilldownDateField(field) {
+		return []interface{}{DateFieldValue(field, doc.media)}
+	}
+
+	values := doc.values(field)
+	switch strings.ToLower(field) {
+	case "datetime", "utcdatetime":
+		keys := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			if t, err := time.Parse(time.RFC3339Nano, valueString(value)); err == nil {
+				keys = append(keys, float64(t.UnixNano()/int64(time.Millisecond)))
+			}
+		}
+		return keys
+	}
+	return values
+}
+
+func distanceKm(latitude float64, longitude float64, location *GeoPoint) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	lat1, lat2 := toRadians(latitude), toRadians(location.Latitude)
+	deltaLat := lat2 - lat1
+	deltaLon := toRadians(location.Longitude - longitude)
+
+	a := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(deltaLon/2)*math.Sin(deltaLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,218 @@
+package common
+
+import (
+	"strings"
+	"unicode"
+)
+
+// The subset of the Elasticsearch query string syntax the in-memory store handles: terms, 'field:term',
+// quoted phrases, a trailing '*' for a prefix, '_exists_:field', '+' & '-' (or AND, OR & NOT) - but not
+// grouping with parentheses. As with Elasticsearch, terms are ORed unless they're required
+type queryString struct {
+	matchAll bool
+	clauses  []*queryClause
+}
+
+const (
+	clauseOptional = iota
+	clauseRequired
+	clauseProhibited
+)
+
+type queryClause struct {
+	occur  int
+	field  string // Empty for the text fields
+	value  string
+	phrase bool
+	prefix bool
+	exists bool
+}
+
+func parseQueryString(text string) *queryString {
+	text = strings.TrimSpace(text)
+	if text == "" || text == "*" {
+		return &queryString{matchAll: true}
+	}
+
+	qs := &queryString{}
+	nextOccur := clauseOptional
+	var previous *queryClause
+	for _, token := range splitQueryString(text) {
+		switch token {
+		case "AND", "&&":
+			if previous != nil && previous.occur == clauseOptional {
+				previous.occur = clauseRequired
+			}
+			nextOccur = clauseRequired
+			continue
+		case "OR", "||":
+			nextOccur = clauseOptional
+			continue
+		case "NOT", "!":
+			nextOccur = clauseProhibited
+			continue
+		}
+
+		clause := &queryClause{occur: nextOccur}
+		if strings.HasPrefix(token, "+") {
+			clause.occur = clauseRequired
+			token = token[1:]
+		} else if strings.HasPrefix(token, "-") || strings.HasPrefix(token, "!") {
+			clause.occur = clauseProhibited
+			token = token[1:]
+		}
+
+		if pos := strings.Index(token, ":"); pos > 0 && !strings.HasPrefix(token, "\"") {
+			clause.field = token[:pos]
+			token = token[pos+1:]
+		}
+		if clause.field == "_exists_" {
+			clause.exists = true
+			clause.field = token
+		} else if len(token) > 1 && strings.HasPrefix(token, "\"") && strings.HasSuffix(token, "\"") {
+			clause.phrase = true
+			clause.value = token[1 : len(token)-1]
+		} else if strings.HasSuffix(token, "*") {
+			clause.prefix = true
+			clause.value = strings.TrimSuffix(token, "*")
+		} else {
+			clause.value = token
+		}
+
+		qs.clauses = append(qs.clauses, clause)
+		previous = clause
+		nextOccur = clauseOptional
+	}
+	return qs
+}
+
+// Splits on whitespace, keeping quoted phrases together
+func splitQueryString(text string) []string {
+	tokens := make([]string, 0)
+	var current []rune
+	inQuotes := false
+	for _, r := range text {
+		if r == '"' {
+			inQuotes = !inQuotes
+		}
+		if unicode.IsSpace(r) && !inQuotes {
+			if len(current) > 0 {
+				tokens = append(tokens, string(current))
+				current = nil
+			}
+			continue
+		}
+		current = append(current, r)
+	}
+	if len(current) > 0 {
+		tokens = append(tokens, string(current))
+	}
+	return tokens
+}
+
+func (qs *queryString) matches(doc *memoryDocument, fields []string) bool {
+	if qs.matchAll {
+		return true
+	}
+
+	hasRequired, anyOptional, hasOptional := false, false, false
+	for _, clause := range qs.clauses {
+		matched := clause.matches(doc, fields)
+		switch clause.occur {
+		case clauseRequired:
+			hasRequired = true
+			if !matched {
+				return false
+			}
+		case clauseProhibited:
+			if matched {
+				return false
+			}
+		default:
+			hasOptional = true
+			anyOptional = anyOptional || matched
+		}
+	}
+	return hasRequired || !hasOptional || anyOptional
+}
+
+func (clause *queryClause) matches(doc *memoryDocument, fields []string) bool {
+	if clause.exists {
+		return len(doc.values(clause.field)) > 0
+	}
+	if clause.field != "" {
+		fields = []string{clause.field}
+	} else if len(fields) == 0 {
+		for name := range doc.fields {
+			fields = append(fields, name)
+		}
+	}
+	if clause.value == "" && clause.prefix {
+		for _, field := range fields {
+			if len(doc.values(field)) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, field := range fields {
+		for _, value := range doc.values(field) {
+			if clause.matchesValue(valueString(value), isKeywordField(field)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Keyword fields match their whole value; others match the words in it, as analyzed
+func (clause *queryClause) matchesValue(value string, keyword bool) bool {
+	if keyword {
+		if clause.prefix {
+			return strings.HasPrefix(value, clause.value)
+		}
+		return value == clause.value
+	}
+
+	words := analyze(value)
+	terms := analyze(clause.value)
+	if len(terms) == 0 {
+		return false
+	}
+	if clause.prefix && len(terms) == 1 {
+		for _, word := range words {
+			if strings.HasPrefix(word, terms[0]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// A phrase, or a term the analyzer splits, is the words in order
+	for start := 0; start+len(terms) <= len(words); start++ {
+		matched := true
+		for index, term := range terms {
+			if words[start+index] != term {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func isKeywordField(field string) bool {
+	field = strings.ToLower(field)
+	return strings.HasSuffix(field, ".value") || strings.HasSuffix(field, ".keyword") || field == "warningentries.code"
+}
+
+// Lowercased words, approximating the standard analyzer
+func analyze(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+	"strconv"
+
+	"github.com/nfnt/resize"
+)
+
+// The largest Hamming distance between two perceptual hashes
+const MaxPerceptualHashDistance = 64
+
+// A difference hash (dHash): the image is reduced to 9x8 grayscale pixels, each bit records
+// whether a pixel is brighter than its neighbor to the right. Resized, re-compressed and lightly
+// edited copies of an image end up with the same, or a very close, hash.
+// The hash is returned as 16 hex characters.
+func PerceptualHash(img image.Image) string {
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	bounds := small.Bounds()
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := luminance(small, bounds.Min.X+x, bounds.Min.Y+y)
+			right := luminance(small, bounds.Min.X+x+1, bounds.Min.Y+y)
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+
+	return fmt.Sprintf("%016x", hash)
+}
+
+func PerceptualHashFromFile(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", err
+	}
+	return PerceptualHash(img), nil
+}
+
+func ParsePerceptualHash(hash string) (uint64, error) {
+	return strconv.ParseUint(hash, 16, 64)
+}
+
+// The number of bits that differ between the two hashes; 0 is identical, 64 is completely different
+func PerceptualHashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func luminance(img image.Image, x, y int) uint32 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return (299*r + 587*g + 114*b) / 1000
+}
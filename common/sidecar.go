@@ -0,0 +1,95 @@
+package common
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// XMP sidecars, written by Lightroom, darktable and others, hold metadata edits for the media beside them
+const SidecarExtension = ".XMP"
+
+func IsSidecar(filename string) bool {
+	return strings.ToUpper(path.Ext(filename)) == SidecarExtension
+}
+
+// The sidecar names that pair with the media file, most specific first: 'IMG_1234.CR2.xmp' (darktable)
+// and then 'IMG_1234.xmp' (Lightroom). The names are upper case, for case insensitive matching.
+func SidecarNames(mediaFilename string) []string {
+	name := strings.ToUpper(path.Base(mediaFilename))
+	return []string{
+		name + SidecarExtension,
+		strings.TrimSuffix(name, path.Ext(name)) + SidecarExtension,
+	}
+}
+
+// Find the sidecar for a single media file; an empty string is returned if there isn't one.
+// When scanning, sidecars are paired using the directory listing instead.
+func FindSidecar(mediaFullPath string) string {
+	directory := path.Dir(mediaFullPath)
+	base := path.Base(mediaFullPath)
+	withoutExt := strings.TrimSuffix(base, path.Ext(base))
+	for _, name := range []string{base + ".xmp", base + ".XMP", withoutExt + ".xmp", withoutExt + ".XMP"} {
+		sidecarPath := path.Join(directory, name)
+		if info, err := os.Stat(sidecarPath); err == nil && !info.IsDir() {
+			return sidecarPath
+		}
+	}
+	return ""
+}
+
+// Merge the values from a sidecar into the metadata of the media. The precedence is:
+//   - Keywords: the union of the embedded and sidecar keywords (XMP Subject & Keywords, IPTC Keywords)
+//   - Rating, Title, Description: the sidecar value, when it has one
+//   - GPS: the sidecar location, when it has both latitude and longitude - it's usually a correction
+//     or an addition made by the cataloging application
+//
+// Everything else (dates, camera & exposure details, dimensions) comes from the media only.
+func (eo *ExifOutput) MergeSidecar(sidecar *ExifOutput) {
+	eo.XMP.Subject = mergeValues(eo.XMP.Subject, sidecar.XMP.Subject)
+	eo.XMP.Keywords = mergeValues(eo.XMP.Keywords, sidecar.XMP.Keywords)
+	eo.IPTC.Keywords = mergeValues(eo.IPTC.Keywords, sidecar.IPTC.Keywords)
+
+	if sidecar.XMP.Rating != nil {
+		eo.XMP.Rating = sidecar.XMP.Rating
+	}
+	if sidecar.XMP.Title != nil {
+		eo.XMP.Title = sidecar.XMP.Title
+	}
+	if sidecar.XMP.Description != nil {
+		eo.XMP.Description = sidecar.XMP.Description
+	}
+
+	if sidecar.Composite.GPSPosition != "" {
+		eo.Composite.GPSPosition = sidecar.Composite.GPSPosition
+	} else if sidecar.XMP.GPSLatitude != "" && sidecar.XMP.GPSLongitude != "" {
+		eo.Composite.GPSPosition = sidecar.XMP.GPSLatitude + ", " + sidecar.XMP.GPSLongitude
+	}
+}
+
+// Exiftool returns a single value as a string (or number) and multiple values as an array
+func mergeValues(first, second interface{}) interface{} {
+	if second == nil {
+		return first
+	}
+	if first == nil {
+		return second
+	}
+
+	merged := make([]interface{}, 0)
+	seen := make(map[interface{}]bool)
+	for _, value := range append(valueList(first), valueList(second)...) {
+		if !seen[value] {
+			seen[value] = true
+			merged = append(merged, value)
+		}
+	}
+	return merged
+}
+
+func valueList(value interface{}) []interface{} {
+	if list, ok := value.([]interface{}); ok {
+		return list
+	}
+	return []interface{}{value}
+}
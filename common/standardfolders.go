@@ -16,6 +16,7 @@ var ThumbnailDirectory string
 var LocationCacheDirectory string
 var ExifToolPath string
 var FfmpegPath string
+var FfprobePath string
 var VipsThumbnailPath string
 var ExecutingDirectory string
 var IndexerPath string
@@ -39,6 +40,7 @@ func InitDirectories(appName string) {
 			ThumbnailDirectory = path.Join(HomeDirectory, "Library", "Application Support", "FindAPhoto", "thumbnails")
 			LocationCacheDirectory = path.Join(HomeDirectory, "Library", "Application Support", "FindAPhoto")
 			FfmpegPath = "/usr/local/bin/ffmpeg"
+			FfprobePath = "/usr/local/bin/ffprobe"
 			ExifToolPath = "/usr/local/bin/exiftool"
 			VipsThumbnailPath = "/usr/local/bin/vipsthumbnail"
 		} else if runtime.GOOS == "linux" {
@@ -48,6 +50,7 @@ func InitDirectories(appName string) {
 			ConfigDirectory = path.Join(HomeDirectory, ".findaphoto")
 			LocationCacheDirectory = path.Join(HomeDirectory, ".findaphoto")
 			FfmpegPath = "/usr/bin/ffmpeg"
+			FfprobePath = "/usr/bin/ffprobe"
 			ExifToolPath = "/usr/bin/exiftool"
 			VipsThumbnailPath = "/usr/bin/vipsthumbnail"
 		} else {
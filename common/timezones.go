@@ -0,0 +1,210 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+// How the time zone of the capture time was decided
+const (
+	TimeZoneMethodOffset   = "offset"   // The offset recorded by the camera (OffsetTimeOriginal, or a date with an offset)
+	TimeZoneMethodLocation = "location" // From the GPS location, using the time zone boundaries
+	TimeZoneMethodIndexer  = "indexer"  // Nothing better known; the time zone of the indexing machine
+)
+
+// A GeoJSON FeatureCollection with a 'tzid' property for each feature, such as the releases from
+// https://github.com/evansiroky/timezone-boundary-builder. The zone names are resolved with the
+// system's zoneinfo, so nothing here needs the network
+var TimeZoneBoundaryFilename string
+
+type timeZoneBoundary struct {
+	name     string
+	minLat   float64
+	maxLat   float64
+	minLon   float64
+	maxLon   float64
+	polygons [][][][2]float64 // Polygons, each an outer ring followed by holes; points are [lon, lat]
+}
+
+type geoJsonFeatureCollection struct {
+	Features []struct {
+		Properties struct {
+			TzId string `json:"tzid"`
+		} `json:"properties"`
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+// A UTC offset, such as the '+02:00' given by cameras. Zone names can have a '+' or '-' as well
+// ('America/Port-au-Prince', 'Etc/GMT+5')
+var timeZoneOffsetPattern = regexp.MustCompile(`^[+-]\d{2}:?\d{2}$`)
+
+var timeZoneBoundaries []*timeZoneBoundary
+var timeZoneLocations = make(map[string]*time.Location)
+var timeZoneLoadOnce sync.Once
+var timeZoneLock sync.Mutex
+
+func DefaultTimeZoneBoundaryFilename() string {
+	return path.Join(LocationCacheDirectory, "timezones.geojson")
+}
+
+func IsTimeZoneOffset(name string) bool {
+	return timeZoneOffsetPattern.MatchString(name)
+}
+
+// Returns the time zone containing the location; false if there's no boundary file, the location
+// is outside all boundaries (such as at sea) or the zone isn't known to this system
+func TimeZoneForLocation(latitude, longitude float64) (*time.Location, bool) {
+	timeZoneLoadOnce.Do(loadTimeZoneBoundaries)
+
+	for _, tzb := range timeZoneBoundaries {
+		if latitude < tzb.minLat || latitude > tzb.maxLat || longitude < tzb.minLon || longitude > tzb.maxLon {
+			continue
+		}
+		if tzb.contains(latitude, longitude) {
+			location, err := loadTimeZoneLocation(tzb.name)
+			if err != nil {
+				log.Warn("Unable to load time zone '%s': %s", tzb.name, err.Error())
+				return nil, false
+			}
+			return location, true
+		}
+	}
+	return nil, false
+}
+
+func loadTimeZoneLocation(name string) (*time.Location, error) {
+	timeZoneLock.Lock()
+	defer timeZoneLock.Unlock()
+
+	if location, ok := timeZoneLocations[name]; ok {
+		return location, nil
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	timeZoneLocations[name] = location
+	return location, nil
+}
+
+func loadTimeZoneBoundaries() {
+	filename := TimeZoneBoundaryFilename
+	if filename == "" {
+		filename = DefaultTimeZoneBoundaryFilename()
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Warn("Time zones won't be derived from locations, unable to open '%s': %s", filename, err.Error())
+		return
+	}
+	defer file.Close()
+
+	var collection geoJsonFeatureCollection
+	err = json.NewDecoder(file).Decode(&collection)
+	if err != nil {
+		log.Warn("Time zones won't be derived from locations, unable to parse '%s': %s", filename, err.Error())
+		return
+	}
+
+	for _, feature := range collection.Features {
+		tzb, err := newTimeZoneBoundary(feature.Properties.TzId, feature.Geometry.Type, feature.Geometry.Coordinates)
+		if err != nil {
+			log.Warn("Ignoring time zone boundary '%s': %s", feature.Properties.TzId, err.Error())
+			continue
+		}
+		timeZoneBoundaries = append(timeZoneBoundaries, tzb)
+	}
+	log.Info("Loaded %d time zone boundaries from %s", len(timeZoneBoundaries), filename)
+}
+
+func newTimeZoneBoundary(name, geometryType string, coordinates json.RawMessage) (*timeZoneBoundary, error) {
+	tzb := &timeZoneBoundary{name: name, minLat: 90, maxLat: -90, minLon: 180, maxLon: -180}
+
+	switch geometryType {
+	case "Polygon":
+		var polygon [][][2]float64
+		if err := json.Unmarshal(coordinates, &polygon); err != nil {
+			return nil, err
+		}
+		tzb.polygons = append(tzb.polygons, polygon)
+	case "MultiPolygon":
+		if err := json.Unmarshal(coordinates, &tzb.polygons); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported geometry type: %s", geometryType)
+	}
+
+	for _, polygon := range tzb.polygons {
+		if len(polygon) < 1 {
+			continue
+		}
+		for _, point := range polygon[0] {
+			tzb.minLon = minFloat(tzb.minLon, point[0])
+			tzb.maxLon = maxFloat(tzb.maxLon, point[0])
+			tzb.minLat = minFloat(tzb.minLat, point[1])
+			tzb.maxLat = maxFloat(tzb.maxLat, point[1])
+		}
+	}
+	return tzb, nil
+}
+
+func (tzb *timeZoneBoundary) contains(latitude, longitude float64) bool {
+	for _, polygon := range tzb.polygons {
+		if len(polygon) < 1 || !ringContains(polygon[0], latitude, longitude) {
+			continue
+		}
+
+		inHole := false
+		for _, hole := range polygon[1:] {
+			if ringContains(hole, latitude, longitude) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// Ray casting - count the edges crossed going east from the point
+func ringContains(ring [][2]float64, latitude, longitude float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		lonI, latI := ring[i][0], ring[i][1]
+		lonJ, latJ := ring[j][0], ring[j][1]
+		if (latI > latitude) != (latJ > latitude) &&
+			longitude < (lonJ-lonI)*(latitude-latI)/(latJ-latI)+lonI {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
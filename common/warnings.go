@@ -0,0 +1,119 @@
+package common
+
+import (
+	"strings"
+)
+
+// The codes are stable, so media can be found by the kind of problem regardless of the details
+// in the message
+const (
+	WarningExifUnavailable           = "exif-unavailable"
+	WarningSidecarUnreadable         = "sidecar-unreadable"
+	WarningUnparseableFocalLength    = "unparseable-focal-length"
+	WarningUnparseableDuration       = "unparseable-duration"
+	WarningUnexpectedKeywords        = "unexpected-keywords"
+	WarningUnparseableRating         = "unparseable-rating"
+	WarningUnparseableISO            = "unparseable-iso"
+	WarningUnparseableExposureTime   = "unparseable-exposure-time"
+	WarningUnparseableDate           = "unparseable-date"
+	WarningNoExifDate                = "no-exif-date"
+	WarningFileDateMismatch          = "file-date-mismatch"
+	WarningUnparseableTimeZone       = "unparseable-time-zone"
+	WarningUnparseableGPS            = "unparseable-gps"
+	WarningUnparseableAltitude       = "unparseable-altitude"
+	WarningUnparseableImageDirection = "unparseable-image-direction"
+	WarningFfprobeFailed             = "ffprobe-failed"
+	WarningPlacenameLookupFailed     = "placename-lookup-failed"
+	WarningPlacenameUnavailable      = "placename-unavailable"
+	WarningGpxWriteFailed            = "gpx-write-failed"
+	WarningOther                     = "other" // A warning from before there were codes that couldn't be classified
+)
+
+type Warning struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Params  map[string]string `json:"params,omitempty"` // The values the message was built from
+}
+
+// The params are name, value pairs
+func NewWarning(code, message string, params ...string) Warning {
+	warning := Warning{Code: code, Message: message}
+	if len(params) > 1 {
+		warning.Params = make(map[string]string)
+		for index := 0; index+1 < len(params); index += 2 {
+			warning.Params[params[index]] = params[index+1]
+		}
+	}
+	return warning
+}
+
+// The start of the messages warnings had before there were codes. Checked in order, the first
+// match wins
+var legacyWarnings = []struct {
+	prefix string
+	code   string
+}{
+	{"No exif data available", WarningExifUnavailable},
+	{"No exif for", WarningExifUnavailable},
+	{"Failed executing exiftool", WarningExifUnavailable},
+	{"Unable to read sidecar", WarningSidecarUnreadable},
+	{"Unexpected format for FocalLength", WarningUnparseableFocalLength},
+	{"Failed converting FocalLength", WarningUnparseableFocalLength},
+	{"Unable to parse duration", WarningUnparseableDuration},
+	{"Unexpected keyword type", WarningUnexpectedKeywords},
+	{"Unexpected subject type", WarningUnexpectedKeywords},
+	{"Unable to parse rating", WarningUnparseableRating},
+	{"Unexpected ISO type", WarningUnparseableISO},
+	{"ISO string", WarningUnparseableISO},
+	{"Unexpected ExposureTime type", WarningUnparseableExposureTime},
+	{"Unable to convert ExposureTimeString", WarningUnparseableExposureTime},
+	{"Failed parsing time zone offset", WarningUnparseableTimeZone},
+	{"Failed parsing", WarningUnparseableDate},
+	{"No usable date in EXIF", WarningNoExifDate},
+	{"File modify date does not match", WarningFileDateMismatch},
+	{"Unsupported GPSPosition", WarningUnparseableGPS},
+	{"Ignoring poorly formed location", WarningUnparseableGPS},
+	{"Ignoring location", WarningUnparseableGPS},
+	{"Unable to parse altitude", WarningUnparseableAltitude},
+	{"Unable to parse image direction", WarningUnparseableImageDirection},
+	{"Failed executing ffprobe", WarningFfprobeFailed},
+	{"Unable to parse ffprobe output", WarningFfprobeFailed},
+	{"Failed getting placename", WarningPlacenameLookupFailed},
+	{"Failed reading body of response", WarningPlacenameLookupFailed},
+	{"Failed deserializing json", WarningPlacenameLookupFailed},
+	{"Reverse lookup didn't return a description", WarningPlacenameLookupFailed},
+	{"No GeoNames city within", WarningPlacenameLookupFailed},
+}
+
+// Gives a code to a warning from before there were codes
+func ClassifyWarning(message string) Warning {
+	for _, legacy := range legacyWarnings {
+		if strings.HasPrefix(message, legacy.prefix) {
+			return Warning{Code: legacy.code, Message: message}
+		}
+	}
+	return Warning{Code: WarningOther, Message: message}
+}
+
+// The start of the messages that warnings with the code had, before there were codes
+func LegacyWarningPrefixes(code string) []string {
+	prefixes := []string{}
+	for _, legacy := range legacyWarnings {
+		if legacy.code == code {
+			prefixes = append(prefixes, legacy.prefix)
+		}
+	}
+	return prefixes
+}
+
+// Moves the warnings from before there were codes into Warnings. Returns true if there were any
+func (m *Media) MigrateLegacyWarnings() bool {
+	if len(m.LegacyWarnings) == 0 {
+		return false
+	}
+	for _, message := range m.LegacyWarnings {
+		m.Warnings = append(m.Warnings, ClassifyWarning(message))
+	}
+	m.LegacyWarnings = nil
+	return true
+}
@@ -19,6 +19,23 @@ type Configuration struct {
 	VipsExists        bool   `json:"VipsExists"`
 	DefaultIndexPath  string `json:"DefaultIndexPath"`
 	ClarifaiAPIKey    string `json:"ClarifaiApiKey"`
+
+	// Optional - when empty, the indexer uses its default list
+	SupportedExtensions []string `json:"SupportedExtensions"`
+
+	// Optional - gitignore-style patterns skipped in every indexed path, in addition to '.findaphotoignore' files
+	ExcludePatterns []string `json:"ExcludePatterns"`
+
+	// Optional - when LocationLookupUrl is empty, placenames come from the GeoNames files in this folder
+	// (the indexer's default folder if this is empty as well)
+	GeoNamesDirectory string `json:"GeoNamesDirectory"`
+
+	// Optional - GPX track logs, for the location of media without one. The folder defaults to the
+	// indexer's; the gap & offset (camera clock minus track clock) are in seconds
+	GpxDirectory     string `json:"GpxDirectory"`
+	GpxMaxGapSeconds int    `json:"GpxMaxGapSeconds"`
+	GpxOffsetSeconds int    `json:"GpxOffsetSeconds"`
+	GpxWriteBack     bool   `json:"GpxWriteBack"`
 }
 
 var Current Configuration
@@ -33,7 +50,7 @@ func ReadConfiguration() {
 		defaults := &Configuration{
 			ElasticSearchURL:  "elastic search url (http://somehost:9200)",
 			RedisURL:          "redis url (redis://somehost:6379)",
-			LocationLookupURL: "location lookup url",
+			LocationLookupURL: "",
 			ClarifaiAPIKey:    "clarifai.com api key goes here",
 		}
 		json, jerr := json.Marshal(defaults)
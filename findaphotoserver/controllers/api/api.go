@@ -56,6 +56,20 @@ func ConfigureRouting(e *echo.Echo) {
 	index.GET("/duplicates", duplicateMediaAPI)
 	index.GET("/info", indexAPI)
 	index.POST("/reindex", reindexAPI)
+	index.GET("/status", indexStatusAPI)
+	index.GET("/runs", indexRunsAPI)
+	index.GET("/dryrun", dryRunAPI)
+	index.GET("/similar", similarAPI)
+	index.GET("/nearduplicates", nearDuplicatesAPI)
+	index.GET("/warnings", warningsAPI)
+	index.GET("/gpx", gpxFilesAPI)
+	index.POST("/gpx", uploadGpxAPI)
+	index.GET("/paths", pathsAPI)
+	index.POST("/paths", addPathAPI)
+	index.PUT("/paths/:alias", relocatePathAPI)
+	index.DELETE("/paths/:alias", removePathAPI)
+	index.GET("/schedule", scheduleAPI)
+	index.PUT("/schedule", updateScheduleAPI)
 }
 
 func filterResults(searchResult *search.SearchResult, propertiesFilter []string) map[string]interface{} {
@@ -177,18 +191,39 @@ func filteredItems(items []*search.MediaHit, propertiesFilter []string) interfac
 
 func property(name string, mh *search.MediaHit) interface{} {
 	switch strings.ToLower(name) {
+	case "altitude":
+		if mh.Media.Altitude != nil {
+			return mh.Media.Altitude
+		}
+		return nil
 	case "aperture":
 		return mh.Media.ApertureValue
+	case "artist":
+		return mh.Media.Artist
+	case "audiochannels":
+		return mh.Media.AudioChannels
+	case "audiocodec":
+		return mh.Media.AudioCodec
+	case "bitrate":
+		return mh.Media.BitRate
 	case "cameramake":
 		return mh.Media.CameraMake
 	case "cameramodel":
 		return mh.Media.CameraModel
+	case "cameraserialnumber":
+		return mh.Media.CameraSerialNumber
 	case "city":
 		return mh.Media.LocationCityName
 	case "createddate":
 		return mh.Media.DateTime
+	case "container":
+		return mh.Media.Container
+	case "copyright":
+		return mh.Media.Copyright
 	case "country":
 		return common.ConvertToCountryName(mh.Media.LocationCountryCode, mh.Media.LocationCountryName)
+	case "description":
+		return mh.Media.Description
 	case "distancekm":
 		if mh.DistanceKm != nil {
 			return mh.DistanceKm
@@ -208,12 +243,26 @@ func property(name string, mh *search.MediaHit) interface{} {
 		return mh.Media.FNumber
 	case "focallength":
 		return mh.Media.FocalLengthMm
+	case "hashdistance":
+		if mh.HashDistance != nil {
+			return mh.HashDistance
+		}
+		return nil
+	case "framerate":
+		return mh.Media.FrameRate
+	case "frameratename":
+		return mh.Media.FrameRateName
 	case "height":
 		return mh.Media.Height
 	case "id":
 		return mh.Media.Path
 	case "iso":
 		return mh.Media.Iso
+	case "imagedirection":
+		if mh.Media.ImageDirection != nil {
+			return mh.Media.ImageDirection
+		}
+		return nil
 	case "imagename":
 		return mh.Media.Filename
 	case "keywords":
@@ -227,6 +276,10 @@ func property(name string, mh *search.MediaHit) interface{} {
 		return mh.Media.LensInfo
 	case "lensmodel":
 		return mh.Media.LensModel
+	case "lensserialnumber":
+		return mh.Media.LensSerialNumber
+	case "localdatetime":
+		return mh.Media.LocalDateTime
 	case "locationdisplayname":
 		if mh.Media.Location == nil {
 			return nil
@@ -242,6 +295,11 @@ func property(name string, mh *search.MediaHit) interface{} {
 			return nil
 		}
 		return mh.Media.LocationPlaceName
+	case "locationsource":
+		if mh.Media.Location == nil {
+			return nil
+		}
+		return mh.Media.LocationSource
 	case "longitude":
 		if mh.Media.Location == nil {
 			return nil
@@ -253,20 +311,42 @@ func property(name string, mh *search.MediaHit) interface{} {
 		return files.ToMediaUrl(mh.Media.Path)
 	case "mimetype":
 		return mh.Media.MimeType
+	case "orientation":
+		return mh.Media.Orientation
 	case "path":
 		return mh.Media.Path
+	case "perceptualhash":
+		return mh.Media.PerceptualHash
+	case "rating":
+		return mh.Media.Rating
+	case "rotation":
+		return mh.Media.Rotation
 	case "signature":
 		return mh.Media.Signature
 	case "sitename":
 		return mh.Media.LocationSiteName
+	case "software":
+		return mh.Media.Software
 	case "slideurl":
 		return files.ToSlideUrl(mh.Media.Path)
 	case "tags":
 		return mh.Media.Tags
 	case "thumburl":
 		return files.ToThumbUrl(mh.Media.Path)
+	case "timezone":
+		return mh.Media.TimeZone
+	case "timezonemethod":
+		return mh.Media.TimeZoneMethod
+	case "title":
+		return mh.Media.Title
+	case "utcdatetime":
+		return mh.Media.UTCDateTime
+	case "videocodec":
+		return mh.Media.VideoCodec
+	case "videoresolution":
+		return mh.Media.VideoResolution
 	case "warnings":
-		return mh.Media.Warnings
+		return mediaWarnings(mh.Media)
 	case "width":
 		return mh.Media.Width
 	}
@@ -290,6 +370,8 @@ func populateCategoryOptions(fc *util.FpContext, categoryOptions *search.Categor
 				categoryOptions.DateCount = 10
 			case "year":
 				categoryOptions.YearCount = 10
+			case "video":
+				categoryOptions.VideoCount = 10
 			default:
 				panic(&util.InvalidRequest{Message: fmt.Sprintf("Unknown category: '%s'", c)})
 			}
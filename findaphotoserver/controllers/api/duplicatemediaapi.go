@@ -1,12 +1,8 @@
 package api
 
 import (
-	"encoding/json"
 	"net/http"
 
-	"golang.org/x/net/context"
-	"gopkg.in/olivere/elastic.v5"
-
 	"github.com/kevintavog/findaphoto/common"
 	"github.com/kevintavog/findaphoto/findaphotoserver/util"
 	"github.com/labstack/echo"
@@ -28,45 +24,28 @@ func duplicateMediaAPI(c echo.Context) error {
 	index := fc.IntFromQuery("first", 1) - 1
 
 	return fc.Time("duplicates", func() error {
-		client := common.CreateClient()
-		result, err := client.Search().
-			Index(common.MediaIndexName).
-			Type(common.DuplicateTypeName).
-			Query(elastic.NewMatchAllQuery()).
-			From(index).
-			Size(count).
-			Pretty(true).
-			Do(context.TODO())
+		duplicates, total, err := common.ActiveMediaStore.Duplicates(index, count)
 		if err != nil {
 			panic(&util.InvalidRequest{Message: "Failed searching for duplicates", Err: err})
 		}
 
-		return c.JSON(http.StatusOK, processSearchResults(result))
+		return c.JSON(http.StatusOK, processDuplicates(duplicates, total))
 	})
 }
 
-func processSearchResults(result *elastic.SearchResult) map[string]interface{} {
+func processDuplicates(duplicates []*common.DuplicateItem, total int64) map[string]interface{} {
 	dsr := make(map[string]interface{})
-	dsr["totalMatches"] = result.TotalHits()
-
-	duplicates := make([]map[string]interface{}, 0)
-
-	resultCount := 0
-	for _, hit := range result.Hits.Hits {
-		di := &common.DuplicateItem{}
-		err := json.Unmarshal(*hit.Source, di)
-		if err != nil {
-			panic(&util.InvalidRequest{Message: "JSON unmarshalling failed", Err: err})
-		}
+	dsr["totalMatches"] = total
 
+	items := make([]map[string]interface{}, 0, len(duplicates))
+	for _, di := range duplicates {
 		item := make(map[string]interface{})
 		item["ignoredPath"] = di.IgnoredPath
 		item["existingPath"] = di.ExistingPath
-		duplicates = append(duplicates, item)
-		resultCount++
+		items = append(items, item)
 	}
 
-	dsr["resultCount"] = resultCount
-	dsr["duplicates"] = duplicates
+	dsr["resultCount"] = len(items)
+	dsr["duplicates"] = items
 	return dsr
 }
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/findaphotoserver/util"
+	"github.com/labstack/echo"
+)
+
+type GeotagMediaFunction func()
+
+// Set by main; the folder the indexer reads GPX track logs from
+var GpxDirectory string
+var GeotagMedia GeotagMediaFunction
+
+const maxGpxFileBytes = 64 * 1024 * 1024
+
+type GpxFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// The GPX track logs the indexer locates media with
+func gpxFilesAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	return fc.Time("gpxfiles", func() error {
+		files := make([]GpxFile, 0)
+		infos, err := ioutil.ReadDir(GpxDirectory)
+		if err == nil {
+			for _, info := range infos {
+				if !info.IsDir() && strings.ToLower(filepath.Ext(info.Name())) == ".gpx" {
+					files = append(files, GpxFile{Name: info.Name(), Size: info.Size(), Modified: info.ModTime()})
+				}
+			}
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+		response := make(map[string]interface{})
+		response["directory"] = GpxDirectory
+		response["files"] = files
+		return c.JSON(http.StatusOK, response)
+	})
+}
+
+// Saves the uploaded track logs (multipart, each in a 'file' part) to the GPX folder. A log with the
+// name of a different one already there is saved with a number added ('track-1.gpx'); the same log
+// uploaded again isn't saved twice. With 'geotag=true', indexed media without a location is then
+// located from the tracks
+func uploadGpxAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	geotag := fc.BoolFromQuery("geotag", false)
+	fc.LogBool("geotag", geotag)
+
+	return fc.Time("uploadgpx", func() error {
+		form, err := c.MultipartForm()
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "Expected a multipart form with GPX files", Err: err})
+		}
+		parts := form.File["file"]
+		if len(parts) < 1 {
+			panic(&util.InvalidRequest{Message: "No GPX files ('file') were uploaded"})
+		}
+
+		// All the files are checked before any are saved
+		contents := make(map[string][]byte)
+		for _, part := range parts {
+			name := filepath.Base(part.Filename)
+			if strings.ToLower(filepath.Ext(name)) != ".gpx" {
+				panic(&util.InvalidRequest{Message: fmt.Sprintf("Not a GPX file: '%s'", part.Filename)})
+			}
+
+			if _, exists := contents[name]; exists {
+				panic(&util.InvalidRequest{Message: fmt.Sprintf("More than one file is named '%s'", name)})
+			}
+
+			data, err := readGpxPart(part)
+			if err != nil {
+				panic(&util.InvalidRequest{Message: fmt.Sprintf("Unable to read '%s'", part.Filename), Err: err})
+			}
+			contents[name] = data
+		}
+
+		err = common.CreateDirectory(GpxDirectory)
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "Unable to create the GPX folder", Err: err})
+		}
+
+		saved := make([]string, 0)
+		renamed := make(map[string]string)
+		for name, data := range contents {
+			savedName, err := saveGpxFile(name, data)
+			if err != nil {
+				panic(&util.InvalidRequest{Message: fmt.Sprintf("Unable to save '%s'", name), Err: err})
+			}
+			if savedName != name {
+				renamed[name] = savedName
+			}
+			saved = append(saved, savedName)
+		}
+		sort.Strings(saved)
+		fc.LogStringArray("saved", saved)
+
+		if geotag {
+			GeotagMedia()
+		}
+
+		response := make(map[string]interface{})
+		response["saved"] = saved
+		response["renamed"] = renamed
+		response["geotagging"] = geotag
+		return c.JSON(http.StatusOK, response)
+	})
+}
+
+// Returns the name the log was saved as; the existing file, if it's the same log
+func saveGpxFile(name string, data []byte) (string, error) {
+	extension := filepath.Ext(name)
+	base := strings.TrimSuffix(name, extension)
+	for count := 0; ; count++ {
+		savedName := name
+		if count > 0 {
+			savedName = fmt.Sprintf("%s-%d%s", base, count, extension)
+		}
+
+		filename := filepath.Join(GpxDirectory, savedName)
+		existing, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			return savedName, ioutil.WriteFile(filename, data, 0644)
+		}
+		if err != nil {
+			return "", err
+		}
+		if bytes.Equal(existing, data) {
+			return savedName, nil
+		}
+	}
+}
+
+// Returns the file, once it's known to be GPX
+func readGpxPart(part *multipart.FileHeader) ([]byte, error) {
+	file, err := part.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxGpxFileBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxGpxFileBytes {
+		return nil, fmt.Errorf("larger than %d bytes", maxGpxFileBytes)
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("not a GPX document: %s", err.Error())
+		}
+		if element, ok := token.(xml.StartElement); ok {
+			if element.Name.Local != "gpx" {
+				return nil, fmt.Errorf("not a GPX document, the root is '%s'", element.Name.Local)
+			}
+			return data, nil
+		}
+	}
+}
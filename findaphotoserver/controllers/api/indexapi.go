@@ -12,15 +12,38 @@ import (
 	"gopkg.in/olivere/elastic.v5"
 
 	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/findaphotoserver/scheduler"
 	"github.com/kevintavog/findaphoto/findaphotoserver/search"
 	"github.com/kevintavog/findaphoto/findaphotoserver/util"
 )
 
-type ReindexMediaFunction func(bool)
+type ReindexMediaFunction func(force bool, dryRun bool)
+type IndexerStatusFunction func() IndexerStatus
+type DryRunStatusFunction func() DryRunStatus
 
 var ReindexMedia ReindexMediaFunction
+var GetIndexerStatus IndexerStatusFunction
+var GetDryRunStatus DryRunStatusFunction
 var FindAPhotoVersionNumber string
 
+type IndexerStatus struct {
+	Active        bool                  `json:"active"`
+	Path          string                `json:"path,omitempty"`
+	Geotagging    bool                  `json:"geotagging,omitempty"` // Locating indexed media from GPX tracks
+	Started       *time.Time            `json:"started,omitempty"`
+	LastCompleted *time.Time            `json:"lastCompleted,omitempty"`
+	LastError     string                `json:"lastError,omitempty"`
+	Progress      *common.IndexProgress `json:"progress,omitempty"`
+}
+
+// The reports of the most recent dry run, one per indexed path
+type DryRunStatus struct {
+	Active    bool                   `json:"active"`
+	Started   *time.Time             `json:"started,omitempty"`
+	Completed *time.Time             `json:"completed,omitempty"`
+	Reports   []*common.DryRunReport `json:"reports"`
+}
+
 type PathAndDate struct {
 	Path        string     `json:"path,omitempty"`
 	LastIndexed *time.Time `json:"lastIndexed,omitempty"`
@@ -48,6 +71,7 @@ var fieldsNotExposed = map[string]bool{
 	"location":            true,
 	"originalcameramake":  true,
 	"originalcameramodel": true,
+	"warningentries":      true,
 }
 
 func indexAPI(c echo.Context) error {
@@ -56,10 +80,8 @@ func indexAPI(c echo.Context) error {
 
 	return fc.Time("index", func() error {
 		props := make(map[string]interface{})
-		client := common.CreateClient()
-
 		for _, name := range propertiesFilter {
-			v := getValue(name, client)
+			v := getValue(name)
 			if v != nil {
 				props[name] = v
 			}
@@ -73,12 +95,33 @@ func reindexAPI(c echo.Context) error {
 	fc := c.(*util.FpContext)
 	return fc.Time("reindex", func() error {
 		force := fc.BoolFromQuery("force", false)
+		dryRun := fc.BoolFromQuery("dryRun", false)
 		fc.LogBool("force", force)
-		ReindexMedia(force)
+		fc.LogBool("dryRun", dryRun)
+		ReindexMedia(force, dryRun)
 		return c.NoContent(http.StatusNoContent)
 	})
 }
 
+// The dry run is started with 'reindex?dryRun=true'; the reports are available when it's no longer active
+func dryRunAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	return fc.Time("dryrun", func() error {
+		status := GetDryRunStatus()
+		fc.LogBool("active", status.Active)
+		return c.JSON(http.StatusOK, status)
+	})
+}
+
+func indexStatusAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	return fc.Time("indexstatus", func() error {
+		status := GetIndexerStatus()
+		fc.LogBool("active", status.Active)
+		return c.JSON(http.StatusOK, status)
+	})
+}
+
 func indexFieldValuesAPI(c echo.Context) error {
 	fc := c.(*util.FpContext)
 	return fc.Time("", func() error {
@@ -108,32 +151,35 @@ func indexFieldValuesAPI(c echo.Context) error {
 	})
 }
 
-func getValue(name string, client *elastic.Client) interface{} {
+func getValue(name string) interface{} {
 	switch strings.ToLower(name) {
 
 	case "dependencyinfo":
-		return getDependencyInfo(client)
+		return getDependencyInfo(common.CreateClient())
 
 	case "duplicatecount":
-		return getDuplicateCount(client)
+		return getDuplicateCount()
 
 	case "fields":
 		return getMappedFields()
 
 	case "imagecount":
-		return getCountsSearch(client, "mimetype:image*")
+		return getCountsSearch("mimetype:image*")
 
 	case "paths":
 		return getAliasedPaths()
 
+	case "schedule":
+		return scheduler.CurrentStatus()
+
 	case "versionnumber":
 		return FindAPhotoVersionNumber
 
 	case "videocount":
-		return getCountsSearch(client, "mimetype:video*")
+		return getCountsSearch("mimetype:video*")
 
 	case "warningcount":
-		return getCountsSearch(client, "warnings:*")
+		return getCountsSearch("_exists_:warningentries OR _exists_:warnings")
 	}
 
 	panic(&util.InvalidRequest{Message: fmt.Sprintf("Unknown property: '%s'", name)})
@@ -154,27 +200,16 @@ func getAliasedPaths() []PathAndDate {
 	return allPaths
 }
 
-func getCountsSearch(client *elastic.Client, query string) string {
-	search := client.Search().
-		Index(common.MediaIndexName).
-		Type(common.MediaTypeName).
-		Query(elastic.NewQueryStringQuery(query)).
-		From(0).
-		Size(1).
-		Pretty(true)
-
-	result, err := search.Do(context.TODO())
+func getCountsSearch(query string) string {
+	result, err := common.ActiveMediaStore.Search(&common.MediaSearchOptions{Query: common.MediaQuery{Text: query}, Size: 0})
 	if err != nil {
 		return ""
 	}
-	return fmt.Sprintf("%d", result.TotalHits())
+	return fmt.Sprintf("%d", result.TotalHits)
 }
 
-func getDuplicateCount(client *elastic.Client) string {
-	count, err := client.Count().
-		Index(common.MediaIndexName).
-		Type(common.DuplicateTypeName).
-		Do(context.TODO())
+func getDuplicateCount() string {
+	_, count, err := common.ActiveMediaStore.Duplicates(0, 0)
 	if err != nil {
 		return ""
 	}
@@ -250,7 +285,7 @@ func getMappedFields() []string {
 func getTopFieldValues(fieldNames []string, maxCount int, searchText string, monthString string,
 	dayString string, drilldownOptions *search.DrilldownOptions) []interface{} {
 
-	var query elastic.Query
+	query := common.MediaQuery{Drilldown: drilldownOptions.Drilldown}
 	if len(monthString) > 0 || len(dayString) > 0 {
 		if len(searchText) > 0 {
 			panic(&util.InvalidRequest{Message: "Either 'q' OR 'month' & 'day' should be specified, not both"})
@@ -259,7 +294,8 @@ func getTopFieldValues(fieldNames []string, maxCount int, searchText string, mon
 		month := util.IntFromString("month", monthString)
 		day := util.IntFromString("day", dayString)
 
-		query = elastic.NewTermQuery("dayofyear", common.DayOfYear(month, day))
+		query.DayOfYearFrom = common.DayOfYear(month, day)
+		query.DayOfYearTo = query.DayOfYearFrom
 	} else {
 		// This is gross - for reasons I don't understand, when using the match all query, the field
 		// enumeration/aggregations come back empty when combined with drilldowns.
@@ -267,27 +303,19 @@ func getTopFieldValues(fieldNames []string, maxCount int, searchText string, mon
 		if searchText == "" {
 			searchText = "*"
 		}
-		//		query = elastic.NewMatchAllQuery()
-		//	} else {
-		query = elastic.NewQueryStringQuery(searchText).
-			Field("path"). // Folder name
-			Field("monthname").
-			Field("dayname").
-			Field("keywords").
-			Field("placename"). // Full reverse location lookup
-			Field("tags")
-		//	}
+		query.Text = searchText
+		query.TextFields = []string{
+			"path", // Folder name
+			"monthname",
+			"dayname",
+			"keywords",
+			"placename", // Full reverse location lookup
+			"tags"}
 	}
 
 	fieldInfo := make([]interface{}, 0)
 
-	client := common.CreateClient()
-	searchService := client.Search().
-		Index(common.MediaIndexName).
-		Type(common.MediaTypeName).
-		Size(0).
-		Query(query)
-
+	options := &common.MediaSearchOptions{Query: query, Size: 0}
 	for _, name := range fieldNames {
 		internalName, _ := common.GetIndexFieldName(name)
 		if _, notSupported := fieldsAggregateDisallowed[internalName]; notSupported {
@@ -295,12 +323,10 @@ func getTopFieldValues(fieldNames []string, maxCount int, searchText string, mon
 			return fieldInfo
 		}
 
-		searchService.Aggregation(name, elastic.NewTermsAggregation().Field(internalName).Size(maxCount))
+		options.Aggregations = append(options.Aggregations, &common.TermsAggregation{Name: name, Field: internalName, Size: maxCount})
 	}
 
-	search.AddDrilldown(searchService, &query, drilldownOptions)
-
-	result, err := searchService.Do(context.TODO())
+	result, err := common.ActiveMediaStore.Search(options)
 
 	if err != nil {
 		panic(&util.InvalidRequest{Message: "Failed searching for field values", Err: err})
@@ -308,12 +334,12 @@ func getTopFieldValues(fieldNames []string, maxCount int, searchText string, mon
 
 	for _, name := range fieldNames {
 		values := make([]interface{}, 0)
-		fieldValues, found := result.Aggregations.Terms(name)
+		buckets, found := result.Aggregations[name]
 		if !found {
 			continue
 		}
 
-		for _, bucket := range fieldValues.Buckets {
+		for _, bucket := range buckets {
 			// datetime needs to be converted to a Date
 			internalName, _ := common.GetIndexFieldName(name)
 			value := ""
@@ -329,7 +355,7 @@ func getTopFieldValues(fieldNames []string, maxCount int, searchText string, mon
 				value = fmt.Sprintf(format, bucket.Key)
 			}
 
-			values = append(values, map[string]interface{}{"value": value, "count": bucket.DocCount})
+			values = append(values, map[string]interface{}{"value": value, "count": bucket.Count})
 		}
 
 		fv := make(map[string]interface{})
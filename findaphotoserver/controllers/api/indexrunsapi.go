@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/findaphotoserver/util"
+	"github.com/labstack/echo"
+)
+
+// The history of indexer runs, most recent first; optionally limited to those of a single alias,
+// including the watch runs covering it
+func indexRunsAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	count := fc.IntFromQuery("count", 20)
+	if count < 1 || count > 100 {
+		panic(&util.InvalidRequest{Message: "count must be between 1 and 100, inclusive"})
+	}
+
+	index := fc.IntFromQuery("first", 1) - 1
+	alias := c.QueryParam("alias")
+
+	return fc.Time("indexruns", func() error {
+		var query elastic.Query = elastic.NewMatchAllQuery()
+		if len(alias) > 0 {
+			fc.Log("alias", alias)
+			query = elastic.NewBoolQuery().
+				Should(elastic.NewTermQuery("alias", alias)).
+				Should(elastic.NewTermQuery("aliases", alias)).
+				MinimumNumberShouldMatch(1)
+		}
+
+		client := common.CreateClient()
+		result, err := client.Search().
+			Index(common.IndexRunIndexName).
+			Type(common.IndexRunTypeName).
+			Query(query).
+			Sort("starttime", false).
+			From(index).
+			Size(count).
+			Do(context.TODO())
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "Failed searching for index runs", Err: err})
+		}
+
+		return c.JSON(http.StatusOK, processIndexRunResults(result))
+	})
+}
+
+func processIndexRunResults(result *elastic.SearchResult) map[string]interface{} {
+	response := make(map[string]interface{})
+	response["totalMatches"] = result.TotalHits()
+
+	runs := make([]map[string]interface{}, 0)
+	for _, hit := range result.Hits.Hits {
+		run := &common.IndexRun{}
+		err := json.Unmarshal(*hit.Source, run)
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "JSON unmarshalling failed", Err: err})
+		}
+
+		item := make(map[string]interface{})
+		item["id"] = hit.Id
+		item["alias"] = run.Alias
+		item["path"] = run.Path
+		item["aliases"] = run.Aliases
+		item["paths"] = run.Paths
+		item["watch"] = run.Watch
+		item["active"] = run.Active
+		item["startTime"] = run.StartTime
+		item["endTime"] = run.EndTime
+		item["durationSeconds"] = run.DurationSeconds
+		item["counters"] = run.Counters
+		item["errors"] = run.Errors
+		item["errorCount"] = run.ErrorCount
+		runs = append(runs, item)
+	}
+
+	response["resultCount"] = len(runs)
+	response["runs"] = runs
+	return response
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/findaphotoserver/search"
+	"github.com/kevintavog/findaphoto/findaphotoserver/util"
+	"github.com/labstack/echo"
+)
+
+type LibraryPath struct {
+	Alias       string     `json:"alias"`
+	Path        string     `json:"path"`
+	Added       time.Time  `json:"added"`
+	LastIndexed *time.Time `json:"lastIndexed,omitempty"`
+	MediaCount  int64      `json:"mediaCount"`
+}
+
+// The library roots, with the number of media indexed in each
+func pathsAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	return fc.Time("paths", func() error {
+		paths := make([]LibraryPath, 0)
+		common.VisitAllPaths(func(alias common.AliasDocument) {
+			result, err := common.ActiveMediaStore.Search(&common.MediaSearchOptions{Query: aliasMediaQuery(alias.Alias), Size: 0})
+			if err != nil {
+				panic(&util.InvalidRequest{Message: "Failed counting the media of each path", Err: err})
+			}
+			paths = append(paths, toLibraryPath(alias, result.TotalHits))
+		})
+
+		response := make(map[string]interface{})
+		response["paths"] = paths
+		return c.JSON(http.StatusOK, response)
+	})
+}
+
+// Adds 'path' as a library root. With 'index=true', indexing is started afterwards
+func addPathAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	index := fc.BoolFromQuery("index", false)
+	fc.LogBool("index", index)
+
+	return fc.Time("addpath", func() error {
+		libraryPath := validLibraryPath(c.QueryParam("path"), "")
+		fc.Log("path", libraryPath)
+
+		alias, err := common.AddAlias(libraryPath)
+		if err != nil {
+			panic(&util.InvalidRequest{Message: fmt.Sprintf("Failed adding '%s'", libraryPath), Err: err})
+		}
+		fc.Log("alias", alias.Alias)
+
+		if index {
+			ReindexMedia(false, false)
+		}
+		return c.JSON(http.StatusOK, toLibraryPath(alias, 0))
+	})
+}
+
+// Removes the library root along with its media, duplicates and thumbnails. The files themselves
+// aren't touched. An indexer run covering the root, such as an indexer watching all paths, would add
+// its media back - so it's refused while one is active, unless 'force=true' is given for a run that
+// was killed rather than stopped
+func removePathAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	alias := c.Param("alias")
+	fc.Log("alias", alias)
+	force := fc.BoolFromQuery("force", false)
+	fc.LogBool("force", force)
+
+	return fc.Time("removepath", func() error {
+		if !common.IsValidAlias(alias) {
+			return util.ErrorJSON(c, http.StatusNotFound, "NoSuchAlias", "", nil)
+		}
+		if GetIndexerStatus().Active {
+			return util.ErrorJSON(c, http.StatusConflict, "IndexerActive", "Paths can't be removed while indexing", nil)
+		}
+
+		client := common.CreateClient()
+		if !force {
+			activeRuns, err := common.ActiveIndexRuns(client, alias)
+			if err != nil {
+				panic(&util.InvalidRequest{Message: "Failed checking for active indexer runs", Err: err})
+			}
+			if activeRuns > 0 {
+				return util.ErrorJSON(c, http.StatusConflict, "IndexerActive", "The path is being indexed or watched by an indexer", nil)
+			}
+		}
+
+		// The media goes first, so nothing is left behind should this fail part way
+		mediaQuery := aliasMediaQuery(alias)
+		deleted, err := common.ActiveMediaStore.DeleteMatching(&mediaQuery)
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "Failed removing the media", Err: err})
+		}
+		search.InvalidatePerceptualHashes()
+
+		aliasPrefix := alias + "\\"
+		_, err = client.DeleteByQuery().
+			Index(common.MediaIndexName).
+			Type(common.DuplicateTypeName).
+			Query(elastic.NewBoolQuery().
+				Should(elastic.NewPrefixQuery("ignoredpath.keyword", aliasPrefix)).
+				Should(elastic.NewPrefixQuery("existingpath.keyword", aliasPrefix)).
+				MinimumNumberShouldMatch(1)).
+			Refresh("true").
+			Do(context.TODO())
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "Failed removing the duplicates", Err: err})
+		}
+
+		err = os.RemoveAll(path.Join(common.ThumbnailDirectory, alias))
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "Failed removing the thumbnails", Err: err})
+		}
+
+		err = common.RemoveAlias(alias)
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "Failed removing the path", Err: err})
+		}
+
+		fc.LogInt64("mediaRemoved", deleted)
+		response := make(map[string]interface{})
+		response["alias"] = alias
+		response["mediaRemoved"] = deleted
+		return c.JSON(http.StatusOK, response)
+	})
+}
+
+// Points the library root at 'path', where its files have been moved to. As the media is indexed by
+// alias, nothing is indexed again
+func relocatePathAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	alias := c.Param("alias")
+	fc.Log("alias", alias)
+
+	return fc.Time("relocatepath", func() error {
+		if !common.IsValidAlias(alias) {
+			return util.ErrorJSON(c, http.StatusNotFound, "NoSuchAlias", "", nil)
+		}
+		if GetIndexerStatus().Active {
+			return util.ErrorJSON(c, http.StatusConflict, "IndexerActive", "Paths can't be relocated while indexing", nil)
+		}
+
+		libraryPath := validLibraryPath(c.QueryParam("path"), alias)
+		fc.Log("path", libraryPath)
+
+		err := common.RelocateAlias(alias, libraryPath)
+		if err != nil {
+			panic(&util.InvalidRequest{Message: fmt.Sprintf("Failed relocating to '%s'", libraryPath), Err: err})
+		}
+
+		var relocated common.AliasDocument
+		common.VisitAllPaths(func(ad common.AliasDocument) {
+			if ad.Alias == alias {
+				relocated = ad
+			}
+		})
+		return c.JSON(http.StatusOK, toLibraryPath(relocated, 0))
+	})
+}
+
+// Matches the media under the alias
+func aliasMediaQuery(alias string) common.MediaQuery {
+	return common.MediaQuery{PathPrefix: alias + "\\"}
+}
+
+// Returns the cleaned path, which must be an existing folder. It can't be within, or contain, another
+// library root (other than 'ignoreAlias'), as the same files would be indexed twice
+func validLibraryPath(libraryPath string, ignoreAlias string) string {
+	if len(libraryPath) < 1 {
+		panic(&util.InvalidRequest{Message: "'path' query parameter missing"})
+	}
+	if !filepath.IsAbs(libraryPath) {
+		panic(&util.InvalidRequest{Message: fmt.Sprintf("'%s' isn't an absolute path", libraryPath)})
+	}
+	libraryPath = filepath.Clean(libraryPath)
+
+	info, err := os.Stat(libraryPath)
+	if err != nil {
+		panic(&util.InvalidRequest{Message: fmt.Sprintf("Unable to access '%s'", libraryPath), Err: err})
+	}
+	if !info.IsDir() {
+		panic(&util.InvalidRequest{Message: fmt.Sprintf("'%s' isn't a folder", libraryPath)})
+	}
+
+	common.VisitAllPaths(func(alias common.AliasDocument) {
+		if alias.Alias == ignoreAlias {
+			return
+		}
+		if strings.EqualFold(libraryPath, filepath.Clean(alias.Path)) {
+			panic(&util.InvalidRequest{Message: fmt.Sprintf("'%s' is already a library path", libraryPath)})
+		}
+		if isWithin(libraryPath, alias.Path) || isWithin(alias.Path, libraryPath) {
+			panic(&util.InvalidRequest{Message: fmt.Sprintf("'%s' overlaps the library path '%s'", libraryPath, alias.Path)})
+		}
+	})
+	return libraryPath
+}
+
+func isWithin(child string, parent string) bool {
+	child = strings.ToLower(filepath.Clean(child))
+	parent = strings.ToLower(filepath.Clean(parent))
+	return strings.HasPrefix(child, strings.TrimSuffix(parent, string(filepath.Separator))+string(filepath.Separator))
+}
+
+func toLibraryPath(alias common.AliasDocument, mediaCount int64) LibraryPath {
+	lp := LibraryPath{
+		Alias:      alias.Alias,
+		Path:       alias.Path,
+		Added:      alias.DateAdded,
+		MediaCount: mediaCount,
+	}
+	if !alias.DateLastIndexed.IsZero() {
+		lp.LastIndexed = &alias.DateLastIndexed
+	}
+	return lp
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kevintavog/findaphoto/findaphotoserver/scheduler"
+	"github.com/kevintavog/findaphoto/findaphotoserver/util"
+	"github.com/labstack/echo"
+)
+
+// The indexing schedule configuration; the next runs are in the 'schedule' property of 'info'
+func scheduleAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	return fc.Time("schedule", func() error {
+		return c.JSON(http.StatusOK, scheduler.CurrentConfiguration())
+	})
+}
+
+// Replaces the indexing schedule configuration with the one in the body. The schedules are cron
+// expressions; roots (by alias) without their own use the default one
+func updateScheduleAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	return fc.Time("updateschedule", func() error {
+		config := scheduler.Configuration{}
+		err := json.NewDecoder(c.Request().Body).Decode(&config)
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "Expected a JSON schedule configuration", Err: err})
+		}
+
+		err = scheduler.Update(config)
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "Failed updating the schedule", Err: err})
+		}
+		fc.LogBool("enabled", config.Enabled)
+		return c.JSON(http.StatusOK, scheduler.CurrentStatus())
+	})
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/findaphotoserver/search"
+	"github.com/kevintavog/findaphoto/findaphotoserver/util"
+	"github.com/labstack/echo"
+)
+
+// Near-duplicate clusters are found by comparing hashes sharing a band of bits; large distances
+// make the bands too small to be useful
+const maxNearDuplicatesDistance = 10
+
+func similarAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	similarOptions := populateSimilarOptions(fc)
+	propertiesFilter := getPropertiesFilter(c.QueryParam("properties"))
+
+	return fc.Time("similar", func() error {
+		searchResult, err := similarOptions.Search()
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "SearchFailed", Err: err})
+		}
+
+		fc.LogInt64("totalMatches", searchResult.TotalMatches)
+		fc.LogInt("itemCount", searchResult.ResultCount)
+		return c.JSON(http.StatusOK, filterResults(searchResult, propertiesFilter))
+	})
+}
+
+func nearDuplicatesAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	nearDuplicatesOptions := populateNearDuplicatesOptions(fc)
+	propertiesFilter := getPropertiesFilter(c.QueryParam("properties"))
+
+	return fc.Time("nearduplicates", func() error {
+		searchResult, err := nearDuplicatesOptions.Search()
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "SearchFailed", Err: err})
+		}
+
+		fc.LogInt64("totalMatches", searchResult.TotalMatches)
+		fc.LogInt("itemCount", searchResult.ResultCount)
+		return c.JSON(http.StatusOK, filterResults(searchResult, propertiesFilter))
+	})
+}
+
+func populateSimilarOptions(fc *util.FpContext) *search.SimilarOptions {
+	id := fc.QueryParam("id")
+	if len(id) < 1 {
+		panic(&util.InvalidRequest{Message: "'id' query parameter missing"})
+	}
+
+	similarOptions := search.NewSimilarOptions(id)
+	similarOptions.MaxDistance = fc.IntFromQuery("distance", similarOptions.MaxDistance)
+	if similarOptions.MaxDistance < 0 || similarOptions.MaxDistance > common.MaxPerceptualHashDistance {
+		panic(&util.InvalidRequest{Message: fmt.Sprintf("distance must be between 0 and %d, inclusive", common.MaxPerceptualHashDistance)})
+	}
+
+	similarOptions.Count = fc.IntFromQuery("count", similarOptions.Count)
+	if similarOptions.Count < 1 || similarOptions.Count > 100 {
+		panic(&util.InvalidRequest{Message: "count must be between 1 and 100, inclusive"})
+	}
+
+	similarOptions.Index = fc.IntFromQuery("first", 1) - 1
+	return similarOptions
+}
+
+func populateNearDuplicatesOptions(fc *util.FpContext) *search.NearDuplicatesOptions {
+	nearDuplicatesOptions := search.NewNearDuplicatesOptions()
+	nearDuplicatesOptions.MaxDistance = fc.IntFromQuery("distance", nearDuplicatesOptions.MaxDistance)
+	if nearDuplicatesOptions.MaxDistance < 0 || nearDuplicatesOptions.MaxDistance > maxNearDuplicatesDistance {
+		panic(&util.InvalidRequest{Message: fmt.Sprintf("distance must be between 0 and %d, inclusive", maxNearDuplicatesDistance)})
+	}
+
+	nearDuplicatesOptions.Count = fc.IntFromQuery("count", nearDuplicatesOptions.Count)
+	if nearDuplicatesOptions.Count < 1 || nearDuplicatesOptions.Count > 100 {
+		panic(&util.InvalidRequest{Message: "count must be between 1 and 100, inclusive"})
+	}
+
+	nearDuplicatesOptions.Index = fc.IntFromQuery("first", 1) - 1
+	return nearDuplicatesOptions
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/findaphotoserver/util"
+	"github.com/labstack/echo"
+)
+
+type WarningCount struct {
+	Code  string `json:"code"`
+	Count int64  `json:"count"`
+	Query string `json:"query"` // For the search API, to list the media with the warning
+}
+
+// The warning codes and how many media have each, most common first. Only warnings with codes are
+// counted, so each count matches its query; media whose warnings haven't been migrated to codes yet
+// (by the indexer's --migrate-warnings) are counted in 'unmigratedCount'. 'q' limits the media counted,
+// with the query string syntax
+func warningsAPI(c echo.Context) error {
+	fc := c.(*util.FpContext)
+	queryString := c.QueryParam("q")
+
+	return fc.Time("warnings", func() error {
+		if len(queryString) > 0 {
+			fc.Log("q", queryString)
+		}
+
+		result, err := common.ActiveMediaStore.Search(&common.MediaSearchOptions{
+			Query:        common.MediaQuery{Text: queryString},
+			Size:         0,
+			Aggregations: []*common.TermsAggregation{{Name: "codes", Field: "warningentries.code", Size: 100}},
+		})
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "Failed searching for warnings", Err: err})
+		}
+
+		counts := make(map[string]int64)
+		for _, bucket := range result.Aggregations["codes"] {
+			counts[fmt.Sprint(bucket.Key)] += bucket.Count
+		}
+
+		warnings := make([]WarningCount, 0)
+		for code, count := range counts {
+			if count > 0 {
+				warnings = append(warnings, WarningCount{
+					Code:  code,
+					Count: count,
+					Query: fmt.Sprintf("warningentries.code:\"%s\"", code),
+				})
+			}
+		}
+		sort.Slice(warnings, func(i, j int) bool {
+			if warnings[i].Count == warnings[j].Count {
+				return warnings[i].Code < warnings[j].Code
+			}
+			return warnings[i].Count > warnings[j].Count
+		})
+
+		unmigrated, err := common.ActiveMediaStore.Search(&common.MediaSearchOptions{
+			Query: common.MediaQuery{Text: queryString, Exists: []string{"warnings"}},
+			Size:  0,
+		})
+		if err != nil {
+			panic(&util.InvalidRequest{Message: "Failed counting unmigrated warnings", Err: err})
+		}
+
+		response := make(map[string]interface{})
+		response["warnings"] = warnings
+		response["unmigratedCount"] = unmigrated.TotalHits
+		return c.JSON(http.StatusOK, response)
+	})
+}
+
+// The warnings of the media, including those from before there were codes; nil if there are none
+func mediaWarnings(media *common.Media) []common.Warning {
+	if len(media.Warnings) == 0 && len(media.LegacyWarnings) == 0 {
+		return nil
+	}
+
+	warnings := append([]common.Warning{}, media.Warnings...)
+	for _, message := range media.LegacyWarnings {
+		warnings = append(warnings, common.ClassifyWarning(message))
+	}
+	return warnings
+}
@@ -5,9 +5,6 @@ import (
 	"net/url"
 	"strings"
 
-	"golang.org/x/net/context"
-	"gopkg.in/olivere/elastic.v5"
-
 	"github.com/kevintavog/findaphoto/common"
 	"github.com/kevintavog/findaphoto/findaphotoserver/util"
 	"github.com/labstack/echo"
@@ -35,18 +32,13 @@ func mediaFiles(c echo.Context) error {
 			return util.ErrorJSON(c, http.StatusNotFound, "invalidMediaId", "", err)
 		}
 
-		client := common.CreateClient()
-		searchResult, err := client.Search().
-			Index(common.MediaIndexName).
-			Type(common.MediaTypeName).
-			Query(elastic.NewTermQuery("_id", mediaID)).
-			Do(context.TODO())
+		media, err := common.ActiveMediaStore.GetMedia(mediaID)
 		if err != nil {
 			fc.LogBool("invalidMediaPrefix", true)
 			return c.NoContent(http.StatusNotFound)
 		}
 
-		if searchResult.TotalHits() == 0 {
+		if media == nil {
 			fc.LogBool("notInRepository", true)
 			return c.NoContent(http.StatusNotFound)
 		}
@@ -15,8 +15,6 @@ import (
 
 	"github.com/labstack/echo"
 	"github.com/nfnt/resize"
-	"golang.org/x/net/context"
-	"gopkg.in/olivere/elastic.v5"
 
 	"github.com/kevintavog/findaphoto/common"
 	"github.com/kevintavog/findaphoto/findaphotoserver/configuration"
@@ -47,18 +45,13 @@ func slideFiles(c echo.Context) error {
 			return util.ErrorJSON(c, http.StatusNotFound, "invalidSlideId", "", err)
 		}
 
-		client := common.CreateClient()
-		searchResult, err := client.Search().
-			Index(common.MediaIndexName).
-			Type(common.MediaTypeName).
-			Query(elastic.NewTermQuery("_id", slideID)).
-			Do(context.TODO())
+		media, err := common.ActiveMediaStore.GetMedia(slideID)
 		if err != nil {
 			fc.LogBool("invalidSlidePrefix", true)
 			return c.NoContent(http.StatusNotFound)
 		}
 
-		if searchResult.TotalHits() == 0 {
+		if media == nil {
 			fc.LogBool("notInRepository", true)
 			return c.NoContent(http.StatusNotFound)
 		}
@@ -74,11 +67,9 @@ func slideFiles(c echo.Context) error {
 			return c.NoContent(http.StatusNotFound)
 		}
 
-		var buffer bytes.Buffer
-		if configuration.Current.VipsExists {
-			buffer, err = generateVipsSlide(slideFilename)
-		} else {
-			buffer, err = generateNfntSlide(slideFilename)
+		buffer, err := generateSlide(slideFilename)
+		if err != nil && common.HasEmbeddedPreview(slideFilename) {
+			buffer, err = generateSlideFromEmbeddedPreview(slideFilename)
 		}
 
 		if err != nil {
@@ -89,6 +80,32 @@ func slideFiles(c echo.Context) error {
 	})
 }
 
+func generateSlide(imageFilename string) (bytes.Buffer, error) {
+	if configuration.Current.VipsExists {
+		return generateVipsSlide(imageFilename)
+	}
+	return generateNfntSlide(imageFilename)
+}
+
+// Raw & HEIC files usually can't be decoded directly, but contain a JPEG preview that can be
+func generateSlideFromEmbeddedPreview(imageFilename string) (bytes.Buffer, error) {
+	var buffer bytes.Buffer
+
+	tmpFilename := path.Join(os.TempDir(), "findAPhoto", "previews", uuid.NewV4().String()+".JPG")
+	err := common.CreateDirectory(path.Dir(tmpFilename))
+	if err != nil {
+		return buffer, err
+	}
+	defer os.Remove(tmpFilename)
+
+	err = common.ExtractEmbeddedPreview(imageFilename, tmpFilename)
+	if err != nil {
+		return buffer, err
+	}
+
+	return generateSlide(tmpFilename)
+}
+
 func generateNfntSlide(imageFilename string) (bytes.Buffer, error) {
 	var buffer bytes.Buffer
 
@@ -1,7 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/context"
@@ -9,32 +17,74 @@ import (
 	"github.com/ian-kent/go-log/log"
 	"github.com/kevintavog/findaphoto/common"
 	"github.com/kevintavog/findaphoto/findaphotoserver/configuration"
+	"github.com/kevintavog/findaphoto/findaphotoserver/controllers/api"
+	"github.com/kevintavog/findaphoto/findaphotoserver/search"
 
 	"gopkg.in/olivere/elastic.v5"
 )
 
-func runIndexer(force bool, devMode bool) {
-	log.Info("Starting indexing")
+var indexerStatusLock sync.Mutex
+var indexerStatus api.IndexerStatus
+var activeIndexerRuns int
+var indexingPaths = make(map[string]int)
 
-	var args = []string{
-		"-s", configuration.Current.ElasticSearchURL,
-		"-l", configuration.Current.LocationLookupURL,
-		"-r", configuration.Current.RedisURL,
-		"-a", common.AliasPathOverride}
+// Indexing runs may overlap each other, but not a geotag run - it updates media the indexer may be writing
+var indexerRunLock sync.RWMutex
 
-	if force {
-		args = append(args, "--reindex")
-	}
+var dryRunLock sync.Mutex
+var dryRunStatus = api.DryRunStatus{Reports: []*common.DryRunReport{}}
 
-	if devMode {
-		args = append(args, "-i")
-		args = append(args, "dev-")
+func currentIndexerStatus() api.IndexerStatus {
+	indexerStatusLock.Lock()
+	defer indexerStatusLock.Unlock()
+	return indexerStatus
+}
+
+func currentDryRunStatus() api.DryRunStatus {
+	dryRunLock.Lock()
+	defer dryRunLock.Unlock()
+	return dryRunStatus
+}
+
+// True if the path is being indexed
+func isIndexing(path string) bool {
+	indexerStatusLock.Lock()
+	defer indexerStatusLock.Unlock()
+	return indexingPaths[path] > 0
+}
+
+func runIndexer(force bool, dryRun bool, devMode bool) {
+	log.Info("Starting indexing (dry run: %t)", dryRun)
+
+	beginIndexerRun()
+	defer endIndexerRun()
+
+	args := indexingArgs(force, devMode)
+
+	if dryRun {
+		args = append(args, "--dry-run")
+		startedTime := time.Now()
+		dryRunLock.Lock()
+		dryRunStatus = api.DryRunStatus{Active: true, Started: &startedTime, Reports: []*common.DryRunReport{}}
+		dryRunLock.Unlock()
+
+		defer func() {
+			completedTime := time.Now()
+			dryRunLock.Lock()
+			dryRunStatus.Active = false
+			dryRunStatus.Completed = &completedTime
+			dryRunLock.Unlock()
+		}()
 	}
 
 	countPaths := 0
 	common.VisitAllPaths(func(alias common.AliasDocument) {
 		countPaths++
-		timeAndRunIndexer(args, alias.Path)
+		if dryRun {
+			dryRunIndexer(args, alias.Path)
+		} else {
+			timeAndRunIndexer(args, alias.Path)
+		}
 	})
 
 	// If the repository is empty and there's a default path, index the default path
@@ -47,7 +97,40 @@ func runIndexer(force bool, devMode bool) {
 		}
 	}
 
-	// Re-load the aliases - at least update the last indexed timestamp
+	reloadAliases()
+}
+
+// Indexes a single path, for the scheduler
+func runPathIndexer(path string, force bool, devMode bool) {
+	log.Info("Starting indexing of '%s' (force: %t)", path, force)
+
+	beginIndexerRun()
+	defer endIndexerRun()
+
+	timeAndRunIndexer(indexingArgs(force, devMode), path)
+	reloadAliases()
+}
+
+func beginIndexerRun() {
+	indexerRunLock.RLock()
+	indexerStatusLock.Lock()
+	activeIndexerRuns++
+	indexerStatus.Active = true
+	indexerStatusLock.Unlock()
+}
+
+func endIndexerRun() {
+	search.InvalidatePerceptualHashes()
+
+	indexerStatusLock.Lock()
+	activeIndexerRuns--
+	indexerStatus.Active = activeIndexerRuns > 0
+	indexerStatusLock.Unlock()
+	indexerRunLock.RUnlock()
+}
+
+// Re-load the aliases - at least update the last indexed timestamp
+func reloadAliases() {
 	client, err := elastic.NewSimpleClient(
 		elastic.SetURL(common.ElasticSearchServer),
 		elastic.SetSniff(false))
@@ -62,17 +145,207 @@ func runIndexer(force bool, devMode bool) {
 	common.InitializeAliases(client)
 }
 
+// The arguments the indexer is run with to index a path
+func indexingArgs(force bool, devMode bool) []string {
+	args := append(indexerArgs(), "--progress")
+
+	if force {
+		args = append(args, "--reindex")
+	}
+
+	if len(configuration.Current.SupportedExtensions) > 0 {
+		args = append(args, "-e", strings.Join(configuration.Current.SupportedExtensions, ","))
+	}
+
+	for _, pattern := range configuration.Current.ExcludePatterns {
+		args = append(args, "-x", pattern)
+	}
+
+	if devMode {
+		args = append(args, "-i")
+		args = append(args, "dev-")
+	}
+	return args
+}
+
+// The arguments every mode of the indexer is run with
+func indexerArgs() []string {
+	var args = []string{
+		"-s", configuration.Current.ElasticSearchURL,
+		"-r", configuration.Current.RedisURL,
+		"-a", common.AliasPathOverride}
+
+	if configuration.Current.LocationLookupURL != "" {
+		args = append(args, "-l", configuration.Current.LocationLookupURL)
+	} else if configuration.Current.GeoNamesDirectory != "" {
+		args = append(args, "--geonames", configuration.Current.GeoNamesDirectory)
+	}
+
+	args = append(args, "--gpx", gpxDirectory())
+	if configuration.Current.GpxMaxGapSeconds > 0 {
+		args = append(args, "--gpx-max-gap", fmt.Sprintf("%d", configuration.Current.GpxMaxGapSeconds))
+	}
+	if configuration.Current.GpxOffsetSeconds != 0 {
+		args = append(args, "--gpx-offset", fmt.Sprintf("%d", configuration.Current.GpxOffsetSeconds))
+	}
+	if configuration.Current.GpxWriteBack {
+		args = append(args, "--gpx-write")
+	}
+	return args
+}
+
+func gpxDirectory() string {
+	if configuration.Current.GpxDirectory != "" {
+		return configuration.Current.GpxDirectory
+	}
+	return common.DefaultGpxDirectory()
+}
+
+// Gives indexed media without a location one from the GPX tracks, after tracks are uploaded. It
+// waits for indexing runs to finish, and they wait for it; it's reported in the indexer status
+func runGeotagger() {
+	indexerRunLock.Lock()
+	defer indexerRunLock.Unlock()
+
+	log.Info("Locating media from GPX tracks")
+	startTime := time.Now()
+	indexerStatusLock.Lock()
+	activeIndexerRuns++
+	indexerStatus.Active = true
+	indexerStatus.Geotagging = true
+	indexerStatus.Path = ""
+	indexerStatus.Started = &startTime
+	indexerStatus.Progress = nil
+	indexerStatusLock.Unlock()
+
+	output, err := exec.Command(common.IndexerPath, append(indexerArgs(), "--geotag")...).CombinedOutput()
+
+	completedTime := time.Now()
+	indexerStatusLock.Lock()
+	activeIndexerRuns--
+	indexerStatus.Active = activeIndexerRuns > 0
+	indexerStatus.Geotagging = false
+	indexerStatus.LastCompleted = &completedTime
+	indexerStatus.LastError = ""
+	if err != nil {
+		indexerStatus.LastError = fmt.Sprintf("Locating media from GPX tracks failed: %s", err.Error())
+	}
+	indexerStatusLock.Unlock()
+
+	if err != nil {
+		log.Error("Failed locating media from GPX tracks: %s\n%s", err.Error(), strings.TrimSpace(string(output)))
+		return
+	}
+	log.Info("Finished locating media from GPX tracks in %1.1f seconds", completedTime.Sub(startTime).Seconds())
+}
+
 func timeAndRunIndexer(args []string, path string) {
 
 	startTime := time.Now()
 
+	indexerStatusLock.Lock()
+	indexerStatus.Path = path
+	indexerStatus.Started = &startTime
+	indexerStatus.Progress = nil
+	indexingPaths[path]++
+	indexerStatusLock.Unlock()
+
+	defer func() {
+		indexerStatusLock.Lock()
+		indexingPaths[path]--
+		if indexingPaths[path] < 1 {
+			delete(indexingPaths, path)
+		}
+		indexerStatusLock.Unlock()
+	}()
+
 	pathAndArgs := append(args, "-p")
 	pathAndArgs = append(pathAndArgs, path)
-	err := exec.Command(common.IndexerPath, pathAndArgs...).Run()
-	duration := time.Now().Sub(startTime).Seconds()
+	cmd := exec.Command(common.IndexerPath, pathAndArgs...)
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+		if err == nil {
+			readIndexerProgress(stdout)
+			err = cmd.Wait()
+		}
+	}
+
+	completedTime := time.Now()
+	duration := completedTime.Sub(startTime).Seconds()
 	log.Info("Finished indexing '%v' in %1.1f seconds", path, duration)
 
+	indexerStatusLock.Lock()
+	indexerStatus.LastCompleted = &completedTime
+	indexerStatus.LastError = ""
+	if err != nil {
+		indexerStatus.LastError = err.Error()
+	}
+	indexerStatusLock.Unlock()
+
 	if err != nil {
 		log.Error("Failed executing indexer for '%s': %s", path, err.Error())
 	}
 }
+
+// Each path gets its own report file, which is collected once that indexer run completes
+func dryRunIndexer(args []string, path string) {
+	reportFile, err := ioutil.TempFile("", "findaphoto-dryrun-")
+	if err != nil {
+		log.Error("Failed creating the dry run report file for '%s': %s", path, err.Error())
+		return
+	}
+	reportFilename := reportFile.Name()
+	reportFile.Close()
+	defer os.Remove(reportFilename)
+
+	reportArgs := append([]string{}, args...)
+	reportArgs = append(reportArgs, "--report", reportFilename)
+	timeAndRunIndexer(reportArgs, path)
+
+	data, err := ioutil.ReadFile(reportFilename)
+	if err != nil {
+		log.Error("Failed reading dry run report for '%s': %s", path, err.Error())
+		return
+	}
+
+	report := &common.DryRunReport{}
+	err = json.Unmarshal(data, report)
+	if err != nil {
+		log.Error("Failed parsing dry run report for '%s': %s", path, err.Error())
+		return
+	}
+
+	dryRunLock.Lock()
+	dryRunStatus.Reports = append(dryRunStatus.Reports, report)
+	dryRunLock.Unlock()
+}
+
+// The indexer writes progress as JSON lines to stdout, mixed in with its console logging
+func readIndexerProgress(reader io.Reader) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+
+		progress := &common.IndexProgress{}
+		err := json.Unmarshal([]byte(line), progress)
+		if err != nil || progress.Type != common.IndexProgressType {
+			continue
+		}
+
+		indexerStatusLock.Lock()
+		indexerStatus.Progress = progress
+		indexerStatusLock.Unlock()
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Warn("Failed reading indexer progress: %s", err.Error())
+
+		// Keep the indexer from blocking on a full pipe
+		io.Copy(ioutil.Discard, reader)
+	}
+}
@@ -0,0 +1,167 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A parsed cron expression: minute, hour, day of month, month & day of week, in local time.
+// Each field is '*', a value, a range ('1-5') or a list of them ('1,3,5'), optionally with a
+// step ('*/15', '0-30/10'). Sunday is 0 or 7. '@hourly', '@daily', '@nightly' & '@weekly' are
+// also understood
+type Schedule struct {
+	Expression string
+
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	daysOfWeek  uint64
+
+	// When both days are restricted, either matching is enough - as with cron
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+var shortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@nightly": "0 2 * * *",
+	"@weekly":  "0 0 * * 0",
+}
+
+// Runs are looked for this far ahead, so impossible dates ('0 0 31 2 *') don't loop forever
+const maxLookAheadYears = 5
+
+func ParseSchedule(expression string) (*Schedule, error) {
+	expression = strings.TrimSpace(expression)
+	fields := strings.Fields(expression)
+	if full, ok := shortcuts[strings.ToLower(expression)]; ok {
+		fields = strings.Fields(full)
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("'%s' should have 5 fields (minute hour day-of-month month day-of-week)", expression)
+	}
+
+	s := &Schedule{Expression: expression}
+	var err error
+	if s.minutes, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("'%s' has an invalid minute: %s", expression, err.Error())
+	}
+	if s.hours, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("'%s' has an invalid hour: %s", expression, err.Error())
+	}
+	if s.daysOfMonth, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("'%s' has an invalid day of the month: %s", expression, err.Error())
+	}
+	if s.months, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("'%s' has an invalid month: %s", expression, err.Error())
+	}
+	if s.daysOfWeek, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("'%s' has an invalid day of the week: %s", expression, err.Error())
+	}
+
+	// Sunday is both 0 & 7
+	if s.daysOfWeek&(1<<7) != 0 {
+		s.daysOfWeek |= 1
+	}
+	s.anyDayOfMonth = strings.HasPrefix(fields[2], "*")
+	s.anyDayOfWeek = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// The first time after the given one that matches, zero if there isn't one in the next few years.
+// Times are matched by the clock, in the location of 'after': when the clocks go back, the repeated
+// times run once; when they go forward, the skipped times run as the clocks change
+func (s *Schedule) Next(after time.Time) time.Time {
+	clock := clockTime(after).Truncate(time.Minute).Add(time.Minute)
+	limit := clock.AddDate(maxLookAheadYears, 0, 0)
+	for clock.Before(limit) {
+		year, month, day := clock.Date()
+		switch {
+		case s.months&(1<<uint(month)) == 0:
+			clock = time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(clock):
+			clock = time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+		case s.hours&(1<<uint(clock.Hour())) == 0:
+			clock = clock.Truncate(time.Hour).Add(time.Hour)
+		case s.minutes&(1<<uint(clock.Minute())) == 0:
+			clock = clock.Add(time.Minute)
+		default:
+			// A time skipped when the clocks go forward is normalized to one before they change
+			t := time.Date(year, month, day, clock.Hour(), clock.Minute(), 0, 0, after.Location())
+			for clockTime(t).Before(clock) {
+				t = t.Add(time.Minute)
+			}
+			if t.After(after) {
+				return t
+			}
+			clock = clock.Add(time.Minute)
+		}
+	}
+	return time.Time{}
+}
+
+// The time shown on the clock, as UTC, which has no daylight saving changes
+func clockTime(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dayOfMonth := s.daysOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.daysOfWeek&(1<<uint(t.Weekday())) != 0
+	if s.anyDayOfMonth || s.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Returns a bit for each value the field matches
+func parseField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if pos := strings.Index(part, "/"); pos != -1 {
+			var err error
+			step, err = strconv.Atoi(part[pos+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step in '%s'", part)
+			}
+			part = part[:pos]
+		}
+
+		first, last := min, max
+		if part != "*" {
+			if pos := strings.Index(part, "-"); pos != -1 {
+				var err error
+				if first, err = strconv.Atoi(part[:pos]); err != nil {
+					return 0, fmt.Errorf("bad range '%s'", part)
+				}
+				if last, err = strconv.Atoi(part[pos+1:]); err != nil {
+					return 0, fmt.Errorf("bad range '%s'", part)
+				}
+			} else {
+				value, err := strconv.Atoi(part)
+				if err != nil {
+					return 0, fmt.Errorf("bad value '%s'", part)
+				}
+				first = value
+				last = value
+				if step > 1 {
+					last = max
+				}
+			}
+		}
+
+		if first < min || last > max || first > last {
+			return 0, fmt.Errorf("'%s' is outside %d-%d", part, min, max)
+		}
+		for value := first; value <= last; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
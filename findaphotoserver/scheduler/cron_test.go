@@ -0,0 +1,117 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// Daylight saving starts on March 8th, 2026 (2:00 becomes 3:00) and ends on November 1st (2:00
+// becomes 1:00). October 18th, 2026 is a Sunday
+const testTimeLayout = "2006-01-02 15:04 MST"
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		expression string
+		valid      bool
+	}{
+		{"0 2 * * *", true},
+		{"*/15 9-17 * * 1-5", true},
+		{"0-30/10 0 1,15 1-6 0,7", true},
+		{"@daily", true},
+		{"@Weekly", true},
+		{"", false},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"@monthly", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"a * * * *", false},
+		{"1- * * * *", false},
+	}
+
+	for _, test := range tests {
+		schedule, err := ParseSchedule(test.expression)
+		if test.valid && err != nil {
+			t.Errorf("'%s' should be valid: %s", test.expression, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("'%s' should be invalid, got %+v", test.expression, schedule)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		after      string
+		next       string
+	}{
+		{"later today", "0 2 * * *", "2026-10-18 01:00 PDT", "2026-10-18 02:00 PDT"},
+		{"not the same minute", "0 2 * * *", "2026-10-18 02:00 PDT", "2026-10-19 02:00 PDT"},
+		{"every 15 minutes", "*/15 * * * *", "2026-10-18 01:07 PDT", "2026-10-18 01:15 PDT"},
+		{"next year", "30 1 1 1 *", "2026-10-18 01:07 PDT", "2027-01-01 01:30 PST"},
+		{"sunday is 0", "0 3 * * 0", "2026-10-18 03:30 PDT", "2026-10-25 03:00 PDT"},
+		{"sunday is 7", "0 3 * * 7", "2026-10-18 03:30 PDT", "2026-10-25 03:00 PDT"},
+		{"shortcut", "@weekly", "2026-10-18 01:07 PDT", "2026-10-25 00:00 PDT"},
+		{"ranges & steps", "0-10/5 9-17 * 1-6 1-5", "2026-10-18 01:07 PDT", "2027-01-01 09:00 PST"},
+
+		// When both days are given, either one matching is enough
+		{"day of week or month, the week", "0 0 13 * 5", "2026-10-18 00:00 PDT", "2026-10-23 00:00 PDT"},
+		{"day of week or month, the month", "0 0 13 * 1", "2026-11-10 00:00 PST", "2026-11-13 00:00 PST"},
+		{"day of month only", "0 0 13 * *", "2026-10-18 00:00 PDT", "2026-11-13 00:00 PST"},
+		{"day of week only", "0 0 * * 1", "2026-10-18 00:00 PDT", "2026-10-19 00:00 PDT"},
+		{"a day of month step is a wildcard", "0 0 */10 * 1", "2026-10-18 00:00 PDT", "2026-12-21 00:00 PST"},
+
+		// A time skipped when the clocks go forward runs as they change; a time repeated when they go
+		// back runs once, the first time
+		{"clocks forward", "30 2 * * *", "2026-03-08 00:00 PST", "2026-03-08 03:00 PDT"},
+		{"after clocks forward", "30 2 * * *", "2026-03-08 03:00 PDT", "2026-03-09 02:30 PDT"},
+		{"every minute over clocks forward", "* * * * *", "2026-03-08 01:59 PST", "2026-03-08 03:00 PDT"},
+		{"before clocks back", "30 1 * * *", "2026-11-01 00:00 PDT", "2026-11-01 01:30 PDT"},
+		{"clocks back", "30 1 * * *", "2026-11-01 01:30 PDT", "2026-11-02 01:30 PST"},
+		{"in the repeated hour", "45 * * * *", "2026-11-01 01:10 PST", "2026-11-01 02:45 PST"},
+		{"after the repeated hour", "0 * * * *", "2026-11-01 01:00 PDT", "2026-11-01 02:00 PST"},
+		{"across clocks forward", "0 12 * * *", "2026-03-07 12:00 PST", "2026-03-08 12:00 PDT"},
+	}
+
+	location, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatalf("Unable to load the time zone: %s", err)
+	}
+	for _, test := range tests {
+		schedule, err := ParseSchedule(test.expression)
+		if err != nil {
+			t.Fatalf("%s: '%s' failed parsing: %s", test.name, test.expression, err)
+		}
+
+		next := schedule.Next(testTime(t, test.after, location))
+		if next.Format(testTimeLayout) != test.next {
+			t.Errorf("%s: '%s' after %s should be %s, not %s", test.name, test.expression, test.after, test.next, next.Format(testTimeLayout))
+		}
+	}
+}
+
+func TestNextImpossible(t *testing.T) {
+	schedule, err := ParseSchedule("0 0 31 2 *")
+	if err != nil {
+		t.Fatalf("Failed parsing: %s", err)
+	}
+	if next := schedule.Next(time.Now()); !next.IsZero() {
+		t.Fatalf("There's no February 31st, got %s", next)
+	}
+}
+
+// The zone abbreviation picks the time when the clocks go back
+func testTime(t *testing.T, value string, location *time.Location) time.Time {
+	parsed, err := time.ParseInLocation(testTimeLayout, value, location)
+	if err != nil {
+		t.Fatalf("Bad test time '%s': %s", value, err)
+	}
+	return parsed
+}
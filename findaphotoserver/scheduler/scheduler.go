@@ -0,0 +1,303 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ian-kent/go-log/log"
+	"github.com/kevintavog/findaphoto/common"
+)
+
+// Runs the indexer for the path; it returns when the run completes
+type RunIndexerFunction func(path string, force bool)
+
+// True if the path is being indexed, by a scheduled run or otherwise
+type IsIndexingFunction func(path string) bool
+
+// The cron expressions a root is indexed on. An empty expression means no runs of that kind
+type RootSchedule struct {
+	Incremental string `json:"incremental"`
+	Forced      string `json:"forced"`
+}
+
+// Roots without their own schedule use the default one. Roots are given by alias
+type Configuration struct {
+	Enabled bool                    `json:"enabled"`
+	Default RootSchedule            `json:"default"`
+	Roots   map[string]RootSchedule `json:"roots,omitempty"`
+}
+
+// The schedule of a root, as surfaced by the API
+type RootStatus struct {
+	Alias           string     `json:"alias"`
+	Path            string     `json:"path"`
+	Incremental     string     `json:"incremental,omitempty"`
+	Forced          string     `json:"forced,omitempty"`
+	NextRun         *time.Time `json:"nextRun,omitempty"`
+	NextRunForced   bool       `json:"nextRunForced"`
+	Running         bool       `json:"running"`
+	LastStarted     *time.Time `json:"lastStarted,omitempty"`
+	LastCompleted   *time.Time `json:"lastCompleted,omitempty"`
+	LastSkipped     *time.Time `json:"lastSkipped,omitempty"`
+	SkippedRunCount int        `json:"skippedRunCount"`
+}
+
+type Status struct {
+	Enabled bool         `json:"enabled"`
+	Roots   []RootStatus `json:"roots"`
+}
+
+var DefaultConfiguration = Configuration{
+	Enabled: true,
+	Default: RootSchedule{
+		Incremental: "0 2 * * *", // Nightly
+		Forced:      "0 3 * * 0", // Sunday mornings
+	},
+}
+
+type rootState struct {
+	running         bool
+	nextRun         time.Time
+	nextRunForced   bool
+	lastStarted     time.Time
+	lastCompleted   time.Time
+	lastSkipped     time.Time
+	skippedRunCount int
+}
+
+var lock sync.Mutex
+var current Configuration
+var configFilename string
+var roots = make(map[string]*rootState)
+var runIndexer RunIndexerFunction
+var isIndexing IsIndexingFunction
+
+// Reads the configuration, writing the default one if there isn't one yet
+func Load(filename string) error {
+	configFilename = filename
+	config := DefaultConfiguration
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		log.Info("Writing the default indexing schedule to %s", filename)
+		return save(config)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return fmt.Errorf("Failed parsing the indexing schedule (%s): %s", filename, err.Error())
+	}
+	if err = validate(config); err != nil {
+		return err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	current = config
+	return nil
+}
+
+// Validates, persists & applies the configuration; the next runs are recalculated
+func Update(config Configuration) error {
+	if err := validate(config); err != nil {
+		return err
+	}
+	if err := save(config); err != nil {
+		return err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, state := range roots {
+		state.nextRun = time.Time{}
+	}
+	return nil
+}
+
+func CurrentConfiguration() Configuration {
+	lock.Lock()
+	defer lock.Unlock()
+	return current
+}
+
+// Checks for due runs once a minute, in the background
+func Start(run RunIndexerFunction, indexing IsIndexingFunction) {
+	runIndexer = run
+	isIndexing = indexing
+	go func() {
+		for {
+			checkRoots(time.Now())
+			now := time.Now()
+			time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		}
+	}()
+}
+
+// The schedule & next run of each root
+func CurrentStatus() Status {
+	config := CurrentConfiguration()
+	status := Status{Enabled: config.Enabled, Roots: make([]RootStatus, 0)}
+
+	lock.Lock()
+	defer lock.Unlock()
+	common.VisitAllPaths(func(alias common.AliasDocument) {
+		schedule := scheduleFor(config, alias.Alias)
+		rs := RootStatus{
+			Alias:       alias.Alias,
+			Path:        alias.Path,
+			Incremental: schedule.Incremental,
+			Forced:      schedule.Forced,
+		}
+
+		state := roots[alias.Alias]
+		if state == nil {
+			state = &rootState{}
+		}
+		nextRun, forced := state.nextRun, state.nextRunForced
+		if nextRun.IsZero() {
+			nextRun, forced = nextRunOf(schedule, time.Now())
+		}
+		if config.Enabled && !nextRun.IsZero() {
+			rs.NextRun = &nextRun
+			rs.NextRunForced = forced
+		}
+		rs.Running = state.running
+		rs.LastStarted = optionalTime(state.lastStarted)
+		rs.LastCompleted = optionalTime(state.lastCompleted)
+		rs.LastSkipped = optionalTime(state.lastSkipped)
+		rs.SkippedRunCount = state.skippedRunCount
+		status.Roots = append(status.Roots, rs)
+	})
+
+	sort.Slice(status.Roots, func(i, j int) bool { return status.Roots[i].Path < status.Roots[j].Path })
+	return status
+}
+
+// Starts the runs that are due. A run is skipped if the previous one for the root is still going
+func checkRoots(now time.Time) {
+	config := CurrentConfiguration()
+	if !config.Enabled {
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	visited := make(map[string]bool)
+	common.VisitAllPaths(func(alias common.AliasDocument) {
+		visited[alias.Alias] = true
+		state := roots[alias.Alias]
+		if state == nil {
+			state = &rootState{}
+			roots[alias.Alias] = state
+		}
+
+		schedule := scheduleFor(config, alias.Alias)
+		if state.nextRun.IsZero() {
+			state.nextRun, state.nextRunForced = nextRunOf(schedule, now)
+			return
+		}
+		if state.nextRun.After(now) {
+			return
+		}
+
+		force := state.nextRunForced
+		state.nextRun, state.nextRunForced = nextRunOf(schedule, now)
+		if state.running || isIndexing(alias.Path) {
+			log.Warn("Skipping the scheduled indexing of '%s', the previous run is still going", alias.Path)
+			state.lastSkipped = now
+			state.skippedRunCount++
+			return
+		}
+
+		log.Info("Starting the scheduled indexing of '%s' (forced: %t)", alias.Path, force)
+		state.running = true
+		state.lastStarted = now
+		go func(path string) {
+			defer func() {
+				lock.Lock()
+				defer lock.Unlock()
+				state.running = false
+				state.lastCompleted = time.Now()
+			}()
+			runIndexer(path, force)
+		}(alias.Path)
+	})
+
+	// Removed roots are forgotten
+	for alias, state := range roots {
+		if !visited[alias] && !state.running {
+			delete(roots, alias)
+		}
+	}
+}
+
+// When both kinds of runs are due at the same time, the forced one is run
+func nextRunOf(schedule RootSchedule, after time.Time) (time.Time, bool) {
+	var next time.Time
+	if s, err := ParseSchedule(schedule.Incremental); err == nil {
+		next = s.Next(after)
+	}
+	if s, err := ParseSchedule(schedule.Forced); err == nil {
+		forced := s.Next(after)
+		if !forced.IsZero() && (next.IsZero() || !forced.After(next)) {
+			return forced, true
+		}
+	}
+	return next, false
+}
+
+func scheduleFor(config Configuration, alias string) RootSchedule {
+	if schedule, ok := config.Roots[alias]; ok {
+		return schedule
+	}
+	return config.Default
+}
+
+func validate(config Configuration) error {
+	schedules := map[string]RootSchedule{"default": config.Default}
+	for alias, schedule := range config.Roots {
+		schedules[alias] = schedule
+	}
+
+	for name, schedule := range schedules {
+		for _, expression := range []string{schedule.Incremental, schedule.Forced} {
+			if expression == "" {
+				continue
+			}
+			if _, err := ParseSchedule(expression); err != nil {
+				return fmt.Errorf("Invalid schedule for %s: %s", name, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func save(config Configuration) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(configFilename, data, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed saving the indexing schedule (%s): %s", configFilename, err.Error())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	current = config
+	return nil
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -5,11 +5,6 @@ import (
 	"strings"
 	"time"
 
-	"encoding/json"
-
-	"golang.org/x/net/context"
-	"gopkg.in/olivere/elastic.v5"
-
 	"github.com/kevintavog/findaphoto/common"
 )
 
@@ -19,6 +14,7 @@ type CategoryOptions struct {
 	TagCount       int
 	DateCount      int
 	YearCount      int
+	VideoCount     int
 }
 
 type DrilldownOptions struct {
@@ -52,8 +48,9 @@ type SearchGroup struct {
 }
 
 type MediaHit struct {
-	Media      *common.Media
-	DistanceKm *float64
+	Media        *common.Media
+	DistanceKm   *float64
+	HashDistance *int // Returned only for similar searches
 }
 
 type CategoryResult struct {
@@ -82,6 +79,7 @@ func NewCategoryOptions() *CategoryOptions {
 		TagCount:       0,
 		DateCount:      0,
 		YearCount:      0,
+		VideoCount:     0,
 	}
 }
 
@@ -92,39 +90,26 @@ func NewDrilldownOptions() *DrilldownOptions {
 	}
 }
 
-//-------------------------------------------------------------------------------------------------
-
-// Each search may return specific fields
-type mappingAction func(searchHit *elastic.SearchHit, mediaHit *MediaHit)
 
 //-------------------------------------------------------------------------------------------------
-func invokeSearch(search *elastic.SearchService, query *elastic.Query, groupBy int, categoryOptions *CategoryOptions, drilldownOptions *DrilldownOptions, extraMapping mappingAction) (*SearchResult, error) {
+func invokeSearch(options *common.MediaSearchOptions, groupBy int, categoryOptions *CategoryOptions) (*SearchResult, error) {
 
-	addAggregations(search, categoryOptions)
-	AddDrilldown(search, query, drilldownOptions)
+	options.Aggregations = addAggregations(categoryOptions)
 
-	result, err := search.Do(context.TODO())
+	result, err := common.ActiveMediaStore.Search(options)
 	if err != nil {
 		return nil, err
 	}
 
-	sr := &SearchResult{TotalMatches: result.TotalHits()}
+	sr := &SearchResult{TotalMatches: result.TotalHits}
 
 	if sr.TotalMatches > 0 {
 		var lastGroup = ""
 		sr.Groups = []*SearchGroup{}
 		var group *SearchGroup
 
-		for _, hit := range result.Hits.Hits {
-			mh := &MediaHit{Media: &common.Media{}}
-			err := json.Unmarshal(*hit.Source, mh.Media)
-			if err != nil {
-				return nil, err
-			}
-
-			if extraMapping != nil {
-				extraMapping(hit, mh)
-			}
+		for _, hit := range result.Hits {
+			mh := &MediaHit{Media: hit.Media, DistanceKm: hit.DistanceKm}
 
 			groupName := groupName(mh.Media, groupBy)
 
@@ -139,7 +124,7 @@ func invokeSearch(search *elastic.SearchService, query *elastic.Query, groupBy i
 		}
 	}
 
-	sr.Categories = processAggregations(&result.Aggregations)
+	sr.Categories = processAggregations(result.Aggregations)
 	return sr, nil
 }
 
@@ -163,310 +148,108 @@ func groupName(media *common.Media, groupBy int) string {
 	return ""
 }
 
-func returnFirstMatch(search *elastic.SearchService) (*MediaHit, error) {
-	result, err := search.Do(context.TODO())
+func returnFirstMatch(options *common.MediaSearchOptions) (*MediaHit, error) {
+	options.From = 0
+	options.Size = 1
+	result, err := common.ActiveMediaStore.Search(options)
 	if err != nil {
 		return nil, err
 	}
 
-	if result.TotalHits() > 0 {
-		hit := result.Hits.Hits[0]
-		mh := &MediaHit{Media: &common.Media{}}
-		err := json.Unmarshal(*hit.Source, mh.Media)
-		if err != nil {
-			return nil, err
-		}
-		return mh, nil
+	if len(result.Hits) > 0 {
+		return &MediaHit{Media: result.Hits[0].Media}, nil
 	}
 
 	return nil, nil
 }
 
-func addAggregations(search *elastic.SearchService, categoryOptions *CategoryOptions) {
+func addAggregations(categoryOptions *CategoryOptions) []*common.TermsAggregation {
+	aggregations := make([]*common.TermsAggregation, 0)
 	if categoryOptions.KeywordCount > 0 {
-		search.Aggregation("keywords", elastic.NewTermsAggregation().Field("keywords.value").Size(categoryOptions.KeywordCount))
+		aggregations = append(aggregations, &common.TermsAggregation{Name: "keywords", Field: "keywords.value", Size: categoryOptions.KeywordCount})
 	}
 
 	if categoryOptions.TagCount > 0 {
-		search.Aggregation("tags", elastic.NewTermsAggregation().Field("tags.value").Size(categoryOptions.TagCount))
+		aggregations = append(aggregations, &common.TermsAggregation{Name: "tags", Field: "tags.value", Size: categoryOptions.TagCount})
 	}
 
 	// Dates are in a Year, Month, Day hierarchy - years & days are limited by the requested limit, while all 12 months are returned (if they exist)
 	if categoryOptions.DateCount > 0 {
-		scriptYear := elastic.NewScriptInline("doc['datetime'].date.toString('YYYY')").Lang("painless")
-		scriptMonth := elastic.NewScriptInline("doc['datetime'].date.toString('MMMM')").Lang("painless")
-		scriptDay := elastic.NewScriptInline("doc['datetime'].date.toString('dd')").Lang("painless")
-		search.Aggregation("dateYear", elastic.NewTermsAggregation().Script(scriptYear).Size(categoryOptions.DateCount).
-			SubAggregation("dateMonth", elastic.NewTermsAggregation().Script(scriptMonth).Size(12).
-				SubAggregation("dateDay", elastic.NewTermsAggregation().Script(scriptDay).Size(categoryOptions.DateCount))))
+		aggregations = append(aggregations, &common.TermsAggregation{Name: "dateYear", Field: common.DateYearField, Size: categoryOptions.DateCount,
+			SubAggregation: &common.TermsAggregation{Name: "dateMonth", Field: common.DateMonthField, Size: 12,
+				SubAggregation: &common.TermsAggregation{Name: "dateDay", Field: common.DateDayField, Size: categoryOptions.DateCount}}})
+	}
+
+	// The video details are separate categories, named by field so they can be used for drilldown
+	if categoryOptions.VideoCount > 0 {
+		for _, field := range []string{"videoresolution", "frameratename", "videocodec", "audiocodec", "container"} {
+			aggregations = append(aggregations, &common.TermsAggregation{Name: field, Field: field + ".value", Size: categoryOptions.VideoCount})
+		}
 	}
 
 	// Location name is returned as a Country, State, City, Site hierarchy
 	if categoryOptions.PlacenameCount > 0 {
-		search.Aggregation("countryName", elastic.NewTermsAggregation().Field("countryname.value").Size(categoryOptions.PlacenameCount).
-			SubAggregation("stateName", elastic.NewTermsAggregation().Field("statename.value").Size(categoryOptions.PlacenameCount).
-				SubAggregation("cityName", elastic.NewTermsAggregation().Field("cityname.value").Size(categoryOptions.PlacenameCount).
-					SubAggregation("siteName", elastic.NewTermsAggregation().Field("sitename.value").Size(categoryOptions.PlacenameCount)))))
+		aggregations = append(aggregations, &common.TermsAggregation{Name: "countryName", Field: "countryname.value", Size: categoryOptions.PlacenameCount,
+			SubAggregation: &common.TermsAggregation{Name: "stateName", Field: "statename.value", Size: categoryOptions.PlacenameCount,
+				SubAggregation: &common.TermsAggregation{Name: "cityName", Field: "cityname.value", Size: categoryOptions.PlacenameCount,
+					SubAggregation: &common.TermsAggregation{Name: "siteName", Field: "sitename.value", Size: categoryOptions.PlacenameCount}}}})
 	}
+	return aggregations
 }
 
-func processAggregations(aggregations *elastic.Aggregations) []*CategoryResult {
-	if aggregations == nil || len(*aggregations) < 1 {
+func processAggregations(aggregations map[string][]*common.TermsBucket) []*CategoryResult {
+	if len(aggregations) < 1 {
 		return nil
 	}
 
 	result := make([]*CategoryResult, 0)
 
-	for key, _ := range *aggregations {
-		terms, ok := aggregations.Terms(key)
-		if ok {
-			topCategory := &CategoryResult{}
-			detailsList := make([]*CategoryDetailResult, 0)
-
-			for _, bucket := range terms.Buckets {
-				if bucket.DocCount == 0 {
-					continue
-				}
-
-				v := ""
-				switch bucket.Key.(type) {
-				case string:
-					v = bucket.Key.(string)
-				case float64:
-					fmt.Printf("Handling a float64 conversion for aggregation\n")
-					// Assume it's a time, specifically, milliseconds since the epoch
-					msec := int64(bucket.Key.(float64))
-					v = fmt.Sprintf("%s", time.Unix(msec/1000, 0))
-				}
-
-				detail := &CategoryDetailResult{}
-				detail.Value = v
-				detail.Count = int(bucket.DocCount)
-				detailsList = append(detailsList, detail)
-
-				// ElasticSearch doesn't return aggregations in buckets EXCEPT for sub-aggregates. But the conversion to a Go
-				// response always includes aggregates. The comparison of length is to filter out the expected 'key' & 'doc_count'
-				// fields, allowing the code to get the actual values of interest.
-				if bucket.Aggregations != nil && len(bucket.Aggregations) > 2 {
-					detail.Field, detail.Children = processDetailAggregations(&bucket.Aggregations)
-				}
-			}
+	for key, buckets := range aggregations {
+		topCategory := &CategoryResult{}
+		detailsList := processBuckets(buckets)
 
-			topCategory.Field = key
-			if len(detailsList) > 0 {
-				topCategory.Details = detailsList
-				result = append(result, topCategory)
-			}
+		topCategory.Field = key
+		if len(detailsList) > 0 {
+			topCategory.Details = detailsList
+			result = append(result, topCategory)
 		}
 	}
 
 	return result
 }
 
-func processDetailAggregations(aggregations *elastic.Aggregations) (*string, []*CategoryDetailResult) {
-	if aggregations == nil || len(*aggregations) < 1 {
-		return nil, nil
-	}
-
+func processBuckets(buckets []*common.TermsBucket) []*CategoryDetailResult {
 	result := make([]*CategoryDetailResult, 0)
 
-	var key string
-	for k, _ := range *aggregations {
-		terms, ok := aggregations.Terms(k)
-		if ok {
-			key = k
-			for _, bucket := range terms.Buckets {
-				if bucket.DocCount == 0 {
-					continue
-				}
-
-				v := ""
-				switch bucket.Key.(type) {
-				case string:
-					v = bucket.Key.(string)
-				case float64:
-					fmt.Printf("Handling a float64 conversion for aggregation\n")
-					// Assume it's a time, specifically, milliseconds since the epoch
-					msec := int64(bucket.Key.(float64))
-					v = fmt.Sprintf("%s", time.Unix(msec/1000, 0))
-				default:
-					v = "error"
-					fmt.Printf("Unhandled type '%v'\n", bucket.Key)
-				}
-
-				detail := &CategoryDetailResult{}
-				result = append(result, detail)
-				detail.Value = v
-				detail.Count = int(bucket.DocCount)
-
-				// ElasticSearch doesn't return aggregations in buckets EXCEPT for sub-aggregates. But the conversion to a Go
-				// response always includes aggregates. The comparison of length is to filter out the expected 'key' & 'doc_count'
-				// fields, allowing the code to get the actual values of interest.
-				if bucket.Aggregations != nil && len(bucket.Aggregations) > 2 {
-					detail.Field, detail.Children = processDetailAggregations(&bucket.Aggregations)
-				}
-			}
+	for _, bucket := range buckets {
+		if bucket.Count == 0 {
+			continue
 		}
-	}
-
-	if len(result) > 0 {
-		return &key, result
-	}
-
-	return nil, nil
-}
-
-func AddDrilldown(search *elastic.SearchService, searchQuery *elastic.Query, drilldownOptions *DrilldownOptions) {
-	if searchQuery == nil {
-		return
-	}
-
-	if len(drilldownOptions.Drilldown) < 1 {
-		return
-	}
-
-	// locations (site, city, state, country) are OR - also, OR between each type/level
-	// dates (year, month, day) are OR - also OR between each value
-	// keywords are OR
-	// tags are OR
-	// each set is ANDed together ((locations) AND (dates) AND (keywords) AND (tags))
-
-	// countryName:Canada;stateName:Washington,Ile-de-France;keywords:trip,flower
-	// (countryName=Canada OR stateName=Washington OR stateName=Ile-de-France) AND (keywords=trip OR keywords=flower)
-
-	drilldownQuery := elastic.NewBoolQuery()
-	drilldownQuery.Must(*searchQuery)
 
-	locationQueryList := make([]interface{}, 0)
-	dateQueryList := make([]interface{}, 0)
-
-	for key, value := range drilldownOptions.Drilldown {
-		fieldName := key
-
-		keyGroup := strings.Split(key, "~")
-		isHierarchical := len(keyGroup) > 1
-		if isHierarchical {
-			fieldName = keyGroup[0]
-		}
-
-		if isLocationField(fieldName) {
-			if isHierarchical {
-				for _, valueSet := range value {
-					valueGroup := strings.Split(valueSet, "~")
-					q := elastic.NewBoolQuery()
-					for index, _ := range keyGroup {
-						fieldQuery := elastic.NewTermQuery(getLocationFieldName(keyGroup[index]), fmt.Sprintf("%s", valueGroup[index]))
-						q.Must(fieldQuery)
-					}
-
-					locationQueryList = append(locationQueryList, q)
-				}
-			} else {
-				for _, v := range value {
-					locationQueryList = append(locationQueryList, elastic.NewTermQuery(getLocationFieldName(fieldName), fmt.Sprintf("%s", v)))
-				}
-			}
-
-		} else if isDateField(fieldName) {
-			if isHierarchical {
-				// For each set, add a date query for each key/value AND'ed together
-				for _, valueSet := range value {
-					valueGroup := strings.Split(valueSet, "~")
-					q := elastic.NewBoolQuery()
-					for index, _ := range keyGroup {
-						script := elastic.NewScriptInline(getDateFieldQuery(keyGroup[index])).Lang("painless").Param("dateValue", valueGroup[index])
-						q.Must(elastic.NewScriptQuery(script))
-					}
-
-					dateQueryList = append(dateQueryList, q)
-				}
-			} else {
-				// If a date field, probably 'dateYear', is specified, it can be one of a few - OR them together
-				for _, v := range value {
-					script := elastic.NewScriptInline(getDateFieldQuery(fieldName)).Lang("painless").Param("dateValue", v)
-					dateQueryList = append(dateQueryList, elastic.NewScriptQuery(script))
-				}
-			}
-		} else {
-			fieldQuery := elastic.NewBoolQuery()
-			for _, v := range value {
-				indexFieldName, overridden := common.GetIndexFieldName(fieldName)
-				indexFieldValue := v
-				if !overridden {
-					indexFieldValue = strings.ToLower(v)
-				}
-				fieldQuery.Should(elastic.NewTermQuery(indexFieldName, indexFieldValue))
-			}
-			drilldownQuery.Must(fieldQuery)
+		v := ""
+		switch bucket.Key.(type) {
+		case string:
+			v = bucket.Key.(string)
+		case float64:
+			// Assume it's a time, specifically, milliseconds since the epoch
+			msec := int64(bucket.Key.(float64))
+			v = fmt.Sprintf("%s", time.Unix(msec/1000, 0))
+		default:
+			v = "error"
+			fmt.Printf("Unhandled type '%v'\n", bucket.Key)
 		}
-	}
 
-	if len(locationQueryList) > 0 {
-		locationQuery := elastic.NewBoolQuery()
-		for _, q := range locationQueryList {
-			if termQuery, ok := q.(*elastic.TermQuery); ok {
-				locationQuery.Should(termQuery)
-			} else {
-				locationQuery.Should(q.(*elastic.BoolQuery))
-			}
-		}
-		drilldownQuery.Must(locationQuery)
-	}
+		detail := &CategoryDetailResult{}
+		detail.Value = v
+		detail.Count = int(bucket.Count)
+		result = append(result, detail)
 
-	if len(dateQueryList) > 0 {
-		dateQuery := elastic.NewBoolQuery()
-		for _, q := range dateQueryList {
-			if scriptQuery, ok := q.(*elastic.ScriptQuery); ok {
-				dateQuery.Should(scriptQuery)
-			} else {
-				dateQuery.Should(q.(*elastic.BoolQuery))
-			}
+		if children := processBuckets(bucket.SubBuckets); len(children) > 0 {
+			field := bucket.SubAggregationName
+			detail.Field = &field
+			detail.Children = children
 		}
-		drilldownQuery.Must(dateQuery)
-	}
-
-	//	src, _ := drilldownQuery.Source()
-	//	dataMap, _ := json.MarshalIndent(src, "", "    ")
-	//	jsonString := string(dataMap)
-	//	fmt.Printf("drilldown: '%s'\n", jsonString)
-
-	search.Query(drilldownQuery)
-}
-
-func isLocationField(name string) bool {
-	switch strings.ToLower(name) {
-	case "countryname", "statename", "cityname", "sitename":
-		return true
-	default:
-		return false
-
-	}
-}
-
-func isDateField(name string) bool {
-	switch strings.ToLower(name) {
-	case "dateyear", "datemonth", "dateday":
-		return true
-	default:
-		return false
-
 	}
-}
 
-func getLocationFieldName(name string) string {
-	return strings.ToLower(name + ".value")
-}
-
-func getDateFieldQuery(name string) string {
-	switch strings.ToLower(name) {
-	case "dateyear":
-		return "doc['datetime'].date.toString('YYYY') == params.dateValue"
-	case "datemonth":
-		return "doc['datetime'].date.toString('MMMM') == params.dateValue"
-	case "dateday":
-		return "doc['datetime'].date.toString('dd') == params.dateValue"
-	default:
-		_ = fmt.Errorf("Unhandled date field name: '%s'\n", name)
-		return ""
-	}
+	return result
 }
@@ -2,7 +2,6 @@ package search
 
 import (
 	"github.com/ian-kent/go-log/log"
-	"gopkg.in/olivere/elastic.v5"
 
 	"github.com/kevintavog/findaphoto/common"
 )
@@ -31,58 +30,49 @@ func NewByDayOptions(month, dayOfMonth int) *ByDayOptions {
 }
 
 func (bdo *ByDayOptions) Search() (*SearchResult, error) {
-	client := common.CreateClient() // consider using elastic.NewSimpleClient
-	search := client.Search().
-		Index(common.MediaIndexName).
-		Type(common.MediaTypeName).
-		Pretty(true).
-		From(bdo.Index).
-		Size(bdo.Count)
-
 	grouping := GroupByDate
 	dayOfYear := common.DayOfYear(bdo.Month, bdo.DayOfMonth)
-	dateQuery := elastic.NewTermQuery("dayofyear", dayOfYear)
+	options := &common.MediaSearchOptions{
+		Query: common.MediaQuery{
+			DayOfYearFrom: dayOfYear,
+			DayOfYearTo:   dayOfYear,
+		},
+		From: bdo.Index,
+		Size: bdo.Count,
+		Sort: common.SortNewestFirst,
+	}
 	if bdo.Random {
 		grouping = GroupByAll
-		randomQuery := elastic.NewFunctionScoreQuery().
-			Query(dateQuery).
-			AddScoreFunc(elastic.NewRandomFunction())
-		search.Query(randomQuery)
-	} else {
-		search.Query(dateQuery)
-		search.Sort("datetime", false)
+		options.Sort = common.SortRandom
 	}
 
-	result, err := invokeSearch(search, nil, grouping, bdo.CategoryOptions, bdo.DrilldownOptions, nil)
+	result, err := invokeSearch(options, grouping, bdo.CategoryOptions)
 	if err == nil {
 		if dayOfYear == 1 {
 			// Latest day of the year with matches
-			result.PreviousAvailableByDay = getAvailableDay(client, elastic.NewRangeQuery("dayofyear").Gt(dayOfYear), false)
+			result.PreviousAvailableByDay = getAvailableDay(common.MediaQuery{DayOfYearFrom: dayOfYear + 1}, false)
 		} else {
-			result.PreviousAvailableByDay = getAvailableDay(client, elastic.NewRangeQuery("dayofyear").Lt(dayOfYear), false)
+			result.PreviousAvailableByDay = getAvailableDay(common.MediaQuery{DayOfYearTo: dayOfYear - 1}, false)
 		}
 
 		if dayOfYear == 366 {
 			// The first day of the year with matches
-			result.NextAvailableByDay = getAvailableDay(client, elastic.NewRangeQuery("dayofyear").Lt(dayOfYear), true)
+			result.NextAvailableByDay = getAvailableDay(common.MediaQuery{DayOfYearTo: dayOfYear - 1}, true)
 		} else {
-			result.NextAvailableByDay = getAvailableDay(client, elastic.NewRangeQuery("dayofyear").Gt(dayOfYear), true)
+			result.NextAvailableByDay = getAvailableDay(common.MediaQuery{DayOfYearFrom: dayOfYear + 1}, true)
 		}
 	}
 
 	return result, err
 }
 
-func getAvailableDay(client *elastic.Client, query *elastic.RangeQuery, ascending bool) *ByDayResult {
-	search := client.Search().
-		Index(common.MediaIndexName).
-		Type(common.MediaTypeName).
-		Pretty(true).
-		Size(1).
-		Sort("dayofyear", ascending).
-		Query(query)
+func getAvailableDay(query common.MediaQuery, ascending bool) *ByDayResult {
+	options := &common.MediaSearchOptions{Query: query, Sort: common.SortDayOfYearDescending}
+	if ascending {
+		options.Sort = common.SortDayOfYearAscending
+	}
 
-	hit, err := returnFirstMatch(search)
+	hit, err := returnFirstMatch(options)
 	if err != nil {
 		log.Warn("available day search failed: %s", err.Error())
 		return nil
@@ -1,9 +1,9 @@
 package search
 
 import (
-	"reflect"
-
-	"gopkg.in/olivere/elastic.v5"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/kevintavog/findaphoto/common"
 )
@@ -32,25 +32,34 @@ func NewNearbyOptions(lat, lon float64, distance string) *NearbyOptions {
 }
 
 func (no *NearbyOptions) Search() (*SearchResult, error) {
-	client := common.CreateClient() // consider using elastic.NewSimpleClient
-	search := client.Search().
-		Index(common.MediaIndexName).
-		Type(common.MediaTypeName).
-		Pretty(true)
-
-	search.Query(elastic.NewGeoDistanceQuery("location").Lat(no.Latitude).Lon(no.Longitude).Distance(no.Distance))
-	search.SortBy(elastic.NewGeoDistanceSort("location").Point(no.Latitude, no.Longitude).Order(true).Unit("km"))
-	search.From(no.Index).Size(no.Count)
-
-	return invokeSearch(search, nil, GroupByAll, no.CategoryOptions, no.DrilldownOptions, func(searchHit *elastic.SearchHit, mediaHit *MediaHit) {
-
-		// For the geo sort, the returned sort value is the distance from the given point, in kilometers
-		if len(searchHit.Sort) > 0 {
-			first := searchHit.Sort[0]
-			if reflect.TypeOf(first).Name() == "float64" {
-				v := first.(float64)
-				mediaHit.DistanceKm = &v
+	kilometers, err := distanceInKilometers(no.Distance)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &common.MediaSearchOptions{
+		Query: common.MediaQuery{
+			Near: &common.GeoDistance{Latitude: no.Latitude, Longitude: no.Longitude, Kilometers: kilometers},
+		},
+		From: no.Index,
+		Size: no.Count,
+		Sort: common.SortNearestFirst,
+	}
+
+	return invokeSearch(options, GroupByAll, no.CategoryOptions)
+}
+
+// Distances are given as Elasticsearch takes them: '500m', '13000km'
+func distanceInKilometers(distance string) (float64, error) {
+	units := map[string]float64{"km": 1, "m": 0.001, "mi": 1.609344}
+	for _, suffix := range []string{"km", "mi", "m"} {
+		if strings.HasSuffix(distance, suffix) {
+			value, err := strconv.ParseFloat(strings.TrimSuffix(distance, suffix), 64)
+			if err != nil {
+				return 0, fmt.Errorf("Invalid distance '%s': %s", distance, err.Error())
 			}
+			return value * units[suffix], nil
 		}
-	})
+	}
+	return 0, fmt.Errorf("Invalid distance '%s', it must end with 'km', 'mi' or 'm'", distance)
 }
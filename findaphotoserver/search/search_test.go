@@ -0,0 +1,304 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+)
+
+func TestTextSearch(t *testing.T) {
+	useTestMedia(t)
+
+	result, err := NewSearchOptions("beach").Search()
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+	if result.TotalMatches != 2 || result.ResultCount != 2 {
+		t.Fatalf("Wrong number of matches: %d (%d returned)", result.TotalMatches, result.ResultCount)
+	}
+
+	// Newest first, grouped by date
+	if len(result.Groups) != 2 || result.Groups[0].Name != "2016-07-05" || result.Groups[1].Name != "2016-07-04" {
+		t.Fatalf("Wrong groups: %v", groupNames(result))
+	}
+}
+
+func TestTextSearchDrilldown(t *testing.T) {
+	useTestMedia(t)
+
+	checkDrilldown(t, "beach", map[string][]string{"countryName": {"Canada"}}, "beach2.jpg")
+	checkDrilldown(t, "beach", map[string][]string{"keywords": {"family"}}, "beach1.jpg")
+	checkDrilldown(t, "beach", map[string][]string{"countryName~stateName": {"United States~Washington"}}, "beach1.jpg")
+	checkDrilldown(t, "", map[string][]string{"dateYear": {"2017"}}, "nowhere.jpg", "peak.jpg")
+	checkDrilldown(t, "", map[string][]string{"cityName": {"Seattle", "Ashford"}, "dateYear": {"2016"}}, "beach1.jpg")
+}
+
+func TestDateDrilldownLocalTime(t *testing.T) {
+	useTestMedia(t)
+
+	// New Year's Eve in Seattle is already the next year in UTC
+	media := testMedia("1\\2017\\fireworks.jpg", time.Date(2018, 1, 1, 7, 30, 0, 0, time.UTC), nil, []string{"fireworks"}, "", "", "")
+	media.LocalDateTime = "2017-12-31T23:30:00"
+	if err := common.ActiveMediaStore.IndexMedia(media.Path, media); err != nil {
+		t.Fatalf("Failed indexing %s: %s", media.Path, err)
+	}
+
+	checkDrilldown(t, "fireworks", map[string][]string{"dateYear": {"2017"}, "dateMonth": {"December"}, "dateDay": {"31"}}, "fireworks.jpg")
+	checkDrilldown(t, "fireworks", map[string][]string{"dateYear": {"2018"}})
+
+	options := NewSearchOptions("fireworks")
+	options.CategoryOptions.DateCount = 10
+	result, err := options.Search()
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+	years := findCategory(result, "dateYear")
+	if years == nil || len(years.Details) != 1 || years.Details[0].Value != "2017" {
+		t.Fatalf("Wrong years: %v", years)
+	}
+	month := years.Details[0].Children[0]
+	if month.Value != "December" || len(month.Children) != 1 || month.Children[0].Value != "31" {
+		t.Fatalf("Wrong month: %v", month)
+	}
+}
+
+func TestNearbySearch(t *testing.T) {
+	useTestMedia(t)
+
+	result, err := NewNearbyOptions(47.6062, -122.3321, "150km").Search()
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+
+	hits := allHits(result)
+	if len(hits) != 2 || hits[0].Media.Filename != "beach1.jpg" || hits[1].Media.Filename != "peak.jpg" {
+		t.Fatalf("Wrong media, or order: %v", filenames(hits))
+	}
+	if hits[0].DistanceKm == nil || *hits[0].DistanceKm > 0.001 {
+		t.Fatalf("Wrong distance for the nearest: %v", hits[0].DistanceKm)
+	}
+	if hits[1].DistanceKm == nil || *hits[1].DistanceKm < 80 || *hits[1].DistanceKm > 110 {
+		t.Fatalf("Wrong distance for the furthest: %v", hits[1].DistanceKm)
+	}
+
+	if _, err := NewNearbyOptions(47.6062, -122.3321, "far").Search(); err == nil {
+		t.Fatalf("An invalid distance should fail")
+	}
+
+	// Only text searches are drilled into
+	options := NewNearbyOptions(47.6062, -122.3321, "150km")
+	options.DrilldownOptions.Drilldown["cityName"] = []string{"Ashford"}
+	result, err = options.Search()
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+	if result.TotalMatches != 2 {
+		t.Fatalf("The drilldown shouldn't narrow a nearby search: %v", filenames(allHits(result)))
+	}
+}
+
+func TestByDaySearch(t *testing.T) {
+	useTestMedia(t)
+
+	result, err := NewByDayOptions(7, 4).Search()
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+
+	hits := allHits(result)
+	if len(hits) != 2 || hits[0].Media.Filename != "peak.jpg" || hits[1].Media.Filename != "beach1.jpg" {
+		t.Fatalf("Wrong media, or order: %v", filenames(hits))
+	}
+	if result.PreviousAvailableByDay != nil {
+		t.Fatalf("There should be no previous day: %v", result.PreviousAvailableByDay)
+	}
+	checkDay(t, "next", result.NextAvailableByDay, 7, 5)
+
+	options := NewByDayOptions(7, 4)
+	options.DrilldownOptions.Drilldown["cityName"] = []string{"Ashford"}
+	result, err = options.Search()
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+	if result.TotalMatches != 2 {
+		t.Fatalf("The drilldown shouldn't narrow a by day search: %v", filenames(allHits(result)))
+	}
+
+	// The last day of the year wraps around to find the next day
+	result, err = NewByDayOptions(12, 31).Search()
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+	if result.TotalMatches != 1 {
+		t.Fatalf("Wrong number of matches: %d", result.TotalMatches)
+	}
+	checkDay(t, "previous", result.PreviousAvailableByDay, 7, 5)
+	checkDay(t, "next", result.NextAvailableByDay, 7, 4)
+}
+
+func TestCategories(t *testing.T) {
+	useTestMedia(t)
+
+	options := NewSearchOptions("")
+	options.CategoryOptions.KeywordCount = 2
+	options.CategoryOptions.PlacenameCount = 10
+	result, err := options.Search()
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+
+	keywords := findCategory(result, "keywords")
+	if keywords == nil || len(keywords.Details) != 2 {
+		t.Fatalf("Wrong keywords: %v", keywords)
+	}
+	if keywords.Details[0].Value != "beach" || keywords.Details[0].Count != 2 || keywords.Details[1].Value != "family" {
+		t.Fatalf("Wrong keywords: %s=%d, %s=%d", keywords.Details[0].Value, keywords.Details[0].Count,
+			keywords.Details[1].Value, keywords.Details[1].Count)
+	}
+
+	countries := findCategory(result, "countryName")
+	if countries == nil || len(countries.Details) != 2 {
+		t.Fatalf("Wrong countries: %v", countries)
+	}
+	country := countries.Details[0]
+	if country.Value != "United States" || country.Count != 2 || country.Field == nil || *country.Field != "stateName" {
+		t.Fatalf("Wrong country: %v", country)
+	}
+	state := country.Children[0]
+	if state.Value != "Washington" || len(state.Children) != 2 || state.Children[0].Value != "Ashford" {
+		t.Fatalf("Wrong state: %v", state)
+	}
+}
+
+func TestSimilarSearch(t *testing.T) {
+	useTestMedia(t)
+	hashes := map[string]string{
+		"1\\2016\\beach1.jpg": "0000000000000000",
+		"1\\2016\\beach2.jpg": "000000000000000f",
+		"1\\2017\\peak.jpg":   "0000000000000003",
+	}
+	for id, hash := range hashes {
+		if err := common.ActiveMediaStore.UpdateMedia(id, map[string]interface{}{"perceptualhash": hash}); err != nil {
+			t.Fatalf("Failed updating %s: %s", id, err)
+		}
+	}
+	InvalidatePerceptualHashes()
+
+	options := NewSimilarOptions("1\\2016\\beach1.jpg")
+	options.MaxDistance = 4
+	result, err := options.Search()
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+	hits := allHits(result)
+	if len(hits) != 2 || hits[0].Media.Filename != "peak.jpg" || hits[1].Media.Filename != "beach2.jpg" {
+		t.Fatalf("Wrong media, or order: %v", filenames(hits))
+	}
+	if *hits[0].HashDistance != 2 || *hits[1].HashDistance != 4 {
+		t.Fatalf("Wrong distances: %d, %d", *hits[0].HashDistance, *hits[1].HashDistance)
+	}
+
+	result, err = NewNearDuplicatesOptions().Search()
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+	if result.TotalMatches != 1 || len(allHits(result)) != 3 {
+		t.Fatalf("Expected a single cluster of all three, got %d clusters: %v", result.TotalMatches, filenames(allHits(result)))
+	}
+}
+
+func useTestMedia(t *testing.T) {
+	store := common.NewMemoryMediaStore()
+	common.ActiveMediaStore = store
+
+	media := []*common.Media{
+		testMedia("1\\2016\\beach1.jpg", time.Date(2016, 7, 4, 10, 0, 0, 0, time.UTC), &common.GeoPoint{Latitude: 47.6062, Longitude: -122.3321},
+			[]string{"beach", "family"}, "United States", "Washington", "Seattle"),
+		testMedia("1\\2016\\beach2.jpg", time.Date(2016, 7, 5, 10, 0, 0, 0, time.UTC), &common.GeoPoint{Latitude: 49.2827, Longitude: -123.1207},
+			[]string{"beach"}, "Canada", "British Columbia", "Vancouver"),
+		testMedia("1\\2017\\peak.jpg", time.Date(2017, 7, 4, 9, 0, 0, 0, time.UTC), &common.GeoPoint{Latitude: 46.8523, Longitude: -121.7603},
+			[]string{"mountain"}, "United States", "Washington", "Ashford"),
+		testMedia("1\\2017\\nowhere.jpg", time.Date(2017, 12, 31, 12, 0, 0, 0, time.UTC), nil,
+			[]string{"party"}, "", "", ""),
+	}
+	for _, m := range media {
+		if err := store.IndexMedia(m.Path, m); err != nil {
+			t.Fatalf("Failed indexing %s: %s", m.Path, err)
+		}
+	}
+}
+
+func testMedia(path string, dateTime time.Time, location *common.GeoPoint, keywords []string, country, state, city string) *common.Media {
+	return &common.Media{
+		Filename:            path[len("1\\2016\\"):],
+		Path:                path,
+		DateTime:            dateTime,
+		DayOfYear:           common.DayOfYearFromDate(dateTime),
+		DayName:             dateTime.Format("Mon, Monday"),
+		MonthName:           dateTime.Format("Jan, January"),
+		Location:            location,
+		Keywords:            keywords,
+		LocationCountryName: country,
+		LocationStateName:   state,
+		LocationCityName:    city,
+	}
+}
+
+func checkDrilldown(t *testing.T, query string, drilldown map[string][]string, expected ...string) {
+	options := NewSearchOptions(query)
+	options.DrilldownOptions.Drilldown = drilldown
+	result, err := options.Search()
+	if err != nil {
+		t.Fatalf("Search failed: %s", err)
+	}
+
+	hits := filenames(allHits(result))
+	if len(hits) != len(expected) {
+		t.Fatalf("'%s' with %v matched %v, not %v", query, drilldown, hits, expected)
+	}
+	for index := range hits {
+		if hits[index] != expected[index] {
+			t.Fatalf("'%s' with %v matched %v, not %v", query, drilldown, hits, expected)
+		}
+	}
+}
+
+func checkDay(t *testing.T, name string, day *ByDayResult, month, dayOfMonth int) {
+	if day == nil || day.Month != month || day.Day != dayOfMonth {
+		t.Fatalf("Wrong %s day: %v, expected %d/%d", name, day, month, dayOfMonth)
+	}
+}
+
+func allHits(result *SearchResult) []*MediaHit {
+	hits := make([]*MediaHit, 0)
+	for _, group := range result.Groups {
+		hits = append(hits, group.Items...)
+	}
+	return hits
+}
+
+func filenames(hits []*MediaHit) []string {
+	names := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		names = append(names, hit.Media.Filename)
+	}
+	return names
+}
+
+func groupNames(result *SearchResult) []string {
+	names := make([]string, 0, len(result.Groups))
+	for _, group := range result.Groups {
+		names = append(names, group.Name)
+	}
+	return names
+}
+
+func findCategory(result *SearchResult, field string) *CategoryResult {
+	for _, category := range result.Categories {
+		if category.Field == field {
+			return category
+		}
+	}
+	return nil
+}
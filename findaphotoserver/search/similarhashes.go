@@ -0,0 +1,118 @@
+package search
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+)
+
+// The perceptual hashes are loaded once, then reused until InvalidatePerceptualHashes is called after
+// indexing - or, for indexer runs the server doesn't start, until they're this old
+var PerceptualHashesMaxAge = 10 * time.Minute
+
+type perceptualHashSet struct {
+	entries []perceptualHashEntry
+	loaded  time.Time
+
+	bandsLock sync.Mutex
+	bands     map[int]*perceptualHashBands // By the number of bands
+}
+
+// If two hashes are within 'maxDistance', at least one of 'maxDistance + 1' bands of the hash
+// must be identical - so only entries sharing a band value need to be compared.
+// buckets[band][value] are the indexes of the entries with that value for the band
+type perceptualHashBands struct {
+	shifts  []uint
+	masks   []uint64
+	buckets []map[uint64][]int
+}
+
+var perceptualHashes *perceptualHashSet
+var perceptualHashesLock sync.Mutex
+
+// Called once media has been indexed or removed, so the hashes are loaded again
+func InvalidatePerceptualHashes() {
+	perceptualHashesLock.Lock()
+	defer perceptualHashesLock.Unlock()
+	perceptualHashes = nil
+}
+
+// Concurrent searches wait for a single load
+func cachedPerceptualHashes() (*perceptualHashSet, error) {
+	perceptualHashesLock.Lock()
+	defer perceptualHashesLock.Unlock()
+
+	if perceptualHashes != nil && time.Now().Sub(perceptualHashes.loaded) < PerceptualHashesMaxAge {
+		return perceptualHashes, nil
+	}
+
+	loaded := time.Now()
+	entries, err := loadPerceptualHashes()
+	if err != nil {
+		return nil, err
+	}
+	perceptualHashes = &perceptualHashSet{
+		entries: entries,
+		loaded:  loaded,
+		bands:   make(map[int]*perceptualHashBands),
+	}
+	return perceptualHashes, nil
+}
+
+// The band index for the distance, built the first time it's needed
+func (phs *perceptualHashSet) bandsFor(maxDistance int) *perceptualHashBands {
+	numBands := maxDistance + 1
+	if numBands < 1 {
+		numBands = 1
+	}
+	if numBands > common.MaxPerceptualHashDistance+1 {
+		numBands = common.MaxPerceptualHashDistance + 1
+	}
+
+	phs.bandsLock.Lock()
+	defer phs.bandsLock.Unlock()
+
+	if bands, ok := phs.bands[numBands]; ok {
+		return bands
+	}
+
+	bands := &perceptualHashBands{}
+	bandWidth := uint(common.MaxPerceptualHashDistance / numBands)
+	for band := 0; band < numBands; band++ {
+		shift := uint(band) * bandWidth
+		width := bandWidth
+		if band == numBands-1 {
+			width = uint(common.MaxPerceptualHashDistance) - shift
+		}
+		mask := uint64(1)<<width - 1
+
+		buckets := make(map[uint64][]int)
+		for i, e := range phs.entries {
+			key := (e.hash >> shift) & mask
+			buckets[key] = append(buckets[key], i)
+		}
+
+		bands.shifts = append(bands.shifts, shift)
+		bands.masks = append(bands.masks, mask)
+		bands.buckets = append(bands.buckets, buckets)
+	}
+
+	phs.bands[numBands] = bands
+	return bands
+}
+
+// The indexes of the entries sharing at least one band value with the hash
+func (phb *perceptualHashBands) candidates(hash uint64) []int {
+	found := make(map[int]bool)
+	candidates := make([]int, 0)
+	for band, buckets := range phb.buckets {
+		for _, i := range buckets[(hash>>phb.shifts[band])&phb.masks[band]] {
+			if !found[i] {
+				found[i] = true
+				candidates = append(candidates, i)
+			}
+		}
+	}
+	return candidates
+}
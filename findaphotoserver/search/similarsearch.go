@@ -0,0 +1,253 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kevintavog/findaphoto/common"
+)
+
+type SimilarOptions struct {
+	Id          string
+	MaxDistance int
+	Index       int
+	Count       int
+}
+
+type NearDuplicatesOptions struct {
+	MaxDistance int
+	Index       int
+	Count       int
+}
+
+type perceptualHashEntry struct {
+	id   string
+	hash uint64
+}
+
+//-------------------------------------------------------------------------------------------------
+func NewSimilarOptions(id string) *SimilarOptions {
+	return &SimilarOptions{
+		Id:          id,
+		MaxDistance: 10,
+		Index:       0,
+		Count:       20,
+	}
+}
+
+//-------------------------------------------------------------------------------------------------
+func NewNearDuplicatesOptions() *NearDuplicatesOptions {
+	return &NearDuplicatesOptions{
+		MaxDistance: 4,
+		Index:       0,
+		Count:       20,
+	}
+}
+
+// Media with a perceptual hash within 'MaxDistance' of the given media, closest first.
+// The given media is not part of the result.
+func (so *SimilarOptions) Search() (*SearchResult, error) {
+	target, err := getMediaByIds([]string{so.Id})
+	if err != nil {
+		return nil, err
+	}
+	if len(target) < 1 {
+		return nil, fmt.Errorf("Unable to find '%s'", so.Id)
+	}
+	if target[0].Media.PerceptualHash == "" {
+		return nil, fmt.Errorf("'%s' doesn't have a perceptual hash", so.Id)
+	}
+	targetHash, err := common.ParsePerceptualHash(target[0].Media.PerceptualHash)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes, err := cachedPerceptualHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	distances := make(map[string]int)
+	matches := make([]string, 0)
+	for _, i := range hashes.bandsFor(so.MaxDistance).candidates(targetHash) {
+		e := hashes.entries[i]
+		if e.id == so.Id {
+			continue
+		}
+		distance := common.PerceptualHashDistance(targetHash, e.hash)
+		if distance <= so.MaxDistance {
+			distances[e.id] = distance
+			matches = append(matches, e.id)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if distances[matches[i]] != distances[matches[j]] {
+			return distances[matches[i]] < distances[matches[j]]
+		}
+		return matches[i] < matches[j]
+	})
+
+	sr := &SearchResult{TotalMatches: int64(len(matches))}
+	hits, err := getMediaByIds(pageOf(matches, so.Index, so.Count))
+	if err != nil {
+		return nil, err
+	}
+
+	group := &SearchGroup{Name: "all", Items: hits}
+	for _, mh := range hits {
+		distance := distances[mh.Media.Path]
+		mh.HashDistance = &distance
+	}
+	sr.Groups = []*SearchGroup{group}
+	sr.ResultCount = len(hits)
+	return sr, nil
+}
+
+// Clusters of media whose perceptual hashes are within 'MaxDistance' of another member of the cluster.
+// Each cluster is returned as a group; the total matches is the number of clusters.
+func (ndo *NearDuplicatesOptions) Search() (*SearchResult, error) {
+	hashes, err := cachedPerceptualHashes()
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := clusterPerceptualHashes(hashes.entries, hashes.bandsFor(ndo.MaxDistance), ndo.MaxDistance)
+
+	sr := &SearchResult{TotalMatches: int64(len(clusters)), Groups: []*SearchGroup{}}
+	first := ndo.Index
+	if first > len(clusters) {
+		first = len(clusters)
+	}
+	last := first + ndo.Count
+	if last > len(clusters) {
+		last = len(clusters)
+	}
+
+	for _, cluster := range clusters[first:last] {
+		hits, err := getMediaByIds(cluster)
+		if err != nil {
+			return nil, err
+		}
+
+		sr.Groups = append(sr.Groups, &SearchGroup{Name: cluster[0], Items: hits})
+		sr.ResultCount += len(hits)
+	}
+
+	return sr, nil
+}
+
+// Group the entries into clusters of near-identical hashes, largest cluster first; single
+// entries aren't returned. Only entries sharing a band value are compared.
+func clusterPerceptualHashes(entries []perceptualHashEntry, bands *perceptualHashBands, maxDistance int) [][]string {
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for _, buckets := range bands.buckets {
+		for _, bucket := range buckets {
+			for a := 0; a < len(bucket); a++ {
+				for b := a + 1; b < len(bucket); b++ {
+					ra, rb := find(bucket[a]), find(bucket[b])
+					if ra == rb {
+						continue
+					}
+					if common.PerceptualHashDistance(entries[bucket[a]].hash, entries[bucket[b]].hash) <= maxDistance {
+						parent[ra] = rb
+					}
+				}
+			}
+		}
+	}
+
+	members := make(map[int][]string)
+	for i, e := range entries {
+		root := find(i)
+		members[root] = append(members[root], e.id)
+	}
+
+	clusters := make([][]string, 0)
+	for _, ids := range members {
+		if len(ids) > 1 {
+			sort.Strings(ids)
+			clusters = append(clusters, ids)
+		}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i]) != len(clusters[j]) {
+			return len(clusters[i]) > len(clusters[j])
+		}
+		return clusters[i][0] < clusters[j][0]
+	})
+	return clusters
+}
+
+// All perceptual hashes in the index; only the hash is retrieved for each document
+func loadPerceptualHashes() ([]perceptualHashEntry, error) {
+	entries := make([]perceptualHashEntry, 0)
+	query := &common.MediaQuery{Exists: []string{"perceptualhash"}}
+	err := common.ActiveMediaStore.Scroll(query, []string{"perceptualhash"}, 1000, func(hits []*common.MediaSearchHit) bool {
+		for _, hit := range hits {
+			hash, err := common.ParsePerceptualHash(hit.Media.PerceptualHash)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, perceptualHashEntry{id: hit.Id, hash: hash})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// In the order of the ids; ids without media are left out
+func getMediaByIds(ids []string) ([]*MediaHit, error) {
+	hits := make([]*MediaHit, 0)
+	if len(ids) < 1 {
+		return hits, nil
+	}
+
+	result, err := common.ActiveMediaStore.Search(&common.MediaSearchOptions{
+		Query: common.MediaQuery{Ids: ids},
+		Size:  len(ids),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[string]*common.Media)
+	for _, hit := range result.Hits {
+		byId[hit.Id] = hit.Media
+	}
+	for _, id := range ids {
+		if media, ok := byId[id]; ok {
+			hits = append(hits, &MediaHit{Media: media})
+		}
+	}
+
+	return hits, nil
+}
+
+func pageOf(ids []string, index, count int) []string {
+	if index >= len(ids) {
+		return []string{}
+	}
+	last := index + count
+	if last > len(ids) {
+		last = len(ids)
+	}
+	return ids[index:last]
+}
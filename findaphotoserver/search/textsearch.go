@@ -1,8 +1,6 @@
 package search
 
 import (
-	"gopkg.in/olivere/elastic.v5"
-
 	"github.com/kevintavog/findaphoto/common"
 )
 
@@ -26,27 +24,25 @@ func NewSearchOptions(query string) *SearchOptions {
 }
 
 func (so *SearchOptions) Search() (*SearchResult, error) {
-	client := common.CreateClient()
-	search := client.Search().
-		Index(common.MediaIndexName).
-		Type(common.MediaTypeName).
-		Pretty(true)
-
-	var query elastic.Query
-	if so.Query == "" {
-		query = elastic.NewMatchAllQuery()
-	} else {
-		query = elastic.NewQueryStringQuery(so.Query).
-			Field("path"). // Folder name
-			Field("monthname").
-			Field("dayname").
-			Field("keywords").
-			Field("placename"). // Full reverse location lookup
-			Field("tags")
+	options := &common.MediaSearchOptions{
+		Query: common.MediaQuery{
+			Text: so.Query,
+			TextFields: []string{
+				"path", // Folder name
+				"monthname",
+				"dayname",
+				"keywords",
+				"placename", // Full reverse location lookup
+				"tags",
+				"title",
+				"description",
+				"artist"},
+			Drilldown: so.DrilldownOptions.Drilldown,
+		},
+		From: so.Index,
+		Size: so.Count,
+		Sort: common.SortNewestFirst,
 	}
 
-	search.Query(query)
-
-	search.From(so.Index).Size(so.Count).Sort("datetime", false)
-	return invokeSearch(search, &query, GroupByDate, so.CategoryOptions, so.DrilldownOptions, nil)
+	return invokeSearch(options, GroupByDate, so.CategoryOptions)
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"path"
 	"time"
 
 	"github.com/ian-kent/go-log/log"
@@ -15,6 +16,7 @@ import (
 	"github.com/kevintavog/findaphoto/findaphotoserver/configuration"
 	"github.com/kevintavog/findaphoto/findaphotoserver/controllers/api"
 	"github.com/kevintavog/findaphoto/findaphotoserver/controllers/files"
+	"github.com/kevintavog/findaphoto/findaphotoserver/scheduler"
 	"github.com/kevintavog/findaphoto/findaphotoserver/util"
 )
 
@@ -58,17 +60,34 @@ func run(devolopmentMode bool, indexOverride string, aliasOverride string) {
 
 	log.Info("Listening at http://localhost:%d/", listenPort)
 	log.Info(" ElasticSearch:: %s/%s", configuration.Current.ElasticSearchURL, common.MediaIndexName)
-	log.Info(" Reverse name lookups: %s", configuration.Current.LocationLookupURL)
+	if configuration.Current.LocationLookupURL != "" {
+		log.Info(" Reverse name lookups: %s", configuration.Current.LocationLookupURL)
+	} else if configuration.Current.GeoNamesDirectory != "" {
+		log.Info(" Reverse name lookups: GeoNames files in %s", configuration.Current.GeoNamesDirectory)
+	} else {
+		log.Info(" Reverse name lookups: GeoNames files in the indexer's default folder")
+	}
 
 	common.ElasticSearchServer = configuration.Current.ElasticSearchURL
 
 	checkElasticServerAndIndex()
 	checkLocationLookupServer()
 
+	err := scheduler.Load(path.Join(common.ConfigDirectory, "rangic.findaphotoSchedule"))
+	if err != nil {
+		log.Fatalf("Unable to load the indexing schedule: %s", err.Error())
+	}
+
 	e := configureEcho()
 
-	api.ReindexMedia = func(force bool) {
-		go runIndexer(force, false)
+	api.ReindexMedia = func(force bool, dryRun bool) {
+		go runIndexer(force, dryRun, false)
+	}
+	api.GetIndexerStatus = currentIndexerStatus
+	api.GetDryRunStatus = currentDryRunStatus
+	api.GpxDirectory = gpxDirectory()
+	api.GeotagMedia = func() {
+		go runGeotagger()
 	}
 
 	api.ConfigureRouting(e)
@@ -85,13 +104,21 @@ func run(devolopmentMode bool, indexOverride string, aliasOverride string) {
 	delayThenIndexFunc := func() {
 		if !devolopmentMode {
 			time.Sleep(1 * time.Second)
-			runIndexer(false, devolopmentMode)
+			runIndexer(false, false, devolopmentMode)
 		}
 	}
 
+	// Scheduled runs of a path being indexed at startup are skipped, so the schedule doesn't wait for it
+	startSchedulerFunc := func() {
+		scheduler.Start(func(path string, force bool) {
+			runPathIndexer(path, force, devolopmentMode)
+		}, isIndexing)
+	}
+
 	startServerFunc := func() {
 		go mediaClassifierFunc()
 		go delayThenIndexFunc()
+		startSchedulerFunc()
 
 		err := e.Start(fmt.Sprintf(":%d", listenPort))
 		if err != nil {
@@ -164,6 +191,7 @@ func checkElasticServerAndIndex() {
 			log.Fatalf("Failed creating index '%s': %+v", common.MediaIndexName, err.Error())
 		}
 	}
+	common.CheckMediaIndexVersion(client)
 
 	exists, err = client.IndexExists(common.AliasIndexName).Do(context.TODO())
 	if err != nil {
@@ -182,6 +210,18 @@ func checkElasticServerAndIndex() {
 		log.Fatalf("Failed initializing aliases: %s", err.Error())
 	}
 
+	exists, err = client.IndexExists(common.IndexRunIndexName).Do(context.TODO())
+	if err != nil {
+		log.Fatalf("Failed querying index: %s", err.Error())
+	}
+	if !exists {
+		log.Warn("The index '%s' doesn't exist", common.IndexRunIndexName)
+		err = common.CreateIndexRunIndex(client)
+		if err != nil {
+			log.Fatalf("Failed creating index '%s': %+v", common.IndexRunIndexName, err.Error())
+		}
+	}
+
 	exists, err = client.IndexExists(common.ClarifaiCacheIndexName).Do(context.TODO())
 	if err != nil {
 		log.Fatalf("Failed querying index: %s", err.Error())
@@ -195,11 +235,16 @@ func checkElasticServerAndIndex() {
 	}
 }
 
+// The lookup server is optional, the indexer works without it - placenames just won't be resolved
 func checkLocationLookupServer() {
+	if configuration.Current.LocationLookupURL == "" {
+		return
+	}
+
 	url := fmt.Sprintf("%s/api/v1/name?lat=%f&lon=%f", configuration.Current.LocationLookupURL, 47.6216, -122.348133)
 
 	_, err := http.Get(url)
 	if err != nil {
-		log.Fatalf("The location lookup server values seem to be wrong, a location lookup failed: %s", err.Error())
+		log.Warn("The location lookup server values seem to be wrong, a location lookup failed: %s", err.Error())
 	}
 }
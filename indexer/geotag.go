@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/steps/preparemedia"
+	"github.com/kevintavog/findaphoto/indexer/steps/resolveplacename"
+
+	"github.com/ian-kent/go-log/log"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+var geotagChecked int64
+var geotagLocated int64
+var geotagUpdatesFailed int64
+
+const geotagScrollSize = 100
+
+// Gives indexed media without a location one from the GPX tracks, when they cover its capture
+// time, then resolves its placename. Files aren't read, though the location may be written to them
+func geotagIndexedMedia() {
+	startTime := time.Now()
+	query := elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery("location"))
+	geotagUpdatesFailed = updateIndexedMedia("location", query, geotagScrollSize, geotagMedia)
+
+	log.Info("[%01.3f seconds] Checked %d media without a location: %d located from tracks, %d updates failed",
+		time.Now().Sub(startTime).Seconds(), geotagChecked, geotagLocated, geotagUpdatesFailed)
+	log.Info("%d locations written to files, %d failed",
+		preparemedia.GeotagWritten, preparemedia.GeotagWriteFailed)
+}
+
+// Returns the fields to update, nil if there's nothing to update
+func geotagMedia(media *common.Media) map[string]interface{} {
+	atomic.AddInt64(&geotagChecked, 1)
+
+	// As when indexing, the file timestamp isn't trusted
+	media.MigrateLegacyWarnings()
+	for _, w := range media.Warnings {
+		if w.Code == common.WarningNoExifDate {
+			return nil
+		}
+	}
+
+	// Video dates are from the QuickTime CreateDate, which is UTC
+	instantKnown := media.TimeZoneMethod != common.TimeZoneMethodIndexer || media.MediaType() == common.MediaTypeVideo
+	location := preparemedia.LocateFromTracks(media, instantKnown)
+	if location == nil {
+		return nil
+	}
+	atomic.AddInt64(&geotagLocated, 1)
+	resolveplacename.Resolve(media)
+
+	if preparemedia.GpxWriteBack {
+		fullPath, err := common.FullPathForAliasedPath(media.Path)
+		if err == nil {
+			err = preparemedia.WriteGpxLocation(fullPath, common.FindSidecar(fullPath), location)
+		}
+		if err != nil {
+			log.Warn("Failed writing the track location to %s: %s", media.Path, err.Error())
+			media.Warnings = append(media.Warnings, common.NewWarning(common.WarningGpxWriteFailed,
+				fmt.Sprintf("Failed writing the track location: %s", err.Error())))
+		}
+	}
+
+	if common.IndexMakeNoChanges {
+		log.Info("WOULD set the location of %s to %f, %f ('%s')",
+			media.Path, location.Latitude, location.Longitude, media.LocationPlaceName)
+	}
+
+	doc := placenameFields(media)
+	doc["location"] = media.Location
+	doc["locationsource"] = media.LocationSource
+	for name, value := range dateFields(media) {
+		doc[name] = value
+	}
+	return doc
+}
+
+// The location may have changed the time zone, and so the date fields
+func dateFields(media *common.Media) map[string]interface{} {
+	return map[string]interface{}{
+		"date":           media.Date,
+		"datetime":       media.DateTime,
+		"localdatetime":  media.LocalDateTime,
+		"utcdatetime":    media.UTCDateTime,
+		"timezone":       media.TimeZone,
+		"timezonemethod": media.TimeZoneMethod,
+		"monthname":      media.MonthName,
+		"dayname":        media.DayName,
+		"dayofyear":      media.DayOfYear,
+	}
+}
@@ -0,0 +1,183 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+// The directories completed by a run that may not have finished - every file in them was found to be
+// unchanged, a duplicate or was indexed. A restarted run skips the files in these directories.
+type Checkpoint struct {
+	Alias                string    `json:"alias"`
+	Path                 string    `json:"path"`
+	Started              time.Time `json:"started"`
+	Updated              time.Time `json:"updated"`
+	CompletedDirectories []string  `json:"completedDirectories"`
+}
+
+var checkpointLock sync.Mutex
+var checkpoint *Checkpoint
+var pendingFiles map[string]int        // Files queued but not yet done, by aliased directory
+var scannedDirectories map[string]bool // All files in the directory have been queued
+var completedDirectories map[string]bool
+
+// Load the checkpoint left by an earlier run of the same path, if there is one
+func LoadCheckpoint(alias, scanPath string) *Checkpoint {
+	filename := checkpointFilename(alias)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Failed reading checkpoint '%s': %s", filename, err.Error())
+		}
+		return nil
+	}
+
+	previous := &Checkpoint{}
+	err = json.Unmarshal(data, previous)
+	if err != nil {
+		log.Warn("Ignoring badly formatted checkpoint '%s': %s", filename, err.Error())
+		return nil
+	}
+	if previous.Path != scanPath {
+		log.Warn("Ignoring checkpoint '%s', it's for '%s'", filename, previous.Path)
+		return nil
+	}
+	return previous
+}
+
+// Track the progress of directories for this run; the completed directories of 'resume' are kept.
+// Until this is called, the other checkpoint functions do nothing.
+func StartCheckpoint(alias, scanPath string, resume *Checkpoint) {
+	checkpointLock.Lock()
+	defer checkpointLock.Unlock()
+
+	checkpoint = &Checkpoint{Alias: alias, Path: scanPath, Started: time.Now()}
+	pendingFiles = make(map[string]int)
+	scannedDirectories = make(map[string]bool)
+	completedDirectories = make(map[string]bool)
+	if resume != nil {
+		checkpoint.Started = resume.Started
+		for _, directory := range resume.CompletedDirectories {
+			completedDirectories[directory] = true
+		}
+	}
+}
+
+func IsDirectoryCompleted(aliasedDirectory string) bool {
+	checkpointLock.Lock()
+	defer checkpointLock.Unlock()
+	return checkpoint != nil && completedDirectories[aliasedDirectory]
+}
+
+// Called before the file is passed to the first step
+func CheckpointFileQueued(aliasedPath string) {
+	checkpointLock.Lock()
+	defer checkpointLock.Unlock()
+	if checkpoint != nil {
+		pendingFiles[aliasedDirectoryOf(aliasedPath)]++
+	}
+}
+
+// Called once every file in the directory has been queued
+func CheckpointDirectoryScanned(aliasedDirectory string) {
+	checkpointLock.Lock()
+	defer checkpointLock.Unlock()
+	if checkpoint != nil {
+		scannedDirectories[aliasedDirectory] = true
+		updateCompleted(aliasedDirectory)
+	}
+}
+
+// Called when the file needs no more work. Files that fail aren't done - their directory is
+// retried by the next run.
+func CheckpointFileDone(aliasedPath string) {
+	checkpointLock.Lock()
+	defer checkpointLock.Unlock()
+	if checkpoint == nil {
+		return
+	}
+
+	directory := aliasedDirectoryOf(aliasedPath)
+	if pendingFiles[directory] > 0 {
+		pendingFiles[directory]--
+		updateCompleted(directory)
+	}
+}
+
+func WriteCheckpoint() {
+	checkpointLock.Lock()
+	if checkpoint == nil {
+		checkpointLock.Unlock()
+		return
+	}
+	checkpoint.Updated = time.Now()
+	checkpoint.CompletedDirectories = make([]string, 0, len(completedDirectories))
+	for directory := range completedDirectories {
+		checkpoint.CompletedDirectories = append(checkpoint.CompletedDirectories, directory)
+	}
+	sort.Strings(checkpoint.CompletedDirectories)
+	data, err := json.Marshal(checkpoint)
+	alias := checkpoint.Alias
+	checkpointLock.Unlock()
+
+	if err != nil {
+		log.Error("Failed converting checkpoint to JSON: %s", err.Error())
+		return
+	}
+	if common.IndexMakeNoChanges {
+		return
+	}
+
+	// Write to a temporary file first, so a crash doesn't leave a partial checkpoint
+	filename := checkpointFilename(alias)
+	err = ioutil.WriteFile(filename+".tmp", data, 0644)
+	if err == nil {
+		err = os.Rename(filename+".tmp", filename)
+	}
+	if err != nil {
+		log.Error("Failed writing checkpoint '%s': %s", filename, err.Error())
+	}
+}
+
+// The run finished, the next one starts from the beginning
+func RemoveCheckpoint(alias string) {
+	if common.IndexMakeNoChanges {
+		return
+	}
+	filename := checkpointFilename(alias)
+	err := os.Remove(filename)
+	if err != nil && !os.IsNotExist(err) {
+		log.Warn("Failed removing checkpoint '%s': %s", filename, err.Error())
+	}
+}
+
+func updateCompleted(aliasedDirectory string) {
+	if scannedDirectories[aliasedDirectory] && pendingFiles[aliasedDirectory] == 0 {
+		completedDirectories[aliasedDirectory] = true
+		delete(scannedDirectories, aliasedDirectory)
+		delete(pendingFiles, aliasedDirectory)
+	}
+}
+
+func aliasedDirectoryOf(aliasedPath string) string {
+	pos := strings.LastIndex(aliasedPath, "\\")
+	if pos < 0 {
+		return aliasedPath
+	}
+	return aliasedPath[:pos]
+}
+
+func checkpointFilename(alias string) string {
+	return path.Join(common.ConfigDirectory, fmt.Sprintf("findaphotoindexer-checkpoint-%s.json", alias))
+}
@@ -0,0 +1,111 @@
+package helpers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+)
+
+var dryRunLock sync.Mutex
+var dryRunReport = newDryRunReport()
+
+func newDryRunReport() *common.DryRunReport {
+	return &common.DryRunReport{
+		Started:    time.Now(),
+		Added:      make([]string, 0),
+		Updated:    make([]common.DryRunUpdate, 0),
+		Removed:    make([]string, 0),
+		Duplicates: make([]common.DryRunDuplicate, 0),
+		Thumbnails: make([]string, 0),
+	}
+}
+
+// The media would be indexed; 'existing' is the stored document, if there is one
+func DryRunIndexed(media *common.Media, existing *json.RawMessage) {
+	dryRunLock.Lock()
+	defer dryRunLock.Unlock()
+
+	if existing == nil {
+		dryRunReport.Added = append(dryRunReport.Added, media.Path)
+	} else {
+		dryRunReport.Updated = append(dryRunReport.Updated, common.DryRunUpdate{
+			Path:          media.Path,
+			ChangedFields: changedFields(media, existing),
+		})
+	}
+}
+
+func DryRunRemoved(aliasedPath string) {
+	dryRunLock.Lock()
+	defer dryRunLock.Unlock()
+	dryRunReport.Removed = append(dryRunReport.Removed, aliasedPath)
+}
+
+func DryRunDuplicate(aliasedPath, duplicateOf string) {
+	dryRunLock.Lock()
+	defer dryRunLock.Unlock()
+	dryRunReport.Duplicates = append(dryRunReport.Duplicates, common.DryRunDuplicate{Path: aliasedPath, DuplicateOf: duplicateOf})
+}
+
+func DryRunThumbnail(aliasedPath string) {
+	dryRunLock.Lock()
+	defer dryRunLock.Unlock()
+	dryRunReport.Thumbnails = append(dryRunReport.Thumbnails, aliasedPath)
+}
+
+// Write the report of everything since the previous call, then start a new report
+func WriteDryRunReport(alias, scanPath, filename string) error {
+	dryRunLock.Lock()
+	report := dryRunReport
+	dryRunReport = newDryRunReport()
+	dryRunLock.Unlock()
+
+	report.Alias = alias
+	report.Path = scanPath
+	report.Completed = time.Now()
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// The top level fields that differ between the media and the stored document. Both are
+// compared as JSON, so that values are compared the same way they're stored.
+func changedFields(media *common.Media, existing *json.RawMessage) []string {
+	changed := make([]string, 0)
+
+	var stored map[string]interface{}
+	if err := json.Unmarshal(*existing, &stored); err != nil {
+		return append(changed, "*")
+	}
+
+	var updated map[string]interface{}
+	data, err := json.Marshal(media)
+	if err == nil {
+		err = json.Unmarshal(data, &updated)
+	}
+	if err != nil {
+		return append(changed, "*")
+	}
+
+	for name, value := range updated {
+		if !reflect.DeepEqual(value, stored[name]) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range stored {
+		if _, exists := updated[name]; !exists {
+			changed = append(changed, name)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
@@ -2,6 +2,8 @@ package helpers
 
 import (
 	"container/list"
+	"encoding/json"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,64 +18,125 @@ import (
 
 var DuplicateCheckFailed int64
 var DuplicatesIgnored int64
+var FalseDuplicatesAvoided int64
 
 type expiringItem struct {
 	insertTime time.Time
 	signature  string
 }
 
+type recentItem struct {
+	aliasedPath   string
+	fullSignature string
+}
+
 const expirationCheckLengthSeconds = 5
 const expireDurationSeconds = 20
 
-var recentItems = make(map[string]string)
+var recentItems = make(map[string]recentItem)
 var recentItemsLock sync.Mutex
 var itemList = list.New()
 var nextExpirationCheck = time.Now().UTC().Add(expirationCheckLengthSeconds * time.Second)
 
-// Check for duplicates. To handle the delay from indexing to being available for searching,
-// track everything for the last few seconds ('expireDurationSeconds')
-func IsDuplicate(client *elastic.Client, signature string, aliasedPath string, markAsIndexed bool) bool {
+// What the index holds for a candidate file: the document with the same path (check 'Found') and the
+// documents with the same (quick) signature. 'Err' is set if either couldn't be retrieved.
+type IndexedState struct {
+	Document         *elastic.GetResult
+	SignatureMatches *elastic.SearchResult
+	Err              error
+}
+
+// Retrieve the indexed state of a batch of files with a single multi-get (by path) and a single
+// multi-search (by signature), rather than searches for each file. The results are in the same
+// order as 'aliasedPaths'.
+func LookupIndexedState(client *elastic.Client, aliasedPaths []string, signatures []string) ([]*IndexedState, error) {
+	states := make([]*IndexedState, len(aliasedPaths))
+	if len(aliasedPaths) < 1 {
+		return states, nil
+	}
+
+	multiGet := client.MultiGet()
+	multiSearch := client.MultiSearch()
+	for idx, aliasedPath := range aliasedPaths {
+		multiGet.Add(elastic.NewMultiGetItem().
+			Index(common.MediaIndexName).
+			Type(common.MediaTypeName).
+			Id(aliasedPath))
+
+		multiSearch.Add(elastic.NewSearchRequest().
+			Index(common.MediaIndexName).
+			Type(common.MediaTypeName).
+			SearchSource(elastic.NewSearchSource().Query(elastic.NewTermQuery("signature", signatures[idx]))))
+	}
+
+	getResponse, err := multiGet.Do(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	searchResponse, err := multiSearch.Do(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	if len(getResponse.Docs) != len(aliasedPaths) || len(searchResponse.Responses) != len(aliasedPaths) {
+		return nil, fmt.Errorf("Expected %d results, got %d documents and %d searches",
+			len(aliasedPaths), len(getResponse.Docs), len(searchResponse.Responses))
+	}
+
+	for idx := range aliasedPaths {
+		state := &IndexedState{Document: getResponse.Docs[idx], SignatureMatches: searchResponse.Responses[idx]}
+		if state.Document.Error != nil {
+			state.Err = fmt.Errorf("document lookup failed: %s", state.Document.Error.Reason)
+		} else if state.SignatureMatches.Error != nil {
+			state.Err = fmt.Errorf("signature search failed: %s", state.SignatureMatches.Error.Reason)
+		}
+		states[idx] = state
+	}
+
+	return states, nil
+}
+
+// Check for duplicates, using the state retrieved by 'LookupIndexedState'. To handle the delay from
+// indexing to being available for searching, track everything for the last few seconds ('expireDurationSeconds')
+// The (quick) signature finds candidates, the full signature confirms them. If 'fullSignature' is empty,
+// it's generated from the file only when needed - and stored for the caller.
+func IsDuplicate(client *elastic.Client, state *IndexedState, signature string, fullSignature *string, aliasedPath string, markAsIndexed bool) bool {
+	// A file may be compared to several with the same quick signature, it's counted once
+	differs := false
+	duplicate := isDuplicate(client, state, signature, fullSignature, aliasedPath, markAsIndexed, &differs)
+	if differs && !duplicate {
+		atomic.AddInt64(&FalseDuplicatesAvoided, 1)
+		log.Info("Same quick signature, different content: %s", aliasedPath)
+	}
+	return duplicate
+}
+
+// 'differs' is set if a file with the same quick signature had different content
+func isDuplicate(client *elastic.Client, state *IndexedState, signature string, fullSignature *string, aliasedPath string, markAsIndexed bool, differs *bool) bool {
 
 	if shouldCheckExpiredItems() {
 		checkAndRemoveExpiredItems()
 	}
 
 	// Is this a recent item?
-	if isRecent, original := isRecentItem(signature); isRecent {
-		atomic.AddInt64(&DuplicatesIgnored, 1)
-		AddDuplicateToIndex(client, aliasedPath, original)
-		return true
-	}
-
-	signatureExistsResult, err := client.Search().
-		Index(common.MediaIndexName).
-		Type(common.MediaTypeName).
-		Query(elastic.NewTermQuery("signature", signature)).
-		Pretty(true).
-		Do(context.TODO())
-	if err != nil {
-		atomic.AddInt64(&DuplicateCheckFailed, 1)
-		log.Error("Error checking signature existence for '%s': %s", aliasedPath, err.Error())
-		return false
+	if isRecent, original := isRecentItem(signature); isRecent && original.aliasedPath != aliasedPath {
+		if isSameContent(aliasedPath, fullSignature, original.fullSignature, differs) {
+			atomic.AddInt64(&DuplicatesIgnored, 1)
+			AddDuplicateToIndex(client, aliasedPath, original.aliasedPath)
+			return true
+		}
 	}
 
-	signatureExists := signatureExistsResult.TotalHits() > 0
-
-	pathSearchResult, err := client.Search().
-		Index(common.MediaIndexName).
-		Type(common.MediaTypeName).
-		Query(elastic.NewTermQuery("_id", aliasedPath)).
-		Pretty(true).
-		Do(context.TODO())
-	if err != nil {
+	if state.Err != nil {
 		atomic.AddInt64(&DuplicateCheckFailed, 1)
-		log.Error("Error checking document existence for '%s': %s", aliasedPath, err.Error())
+		log.Error("Error checking signature existence for '%s': %s", aliasedPath, state.Err.Error())
 		return false
 	}
-	pathExists := pathSearchResult.TotalHits() > 0
+
+	signatureExists := state.SignatureMatches.TotalHits() > 0
+	pathExists := state.Document.Found
 
 	if signatureExists && !pathExists {
-		media := returnFirstMatch(signatureExistsResult)
+		media := firstSameContentMatch(client, state.SignatureMatches, aliasedPath, fullSignature, differs)
 		if media != nil {
 			AddDuplicateToIndex(client, aliasedPath, media.Path)
 
@@ -83,33 +146,83 @@ func IsDuplicate(client *elastic.Client, signature string, aliasedPath string, m
 			} else {
 				classifymedia.Enqueue(fullPath, media.Path, media.Tags)
 			}
-		} else {
-			log.Error("Unable to get original path from duplicate search result")
+			atomic.AddInt64(&DuplicatesIgnored, 1)
+			return true
 		}
-		atomic.AddInt64(&DuplicatesIgnored, 1)
-		return true
 	}
 
 	if markAsIndexed {
 		recentItemsLock.Lock()
-		defer recentItemsLock.Unlock()
-
 		original, exists := recentItems[signature]
-		if exists {
-			AddDuplicateToIndex(client, aliasedPath, original)
+		if !exists {
+			recentItems[signature] = recentItem{aliasedPath: aliasedPath, fullSignature: *fullSignature}
+			itemList.PushBack(&expiringItem{
+				signature:  signature,
+				insertTime: time.Now().UTC(),
+			})
+		}
+		recentItemsLock.Unlock()
+
+		// Comparing may read the whole file, so it's done without the lock
+		if exists && original.aliasedPath != aliasedPath && isSameContent(aliasedPath, fullSignature, original.fullSignature, differs) {
+			AddDuplicateToIndex(client, aliasedPath, original.aliasedPath)
 			return true
 		}
-		recentItems[signature] = aliasedPath
-		itemList.PushBack(&expiringItem{
-			signature:  signature,
-			insertTime: time.Now().UTC(),
-		})
 	}
 
 	return false
 }
 
-func isRecentItem(signature string) (bool, string) {
+// Returns the first search result that has the same full signature as the candidate file
+func firstSameContentMatch(client *elastic.Client, result *elastic.SearchResult, aliasedPath string, fullSignature *string, differs *bool) *common.Media {
+	for _, hit := range result.Hits.Hits {
+		media := &common.Media{}
+		err := json.Unmarshal(*hit.Source, media)
+		if err != nil {
+			log.Error("Unable to get original path from duplicate search result: %s", err.Error())
+			continue
+		}
+
+		existingFullSignature := media.FullSignature
+		if existingFullSignature == "" {
+			existingFullSignature, err = BackfillFullSignature(client, media)
+			if err != nil {
+				log.Error("Unable to generate full signature for %s: %s", media.Path, err.Error())
+				continue
+			}
+		}
+
+		if isSameContent(aliasedPath, fullSignature, existingFullSignature, differs) {
+			return media
+		}
+	}
+
+	return nil
+}
+
+// Sets 'differs' if the full signatures don't match
+func isSameContent(aliasedPath string, fullSignature *string, existingFullSignature string, differs *bool) bool {
+	if *fullSignature == "" {
+		fullPath, err := common.FullPathForAliasedPath(aliasedPath)
+		if err == nil {
+			*fullSignature, err = GenerateFullSignature(fullPath)
+		}
+		if err != nil {
+			// Without the full signature, indexing a duplicate is preferred over dropping a unique file
+			atomic.AddInt64(&DuplicateCheckFailed, 1)
+			log.Error("Unable to generate full signature for %s: %s", aliasedPath, err.Error())
+			return false
+		}
+	}
+
+	if *fullSignature != existingFullSignature {
+		*differs = true
+		return false
+	}
+	return true
+}
+
+func isRecentItem(signature string) (bool, recentItem) {
 	recentItemsLock.Lock()
 	defer recentItemsLock.Unlock()
 
@@ -1,8 +1,6 @@
 package helpers
 
 import (
-	"encoding/json"
-
 	"github.com/ian-kent/go-log/log"
 	"github.com/kevintavog/findaphoto/common"
 
@@ -11,6 +9,10 @@ import (
 )
 
 func InitializeDuplicates() {
+	if common.IndexMakeNoChanges {
+		return
+	}
+
 	client := common.CreateClient()
 
 	_, err := client.DeleteByQuery().
@@ -25,6 +27,12 @@ func InitializeDuplicates() {
 }
 
 func AddDuplicateToIndex(client *elastic.Client, ignoredPath string, existingPath string) {
+	if common.IndexMakeNoChanges {
+		log.Info("WOULD mark %v as a duplicate of %v", ignoredPath, existingPath)
+		DryRunDuplicate(ignoredPath, existingPath)
+		return
+	}
+
 	dupItem := &common.DuplicateItem{
 		IgnoredPath:  ignoredPath,
 		ExistingPath: existingPath,
@@ -41,17 +49,3 @@ func AddDuplicateToIndex(client *elastic.Client, ignoredPath string, existingPat
 		log.Error("Failed adding DupliateItem %s: %s", ignoredPath, err.Error())
 	}
 }
-
-func returnFirstMatch(result *elastic.SearchResult) *common.Media {
-	if result.TotalHits() > 0 {
-		hit := result.Hits.Hits[0]
-		media := &common.Media{}
-		err := json.Unmarshal(*hit.Source, media)
-		if err != nil {
-			return nil
-		}
-		return media
-	}
-
-	return nil
-}
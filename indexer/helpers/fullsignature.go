@@ -0,0 +1,77 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/kevintavog/findaphoto/common"
+
+	"github.com/ian-kent/go-log/log"
+	"golang.org/x/net/context"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+var FullSignaturesGenerated int64
+var FullSignaturesFailed int64
+var FullSignaturesBackfilled int64
+
+type fullSignatureUpdate struct {
+	FullSignature string `json:"fullsignature"`
+}
+
+// The signature of the entire file; the quick signature (checkindex) covers only the start of the file.
+func GenerateFullSignature(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		atomic.AddInt64(&FullSignaturesFailed, 1)
+		return "", err
+	}
+	defer file.Close()
+
+	sha := sha256.New()
+	_, err = io.Copy(sha, file)
+	if err != nil {
+		atomic.AddInt64(&FullSignaturesFailed, 1)
+		return "", err
+	}
+
+	atomic.AddInt64(&FullSignaturesGenerated, 1)
+	return hex.EncodeToString(sha.Sum(nil)), nil
+}
+
+// Documents indexed before full signatures existed don't have one - generate it from the file and
+// store it with the document.
+func BackfillFullSignature(client *elastic.Client, media *common.Media) (string, error) {
+	fullPath, err := common.FullPathForAliasedPath(media.Path)
+	if err != nil {
+		return "", err
+	}
+
+	fullSignature, err := GenerateFullSignature(fullPath)
+	if err != nil {
+		return "", err
+	}
+	media.FullSignature = fullSignature
+
+	if common.IndexMakeNoChanges {
+		log.Info("WOULD update full signature of %v", media.Path)
+		return fullSignature, nil
+	}
+
+	_, err = client.Update().
+		Index(common.MediaIndexName).
+		Type(common.MediaTypeName).
+		Id(media.Path).
+		Doc(fullSignatureUpdate{FullSignature: fullSignature}).
+		Do(context.TODO())
+	if err != nil {
+		log.Error("Failed storing full signature for %s: %s", media.Path, err.Error())
+	} else {
+		atomic.AddInt64(&FullSignaturesBackfilled, 1)
+	}
+
+	return fullSignature, nil
+}
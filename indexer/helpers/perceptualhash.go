@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"sync/atomic"
+
+	"github.com/kevintavog/findaphoto/common"
+
+	"github.com/ian-kent/go-log/log"
+	"golang.org/x/net/context"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+var PerceptualHashesGenerated int64
+var PerceptualHashesFailed int64
+
+type perceptualHashUpdate struct {
+	PerceptualHash string `json:"perceptualhash"`
+}
+
+// The perceptual hash is generated from the thumbnail, which is small and exists for images and videos.
+// An empty string is returned if the thumbnail doesn't exist (yet)
+func PerceptualHashFromThumbnail(aliasedPath string) string {
+	thumbPath := common.ToThumbPath(aliasedPath)
+	if exists, _ := common.FileExists(thumbPath); !exists {
+		return ""
+	}
+
+	hash, err := common.PerceptualHashFromFile(thumbPath)
+	if err != nil {
+		atomic.AddInt64(&PerceptualHashesFailed, 1)
+		log.Warn("Failed generating perceptual hash for %s: %s", aliasedPath, err.Error())
+		return ""
+	}
+
+	atomic.AddInt64(&PerceptualHashesGenerated, 1)
+	return hash
+}
+
+// Update the document with the perceptual hash of its thumbnail. Thumbnails are generated independently
+// of indexing, so the document may not exist yet - in that case, the hash is added when it's indexed.
+func UpdatePerceptualHash(client *elastic.Client, aliasedPath string) {
+	hash := PerceptualHashFromThumbnail(aliasedPath)
+	if hash == "" || common.IndexMakeNoChanges {
+		return
+	}
+
+	_, err := client.Update().
+		Index(common.MediaIndexName).
+		Type(common.MediaTypeName).
+		Id(aliasedPath).
+		Doc(perceptualHashUpdate{PerceptualHash: hash}).
+		Do(context.TODO())
+	if err != nil && !elastic.IsNotFound(err) {
+		log.Error("Failed storing perceptual hash for %s: %s", aliasedPath, err.Error())
+	}
+}
@@ -4,7 +4,9 @@ import (
 	_ "net/http"
 	_ "net/http/pprof"
 	"os"
+	"path"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/kevintavog/findaphoto/common"
@@ -15,6 +17,8 @@ import (
 	"github.com/kevintavog/findaphoto/indexer/steps/generatethumbnail"
 	"github.com/kevintavog/findaphoto/indexer/steps/getexif"
 	"github.com/kevintavog/findaphoto/indexer/steps/indexmedia"
+	"github.com/kevintavog/findaphoto/indexer/steps/preparemedia"
+	"github.com/kevintavog/findaphoto/indexer/steps/probevideo"
 	"github.com/kevintavog/findaphoto/indexer/steps/resolveplacename"
 	"github.com/kevintavog/findaphoto/indexer/steps/scanner"
 
@@ -36,23 +40,51 @@ func main() {
 		log.Fatal("ffmpeg isn't usable (path is '%s')", common.FfmpegPath)
 	}
 	generatethumbnail.VipsExists = common.IsExecWorking(common.VipsThumbnailPath, "--vips-version")
+	probevideo.FfprobeExists = common.IsExecWorking(common.FfprobePath, "-version")
 
 	app := cli.App("indexer", "The FindAPhoto indexer")
-	app.Spec = "-p -s -r -l [-a] [-i] [--reindex] [-v]"
+	app.Spec = "(-p | -w | --resolve-placenames | --migrate-warnings | --geotag | --migrate-index) -s -r [--transform...] [--keep-old-index] [-l] [--geonames] [-a] [-i] [--reindex] [--scan-interval] [--progress] [-e] [--backfill-signatures] [--batch-size] [--flush-seconds] [-x...] [--dry-run] [--report] [--timezones] [--location-cache-meters] [--lookup-timeout] [--placename-query] [--placename-refresh] [--gpx] [--gpx-max-gap] [--gpx-offset] [--gpx-write] [-v]"
 	indexPrefix := app.StringOpt("i", "", "The prefix for the index (for development) (optional)")
 	scanPath := app.StringOpt("p path", "", "The path to recursively index")
+	watch := app.BoolOpt("w watch", false, "Continuously watch all indexed paths for changes, rather than index a single path")
+	reresolvePlacenames := app.BoolOpt("resolve-placenames", false, "Resolve the placenames of indexed media again, rather than index a path; files aren't read")
+	migrateLegacyWarnings := app.BoolOpt("migrate-warnings", false, "Give codes to the warnings of media indexed before warnings had them, rather than index a path; files aren't read")
+	geotagIndexed := app.BoolOpt("geotag", false, "Give indexed media without a location one from the GPX tracks, rather than index a path")
+	migrateIndex := app.BoolOpt("migrate-index", false, "Copy the media index to one with the current mapping, then switch to it, rather than index a path; files aren't read")
+	fieldTransforms := app.StringsOpt("transform", nil, "With --migrate-index, 'field=<painless expression>' sets the field of each document as it's copied, 'field=' removes it; may be repeated (optional)")
+	keepOldIndex := app.BoolOpt("keep-old-index", false, "With --migrate-index, keep the index migrated from (optional)")
+	placenameQueryString := app.StringOpt("placename-query", "", "With --resolve-placenames, the query selecting the media; by default, media with no placename, a failed lookup, or a placename reused from further than --location-cache-meters (optional)")
+	refreshPlacenames := app.BoolOpt("placename-refresh", false, "With --resolve-placenames, look every location up rather than reuse cached placenames (optional)")
+	scanIntervalMinutes := app.IntOpt("scan-interval", 12*60, "When watching, the number of minutes between full scans (optional)")
 	server := app.StringOpt("s server", "", "The URL for the ElasticSearch server")
 	redisServer := app.StringOpt("r", "", "The URL for the Redis server")
-	locationLookupUrl := app.StringOpt("l", "", "The URL for the location lookup (ReverseNameLookup)")
+	locationLookupUrl := app.StringOpt("l", "", "The URL for the location lookup (ReverseNameLookup); when not given, the GeoNames files are used (optional)")
+	geoNamesDirectory := app.StringOpt("geonames", resolveplacename.DefaultGeoNamesDirectory(), "The folder with the GeoNames cities & admin1 codes files, for offline location lookups (optional)")
 	forceIndex := app.BoolOpt("reindex", false, "Force everything to be re-indexed; current index not deleted. (optional)")
+	backfillSignatures := app.BoolOpt("backfill-signatures", false, "Add the full file signature to indexed documents missing it; reads every file. (optional)")
 	aliasPathOverride := app.StringOpt("a", "", "The alias path override, for development")
+	extensions := app.StringOpt("e extensions", "", "Comma separated list of file extensions to index, replacing the defaults (optional)")
+	excludePatterns := app.StringsOpt("x exclude", nil, "A gitignore-style pattern for files and folders to skip, in addition to '.findaphotoignore' files; may be repeated (optional)")
+	reportProgress := app.BoolOpt("progress", false, "Periodically write progress, as JSON lines, to stdout (optional)")
+	batchSize := app.IntOpt("batch-size", indexmedia.BatchSize, "The number of documents written to ElasticSearch in each bulk request (optional)")
+	flushSeconds := app.IntOpt("flush-seconds", int(indexmedia.FlushInterval.Seconds()), "The most seconds documents wait before being written to ElasticSearch (optional)")
+	dryRun := app.BoolOpt("dry-run", false, "Make no changes, report what would change instead (optional)")
+	reportFilename := app.StringOpt("report", path.Join(common.LogDirectory, "findaphotoindexer-dryrun.json"), "The file the dry run report (JSON) is written to (optional)")
+	timeZonesFilename := app.StringOpt("timezones", common.DefaultTimeZoneBoundaryFilename(), "A GeoJSON file of time zone boundaries, for the time zone of media with a location but no offset (optional)")
+	locationCacheMeters := app.IntOpt("location-cache-meters", int(resolveplacename.CacheRadiusMeters), "Locations within this many meters of a previous lookup reuse its placename (optional)")
+	lookupTimeoutSeconds := app.IntOpt("lookup-timeout", int(resolveplacename.LookupTimeout.Seconds()), "The most seconds a single location lookup request may take (optional)")
+	gpxDirectory := app.StringOpt("gpx", common.DefaultGpxDirectory(), "The folder with GPX track logs, for the location of media without one (optional)")
+	gpxMaxGapSeconds := app.IntOpt("gpx-max-gap", int(preparemedia.GpxMaxGap.Seconds()), "Track points further apart than this many seconds aren't interpolated between (optional)")
+	gpxOffsetSeconds := app.IntOpt("gpx-offset", 0, "How many seconds ahead of the track logger's clock the camera's clock is, negative if behind (optional)")
+	gpxWrite := app.BoolOpt("gpx-write", false, "Write locations from the tracks to the sidecar, creating one if needed (optional)")
 	app.Version("v", "Show the version and exit")
 	app.Action = func() {
 
-		// common.IndexMakeNoChanges = true
+		common.IndexMakeNoChanges = *dryRun
 		common.MediaIndexName = *indexPrefix + common.MediaIndexName
 		common.RedisServer = *redisServer
 		common.AliasPathOverride = *aliasPathOverride
+		common.TimeZoneBoundaryFilename = *timeZonesFilename
 
 		log.Info("%s: FindAPhoto scanning %s, and indexing to %s/%s; using %d/%d CPU's",
 			time.Now().Format("2006-01-02"),
@@ -61,16 +93,34 @@ func main() {
 			common.MediaIndexName,
 			runtime.NumCPU(),
 			runtime.GOMAXPROCS(0))
-		log.Info("Using %s to resolve locations to placename", *locationLookupUrl)
-
 		if common.IndexMakeNoChanges {
-			log.Info("NOT making any changes")
+			log.Info("NOT making any changes, writing what would change to %s", *reportFilename)
+		}
+
+		if len(*extensions) > 0 {
+			scanner.SetSupportedExtensions(strings.Split(*extensions, ","))
+			log.Info("Indexing files with these extensions: %s", *extensions)
+		}
+
+		if *batchSize < 1 || *flushSeconds < 1 {
+			log.Fatalf("The batch size and flush seconds must be at least 1")
+		}
+		indexmedia.BatchSize = *batchSize
+		indexmedia.FlushInterval = time.Duration(*flushSeconds) * time.Second
+
+		if len(*excludePatterns) > 0 {
+			scanner.SetExcludePatterns(*excludePatterns)
+			log.Info("Excluding: %s", strings.Join(*excludePatterns, ", "))
 		}
 
 		checkindex.ForceIndex = *forceIndex
 		if checkindex.ForceIndex {
 			log.Warn("Re-indexing all documents")
 		}
+		checkindex.BackfillFullSignatures = *backfillSignatures
+		if checkindex.BackfillFullSignatures {
+			log.Warn("Adding full signatures to existing documents")
+		}
 
 		if !common.IndexMakeNoChanges {
 			c, err := redis.DialURL(*redisServer)
@@ -81,24 +131,85 @@ func main() {
 		}
 
 		common.ElasticSearchServer = *server
-		resolveplacename.LocationLookupUrl = *locationLookupUrl
+		if *lookupTimeoutSeconds < 1 {
+			log.Fatalf("The lookup timeout must be at least 1 second")
+		}
+		resolveplacename.LookupTimeout = time.Duration(*lookupTimeoutSeconds) * time.Second
+		configureGeocoder(*locationLookupUrl, *geoNamesDirectory)
+		resolveplacename.CacheRadiusMeters = float64(*locationCacheMeters)
+		configureGeotagging(*gpxDirectory, *gpxMaxGapSeconds, *gpxOffsetSeconds, *gpxWrite)
 
 		checkServerAndIndex()
+
+		if !generatethumbnail.VipsExists {
+			log.Warn("Unable to use the 'vipsthumbnails' command, defaulting to slower slide generation (path is '%s')", common.VipsThumbnailPath)
+		}
+		if !probevideo.FfprobeExists {
+			log.Warn("Unable to use the 'ffprobe' command, video details won't be indexed (path is '%s')", common.FfprobePath)
+		}
+
+		handleSignals()
+
+		if *migrateIndex {
+			migrateMediaIndex(*fieldTransforms, *keepOldIndex)
+			return
+		}
+
+		if *reresolvePlacenames {
+			resolveplacename.BypassCache = *refreshPlacenames
+			resolvePlacenames(*placenameQueryString)
+			writeDryRunReport("", "", *reportFilename)
+			return
+		}
+
+		if *geotagIndexed {
+			geotagIndexedMedia()
+			writeDryRunReport("", "", *reportFilename)
+			return
+		}
+
+		if *migrateLegacyWarnings {
+			migrateWarnings()
+			writeDryRunReport("", "", *reportFilename)
+			return
+		}
+
+		if *watch {
+			if *reportProgress {
+				startProgressReporting("", "", time.Now())
+			}
+			watchAllPaths(time.Duration(*scanIntervalMinutes)*time.Minute, *reportFilename)
+			return
+		}
+
 		alias, err := common.AliasForPath(*scanPath)
 		if err != nil {
 			log.Fatalf("Unable to get alias for '%s': %s", *scanPath, err.Error())
 		}
 
-		if !generatethumbnail.VipsExists {
-			log.Warn("Unable to use the 'vipsthumbnails' command, defaulting to slower slide generation (path is '%s')", common.VipsThumbnailPath)
+		scanStartTime := time.Now()
+		stopProgress := func() {}
+		if *reportProgress {
+			stopProgress = startProgressReporting(alias, *scanPath, scanStartTime)
 		}
 
-		scanStartTime := time.Now()
+		run := startRun(alias, *scanPath)
+		stopCheckpointing := startCheckpointing(alias, *scanPath, *forceIndex)
 		helpers.InitializeDuplicates()
 		classifymedia.Start()
 		scanner.Scan(*scanPath, alias)
 		scanDuration := time.Now().Sub(scanStartTime).Seconds()
+		stopProgress()
+		stopCheckpointing(!scanner.Stopped())
 		emitStats(scanDuration)
+		writeSkippedReport(alias, *scanPath)
+		writeDryRunReport(alias, *scanPath, *reportFilename)
+		run.finish()
+
+		// The alias isn't marked as indexed until a run completes
+		if scanner.Stopped() {
+			return
+		}
 
 		if !common.IndexMakeNoChanges {
 			err = common.UpdateLastIndexed(alias)
@@ -111,6 +222,86 @@ func main() {
 	app.Run(os.Args)
 }
 
+func watchAllPaths(fullScanInterval time.Duration, reportFilename string) {
+	if fullScanInterval < time.Minute {
+		log.Fatalf("The scan interval must be at least one minute")
+	}
+
+	log.Info("Watching all paths for changes, with a full scan every %s", fullScanInterval)
+
+	helpers.InitializeDuplicates()
+	classifymedia.Start()
+
+	scanStartTime := time.Now()
+	run := startWatchRun()
+	scanner.Watch(fullScanInterval, func() {
+		emitStats(time.Now().Sub(scanStartTime).Seconds())
+		log.Info("%d watch events, %d files queued from watching, %d directories watched, %d full scans",
+			scanner.WatchEvents, scanner.WatchFilesQueued, scanner.WatchDirectoriesAdded, scanner.FullScans)
+		writeSkippedReport("", "")
+		writeDryRunReport("", "", reportFilename)
+
+		if !common.IndexMakeNoChanges {
+			common.VisitAllPaths(func(alias common.AliasDocument) {
+				err := common.UpdateLastIndexed(alias.Alias)
+				if err != nil {
+					log.Warn("Failed updating indexed date: '%s'", err.Error())
+				}
+			})
+		}
+		scanStartTime = time.Now()
+	})
+
+	// Watch returns once the pipeline has drained
+	run.finish()
+}
+
+func writeDryRunReport(alias, scanPath, filename string) {
+	if !common.IndexMakeNoChanges {
+		return
+	}
+
+	err := helpers.WriteDryRunReport(alias, scanPath, filename)
+	if err != nil {
+		log.Error("Failed writing the dry run report to '%s': %s", filename, err.Error())
+	} else {
+		log.Info("Wrote the dry run report to %s", filename)
+	}
+}
+
+// The lookup service is used if it's given, otherwise the offline GeoNames files
+func configureGeocoder(locationLookupUrl, geoNamesDirectory string) {
+	if locationLookupUrl != "" {
+		resolveplacename.ActiveGeocoder = resolveplacename.NewHTTPGeocoder(locationLookupUrl)
+	} else {
+		geocoder, err := resolveplacename.NewGeoNamesGeocoder(geoNamesDirectory)
+		if err != nil {
+			log.Warn("Locations won't be resolved to placenames: %s", err.Error())
+			return
+		}
+		resolveplacename.ActiveGeocoder = geocoder
+	}
+	log.Info("Using %s to resolve locations to placename", resolveplacename.ActiveGeocoder.Name())
+}
+
+func configureGeotagging(gpxDirectory string, maxGapSeconds, offsetSeconds int, writeBack bool) {
+	if maxGapSeconds < 1 {
+		log.Fatalf("The GPX max gap must be at least 1 second")
+	}
+	preparemedia.GpxMaxGap = time.Duration(maxGapSeconds) * time.Second
+	preparemedia.GpxTimeOffset = time.Duration(offsetSeconds) * time.Second
+	preparemedia.GpxWriteBack = writeBack
+
+	count, err := preparemedia.LoadGpxTracks(gpxDirectory)
+	if err != nil {
+		log.Warn("Media won't be located from GPX tracks: %s", err.Error())
+		return
+	}
+	if count > 0 {
+		log.Info("Loaded %d track points from %s", count, gpxDirectory)
+	}
+}
+
 func emitStats(seconds float64) {
 	filesPerSecond := float64(scanner.SupportedFilesFound) / seconds
 
@@ -124,17 +315,42 @@ func emitStats(seconds float64) {
 	log.Info("%d exiftool invocations, %d failed",
 		getexif.ExifToolInvocations, getexif.ExifToolFailed)
 
+	log.Info("%d ffprobe invocations, %d failed",
+		probevideo.FfprobeInvocations, probevideo.FfprobeFailed)
+
 	log.Info("%d locations lookup attempts, %d location lookup failures, %d server errors, %d other failures",
 		resolveplacename.PlacenameLookups, resolveplacename.FailedLookups, resolveplacename.ServerErrors, resolveplacename.Failures)
 
+	log.Info("%d media located from GPX tracks; %d locations written to files, %d failed",
+		preparemedia.GeotagMatched, preparemedia.GeotagWritten, preparemedia.GeotagWriteFailed)
+
+	log.Info("%d placename cache hits, %d misses (within %01.0f meters)",
+		resolveplacename.CacheHits, resolveplacename.CacheMisses, resolveplacename.CacheRadiusMeters)
+
+	log.Info("%d location lookup retries, lookups paused %d times; %d lookups deferred, %d resolved later",
+		resolveplacename.LookupRetries, resolveplacename.BreakerOpened, resolveplacename.LookupsDeferred, resolveplacename.DeferredResolved)
+
 	log.Info("%d image thumbnails created, %d failed; %d video thumbnails created, %d failed; %d failed thumbnail checks",
 		generatethumbnail.GeneratedImage, generatethumbnail.FailedImage, generatethumbnail.GeneratedVideo, generatethumbnail.FailedVideo, checkthumbnail.FailedChecks)
 
+	log.Info("%d perceptual hashes generated, %d failed",
+		helpers.PerceptualHashesGenerated, helpers.PerceptualHashesFailed)
+
 	log.Info("%d files indexed, %d duplicates ignored, %d failed and %d added due to detected changes",
 		indexmedia.IndexedFiles, helpers.DuplicatesIgnored, indexmedia.FailedIndexAttempts, indexmedia.ChangedFiles)
 
+	log.Info("%d full signatures generated, %d failed, %d backfilled; %d false duplicates avoided",
+		helpers.FullSignaturesGenerated, helpers.FullSignaturesFailed, helpers.FullSignaturesBackfilled, helpers.FalseDuplicatesAvoided)
+
 	log.Info("%d media scanned, %d removed from the index",
 		scanner.MediaScanned, scanner.MediaRemoved)
+
+	log.Info("%d files and %d folders skipped by ignore rules, %d already indexed files removed",
+		scanner.FilesIgnored, scanner.DirectoriesIgnored, scanner.IgnoredMediaRemoved)
+
+	if scanner.FilesResumed > 0 {
+		log.Info("%d files skipped, their folders were completed by the interrupted run", scanner.FilesResumed)
+	}
 }
 
 func checkServerAndIndex() {
@@ -161,8 +377,18 @@ func checkServerAndIndex() {
 		log.Fatal("The index '%s' doesn't exist", common.AliasIndexName)
 	}
 
+	// Indexes created before warnings had codes don't have their mapping
+	if !common.IndexMakeNoChanges {
+		err = common.AddWarningEntriesMapping(client)
+		if err != nil {
+			log.Fatal("Failed adding the warnings mapping: %s", err.Error())
+		}
+	}
+
 	err = common.InitializeAliases(client)
 	if err != nil {
 		log.Fatal("Failed initializing aliases: %s", err.Error())
 	}
+
+	common.CheckMediaIndexVersion(client)
 }
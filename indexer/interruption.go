@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/helpers"
+	"github.com/kevintavog/findaphoto/indexer/steps/scanner"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+const checkpointInterval = 30 * time.Second
+
+// The first SIGINT/SIGTERM stops finding files, letting the queued files drain through the steps.
+// A second one exits immediately, after writing the checkpoint.
+func handleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		log.Warn("Received %s, finishing the files already queued (repeat to exit immediately)", sig)
+		scanner.Stop()
+
+		sig = <-signals
+		log.Warn("Received %s again, exiting", sig)
+		helpers.WriteCheckpoint()
+		os.Exit(1)
+	}()
+}
+
+// Track completed directories, periodically writing them to the checkpoint file in case the indexer
+// crashes. Unless 'restart' is set, directories completed by an earlier, unfinished run are skipped.
+// The returned function writes the checkpoint a final time - or removes it if the run completed.
+// A dry run neither resumes from nor changes the checkpoint, which may be a real run's.
+func startCheckpointing(alias, scanPath string, restart bool) func(completed bool) {
+	if common.IndexMakeNoChanges {
+		return func(completed bool) {}
+	}
+
+	var resume *helpers.Checkpoint
+	if restart {
+		helpers.RemoveCheckpoint(alias)
+	} else {
+		resume = helpers.LoadCheckpoint(alias, scanPath)
+		if resume != nil {
+			log.Warn("Resuming the run started %s; skipping %d completed directories",
+				resume.Started.Format(time.RFC3339), len(resume.CompletedDirectories))
+		}
+	}
+	helpers.StartCheckpoint(alias, scanPath, resume)
+
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				helpers.WriteCheckpoint()
+			case <-done:
+				finished <- true
+				return
+			}
+		}
+	}()
+
+	return func(completed bool) {
+		done <- true
+		<-finished
+		if completed {
+			helpers.RemoveCheckpoint(alias)
+		} else {
+			helpers.WriteCheckpoint()
+			log.Warn("The run didn't complete; the next run resumes from the checkpoint")
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+
+	"github.com/ian-kent/go-log/log"
+	"golang.org/x/net/context"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// Copies the media index to a new index with the current mapping, changing documents with the
+// transforms, then points the media index alias at the new index. Searches continue against the
+// old index until then. Writes to the old index are blocked during the copy, so nothing written
+// meanwhile is lost - it fails instead, and media that failed is indexed by the next indexer run.
+// 'fieldTransforms' are 'field=<painless expression>', or 'field=' to remove the field
+func migrateMediaIndex(fieldTransforms []string, keepOldIndex bool) {
+	startTime := time.Now()
+	client := common.CreateClient()
+
+	info, err := common.GetMediaIndexInfo(client)
+	if err != nil {
+		log.Fatalf("Unable to get the schema version of '%s': %s", common.MediaIndexName, err.Error())
+	}
+
+	target := common.VersionedMediaIndexName(common.MediaSchemaVersion)
+	if info.IsAlias && info.Index == target {
+		log.Info("'%s' is already schema version %d (%s)", common.MediaIndexName, common.MediaSchemaVersion, target)
+		return
+	}
+	if info.Version > common.MediaSchemaVersion {
+		log.Fatalf("'%s' is schema version %d, newer than this indexer's %d", common.MediaIndexName, info.Version, common.MediaSchemaVersion)
+	}
+
+	// An index from before aliases has the alias's name, and is deleted to make way for the alias;
+	// it's copied to a backup first
+	backup := ""
+	if !info.IsAlias {
+		backup = common.VersionedMediaIndexName(info.Version)
+		if backup == target {
+			log.Fatalf("'%s' isn't an alias, but is already schema version %d", common.MediaIndexName, info.Version)
+		}
+	}
+
+	transforms := common.MediaFieldTransformsBetween(info.Version, common.MediaSchemaVersion)
+	for _, ft := range fieldTransforms {
+		pos := strings.Index(ft, "=")
+		if pos < 1 {
+			log.Fatalf("Field transforms are 'field=<painless expression>', not '%s'", ft)
+		}
+		transforms = append(transforms, common.FieldTransform{Field: ft[:pos], Script: ft[pos+1:]})
+	}
+	script := common.FieldTransformScript(transforms)
+
+	log.Warn("Migrating '%s' from %s (schema version %d) to %s (schema version %d)",
+		common.MediaIndexName, info.Index, info.Version, target, common.MediaSchemaVersion)
+	if script != "" {
+		log.Info("Transforming documents with: %s", script)
+	}
+	if common.IndexMakeNoChanges {
+		if backup != "" {
+			log.Info("WOULD copy %s to %s, as a backup", info.Index, backup)
+		}
+		log.Info("WOULD copy %s to %s and point '%s' at %s", info.Index, target, common.MediaIndexName, target)
+		return
+	}
+
+	err = setWritesBlocked(client, info.Index, true)
+	if err != nil {
+		log.Fatalf("Failed blocking writes to %s: %s", info.Index, err.Error())
+	}
+	log.Warn("Writes to %s are blocked until the migration finishes", info.Index)
+
+	err = copyMediaIndex(client, info, target, backup, script)
+	if err != nil {
+		if unblockErr := setWritesBlocked(client, info.Index, false); unblockErr != nil {
+			log.Error("Failed unblocking writes to %s: %s", info.Index, unblockErr.Error())
+		}
+		log.Fatalf("Failed migrating '%s': %s", common.MediaIndexName, err.Error())
+	}
+
+	// The index migrated from: the old versioned index, or the backup of the one from before aliases
+	old := info.Index
+	if backup != "" {
+		old = backup
+	}
+	if keepOldIndex {
+		if unblockErr := setWritesBlocked(client, old, false); unblockErr != nil {
+			log.Error("Failed unblocking writes to %s: %s", old, unblockErr.Error())
+		}
+		log.Info("The old index %s was kept", old)
+	} else {
+		_, err = client.DeleteIndex(old).Do(context.TODO())
+		if err != nil {
+			log.Error("Failed deleting the old index %s: %s", old, err.Error())
+		}
+	}
+
+	log.Info("[%01.3f seconds] '%s' is now %s, schema version %d",
+		time.Now().Sub(startTime).Seconds(), common.MediaIndexName, target, common.MediaSchemaVersion)
+}
+
+// Copies to the backup, if there is one, then to the target, then points the alias at the target
+func copyMediaIndex(client *elastic.Client, info *common.MediaIndexInfo, target string, backup string, script string) error {
+	if backup != "" {
+		err := recreateIndex(client, backup, func() error { return createIndexLike(client, info.Index, backup) })
+		if err != nil {
+			return err
+		}
+		err = reindexMedia(client, info.Index, backup, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	err := recreateIndex(client, target, func() error { return common.CreateVersionedMediaIndex(client, target) })
+	if err != nil {
+		return err
+	}
+	err = reindexMedia(client, info.Index, target, script)
+	if err != nil {
+		return err
+	}
+
+	err = swapMediaIndexAlias(client, info, target)
+	if err != nil {
+		if backup != "" {
+			return fmt.Errorf("pointing '%s' at %s failed, its documents are in %s: %s", common.MediaIndexName, target, backup, err.Error())
+		}
+		return fmt.Errorf("pointing '%s' at %s failed: %s", common.MediaIndexName, target, err.Error())
+	}
+	return nil
+}
+
+// An index left behind by an interrupted migration is started over
+func recreateIndex(client *elastic.Client, index string, create func() error) error {
+	exists, err := client.IndexExists(index).Do(context.TODO())
+	if err != nil {
+		return err
+	}
+	if exists {
+		log.Warn("Deleting '%s', left by an earlier migration", index)
+		_, err = client.DeleteIndex(index).Do(context.TODO())
+		if err != nil {
+			return fmt.Errorf("unable to delete %s: %s", index, err.Error())
+		}
+	}
+	return create()
+}
+
+// Creates an index with the mapping of another
+func createIndexLike(client *elastic.Client, source string, target string) error {
+	results, err := client.GetMapping().Index(source).Do(context.TODO())
+	if err != nil {
+		return err
+	}
+	index, ok := results[source].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("no mapping for %s", source)
+	}
+
+	body := map[string]interface{}{
+		"settings": map[string]interface{}{
+			"max_result_window":  100000,
+			"number_of_shards":   1,
+			"number_of_replicas": 0,
+		},
+		"mappings": index["mappings"],
+	}
+	_, err = client.CreateIndex(target).BodyJson(body).Do(context.TODO())
+	return err
+}
+
+func reindexMedia(client *elastic.Client, source string, target string, script string) error {
+	reindex := client.Reindex().
+		SourceIndex(source).
+		DestinationIndex(target).
+		WaitForCompletion(true).
+		Refresh("true")
+	if script != "" {
+		reindex = reindex.Script(elastic.NewScript(script))
+	}
+	response, err := reindex.Do(context.TODO())
+	if err != nil {
+		return fmt.Errorf("copying %s to %s failed: %s", source, target, err.Error())
+	}
+	if len(response.Failures) > 0 {
+		return fmt.Errorf("copying %d documents from %s to %s failed; the first failure: %v",
+			len(response.Failures), source, target, response.Failures[0])
+	}
+	log.Info("Copied %d documents to %s", response.Created, target)
+	return nil
+}
+
+// An alias is moved in a single request. An index from before aliases has the alias's name, so it's
+// deleted first - searches fail until the alias is added, a moment later
+func swapMediaIndexAlias(client *elastic.Client, info *common.MediaIndexInfo, target string) error {
+	if info.IsAlias {
+		_, err := client.Alias().
+			Remove(info.Index, common.MediaIndexName).
+			Add(target, common.MediaIndexName).
+			Do(context.TODO())
+		return err
+	}
+
+	log.Warn("Deleting %s, to replace it with an alias", info.Index)
+	_, err := client.DeleteIndex(info.Index).Do(context.TODO())
+	if err != nil {
+		return fmt.Errorf("unable to delete the old index: %s", err.Error())
+	}
+	_, err = client.Alias().Add(target, common.MediaIndexName).Do(context.TODO())
+	return err
+}
+
+func setWritesBlocked(client *elastic.Client, index string, blocked bool) error {
+	_, err := client.IndexPutSettings(index).
+		BodyJson(map[string]interface{}{"index.blocks.write": blocked}).
+		Do(context.TODO())
+	return err
+}
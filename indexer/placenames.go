@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/steps/resolveplacename"
+
+	"github.com/ian-kent/go-log/log"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+var placenamesChecked int64
+var placenamesResolved int64
+var placenamesUnresolved int64
+var placenameUpdatesFailed int64
+
+const placenameScrollSize = 100
+
+// Finds indexed media with a location and resolves the placename again, updating only the placename
+// fields (and the warnings). Without a query, media with no placename, a failed lookup warning or a
+// stale placename is selected; otherwise the query (query string syntax) selects the media
+func resolvePlacenames(queryString string) {
+	startTime := time.Now()
+	placenameUpdatesFailed = updateIndexedMedia("placename", placenameQuery(queryString), placenameScrollSize, resolveMediaPlacename)
+
+	log.Info("[%01.3f seconds] Checked %d placenames: %d resolved, %d unresolved, %d updates failed",
+		time.Now().Sub(startTime).Seconds(), placenamesChecked, placenamesResolved, placenamesUnresolved, placenameUpdatesFailed)
+	log.Info("%d locations lookup attempts, %d location lookup failures, %d server errors, %d other failures",
+		resolveplacename.PlacenameLookups, resolveplacename.FailedLookups, resolveplacename.ServerErrors, resolveplacename.Failures)
+	log.Info("%d placename cache hits, %d misses (within %01.0f meters)",
+		resolveplacename.CacheHits, resolveplacename.CacheMisses, resolveplacename.CacheRadiusMeters)
+	log.Info("%d location lookup retries, lookups paused %d times",
+		resolveplacename.LookupRetries, resolveplacename.BreakerOpened)
+}
+
+func placenameQuery(queryString string) elastic.Query {
+	query := elastic.NewBoolQuery().Must(elastic.NewExistsQuery("location"))
+	if queryString != "" {
+		return query.Must(elastic.NewQueryStringQuery(queryString))
+	}
+
+	// A placename is stale when it was reused from a cached lookup further away than is now allowed
+	needsResolving := elastic.NewBoolQuery().
+		Should(elastic.NewBoolQuery().MustNot(elastic.NewExistsQuery("countryname"))).
+		Should(elastic.NewRangeQuery("cachedlocationdistancemeters").Gt(resolveplacename.CacheRadiusMeters))
+	for _, code := range resolveplacename.PlacenameWarningCodes {
+		needsResolving.Should(elastic.NewTermQuery("warningentries.code", code))
+
+		// Media whose warnings haven't been migrated yet
+		for _, prefix := range common.LegacyWarningPrefixes(code) {
+			needsResolving.Should(elastic.NewPrefixQuery("warnings.value", prefix))
+		}
+	}
+	return query.Must(needsResolving.MinimumNumberShouldMatch(1))
+}
+
+// Returns the fields to update
+func resolveMediaPlacename(media *common.Media) map[string]interface{} {
+	atomic.AddInt64(&placenamesChecked, 1)
+
+	// A failed lookup adds its warning back. The legacy warnings are migrated along the way
+	media.MigrateLegacyWarnings()
+	warnings := make([]common.Warning, 0, len(media.Warnings))
+	for _, w := range media.Warnings {
+		if !resolveplacename.IsPlacenameWarning(w) {
+			warnings = append(warnings, w)
+		}
+	}
+	media.Warnings = warnings
+
+	if resolveplacename.Resolve(media) {
+		atomic.AddInt64(&placenamesResolved, 1)
+	} else {
+		atomic.AddInt64(&placenamesUnresolved, 1)
+	}
+
+	if common.IndexMakeNoChanges {
+		log.Info("WOULD update the placename of %s to '%s'", media.Path, media.LocationPlaceName)
+	}
+	return placenameFields(media)
+}
+
+// The placename fields as resolving left them - unchanged if the lookup failed. The warnings are
+// included as resolving adds (and clears) them
+func placenameFields(media *common.Media) map[string]interface{} {
+	return map[string]interface{}{
+		"countrycode":                  media.LocationCountryCode,
+		"countryname":                  media.LocationCountryName,
+		"statename":                    media.LocationStateName,
+		"cityname":                     media.LocationCityName,
+		"sitename":                     media.LocationSiteName,
+		"placename":                    media.LocationPlaceName,
+		"hierarchicalname":             media.LocationHierarchicalName,
+		"displayname":                  media.LocationDisplayName,
+		"cachedlocationdistancemeters": media.CachedLocationDistanceMeters,
+		"warningentries":               media.Warnings,
+		"warnings":                     nil,
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/helpers"
+	"github.com/kevintavog/findaphoto/indexer/steps/checkindex"
+	"github.com/kevintavog/findaphoto/indexer/steps/checkthumbnail"
+	"github.com/kevintavog/findaphoto/indexer/steps/generatethumbnail"
+	"github.com/kevintavog/findaphoto/indexer/steps/getexif"
+	"github.com/kevintavog/findaphoto/indexer/steps/indexmedia"
+	"github.com/kevintavog/findaphoto/indexer/steps/preparemedia"
+	"github.com/kevintavog/findaphoto/indexer/steps/probevideo"
+	"github.com/kevintavog/findaphoto/indexer/steps/resolveplacename"
+	"github.com/kevintavog/findaphoto/indexer/steps/scanner"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+const progressInterval = 2 * time.Second
+
+// Periodically write the progress to stdout, as a line of JSON, until the returned function is called.
+// The returned function writes one final, completed, progress line.
+func startProgressReporting(alias, scanPath string, startTime time.Time) func() {
+	done := make(chan bool)
+	finished := make(chan bool)
+
+	go func() {
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				emitProgress(alias, scanPath, startTime, false)
+			case <-done:
+				emitProgress(alias, scanPath, startTime, true)
+				finished <- true
+				return
+			}
+		}
+	}()
+
+	return func() {
+		done <- true
+		<-finished
+	}
+}
+
+func emitProgress(alias, scanPath string, startTime time.Time, completed bool) {
+	progress := currentProgress(alias, scanPath, startTime)
+	progress.Completed = completed
+
+	line, err := json.Marshal(progress)
+	if err != nil {
+		log.Error("Failed converting progress to JSON: %s", err.Error())
+		return
+	}
+	fmt.Println(string(line))
+}
+
+func currentProgress(alias, scanPath string, startTime time.Time) *common.IndexProgress {
+	elapsed := time.Now().Sub(startTime).Seconds()
+	progress := &common.IndexProgress{
+		Type:           common.IndexProgressType,
+		Alias:          alias,
+		Path:           scanPath,
+		ScanCompleted:  scanner.ScanCompleted(),
+		ElapsedSeconds: elapsed,
+
+		DirectoriesScanned:  atomic.LoadInt64(&scanner.DirectoriesScanned),
+		FilesScanned:        atomic.LoadInt64(&scanner.FilesScanned),
+		SupportedFilesFound: atomic.LoadInt64(&scanner.SupportedFilesFound),
+		FilesChecked:        atomic.LoadInt64(&checkindex.ChecksMade),
+		FilesIndexed:        atomic.LoadInt64(&indexmedia.IndexedFiles),
+		DuplicatesIgnored:   atomic.LoadInt64(&helpers.DuplicatesIgnored),
+		MediaRemoved:        atomic.LoadInt64(&scanner.MediaRemoved),
+
+		Failures: map[string]int64{
+			"signature":  atomic.LoadInt64(&checkindex.SignatureGenerationFailed),
+			"check":      atomic.LoadInt64(&checkindex.CheckFailed),
+			"exiftool":   atomic.LoadInt64(&getexif.ExifToolFailed),
+			"ffprobe":    atomic.LoadInt64(&probevideo.FfprobeFailed),
+			"placename":  atomic.LoadInt64(&resolveplacename.FailedLookups) + atomic.LoadInt64(&resolveplacename.Failures),
+			"thumbnail":  atomic.LoadInt64(&generatethumbnail.FailedImage) + atomic.LoadInt64(&generatethumbnail.FailedVideo),
+			"index":      atomic.LoadInt64(&indexmedia.FailedIndexAttempts),
+			"duplicates": atomic.LoadInt64(&helpers.DuplicateCheckFailed),
+		},
+
+		QueueDepths: map[string]int{
+			"checkindex":        checkindex.QueueLength(),
+			"getexif":           getexif.QueueLength(),
+			"preparemedia":      preparemedia.QueueLength(),
+			"probevideo":        probevideo.QueueLength(),
+			"resolveplacename":  resolveplacename.QueueLength(),
+			"deferredplacename": resolveplacename.DeferredCount(),
+			"indexmedia":        indexmedia.QueueLength(),
+			"checkthumbnail":    checkthumbnail.QueueLength(),
+			"generatethumbnail": generatethumbnail.QueueLength(),
+		},
+	}
+
+	// The estimate is only meaningful once the number of files to check is known
+	if progress.ScanCompleted && progress.FilesChecked > 0 && elapsed > 0 {
+		remaining := progress.SupportedFilesFound - progress.FilesChecked - progress.Failures["signature"]
+		if remaining > 0 {
+			progress.EtaSeconds = float64(remaining) / (float64(progress.FilesChecked) / elapsed)
+		}
+	}
+
+	return progress
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/helpers"
+	"github.com/kevintavog/findaphoto/indexer/steps/checkindex"
+	"github.com/kevintavog/findaphoto/indexer/steps/checkthumbnail"
+	"github.com/kevintavog/findaphoto/indexer/steps/generatethumbnail"
+	"github.com/kevintavog/findaphoto/indexer/steps/getexif"
+	"github.com/kevintavog/findaphoto/indexer/steps/indexmedia"
+	"github.com/kevintavog/findaphoto/indexer/steps/preparemedia"
+	"github.com/kevintavog/findaphoto/indexer/steps/probevideo"
+	"github.com/kevintavog/findaphoto/indexer/steps/resolveplacename"
+	"github.com/kevintavog/findaphoto/indexer/steps/scanner"
+
+	"github.com/ian-kent/go-log/log"
+	"golang.org/x/net/context"
+)
+
+var loggedErrors *common.ErrorCollector
+
+// Tracks a single run, stored in the run history index when it starts and again when it finishes
+type indexRun struct {
+	id        string
+	alias     string
+	path      string
+	aliases   []string
+	paths     []string
+	watch     bool
+	startTime time.Time
+	counters  map[string]map[string]int64
+}
+
+func startRun(alias, path string) *indexRun {
+	run := newRun()
+	run.alias = alias
+	run.path = path
+	run.store(true)
+	return run
+}
+
+// A watch run covers every root being watched
+func startWatchRun() *indexRun {
+	run := newRun()
+	run.watch = true
+	common.VisitAllPaths(func(alias common.AliasDocument) {
+		run.aliases = append(run.aliases, alias.Alias)
+		run.paths = append(run.paths, alias.Path)
+	})
+	run.store(true)
+	return run
+}
+
+// Counters only increase during the life of the process; the counters at the start of the run are
+// subtracted from those at the end, so that each run has its own values.
+func newRun() *indexRun {
+	if loggedErrors == nil {
+		loggedErrors = common.CollectLoggedErrors(common.MaxIndexRunErrors)
+	} else {
+		loggedErrors.Reset()
+	}
+
+	return &indexRun{
+		startTime: time.Now(),
+		counters:  collectCounters(),
+	}
+}
+
+// Called once the pipeline has drained, so the counters include every file of the run
+func (ir *indexRun) finish() {
+	ir.store(false)
+}
+
+// While the run is active, the server won't remove the paths it covers
+func (ir *indexRun) store(active bool) {
+	now := time.Now()
+	counters := collectCounters()
+	for step, values := range counters {
+		for name, value := range values {
+			values[name] = value - ir.counters[step][name]
+		}
+	}
+
+	run := &common.IndexRun{
+		Alias:           ir.alias,
+		Path:            ir.path,
+		Aliases:         ir.aliases,
+		Paths:           ir.paths,
+		Watch:           ir.watch,
+		Active:          active,
+		StartTime:       ir.startTime,
+		DurationSeconds: now.Sub(ir.startTime).Seconds(),
+		Counters:        counters,
+	}
+	if !active {
+		run.EndTime = now
+		run.Errors, run.ErrorCount = loggedErrors.Reset()
+	}
+
+	if common.IndexMakeNoChanges {
+		if !active {
+			log.Info("WOULD store run history for '%s'", ir.describe())
+		}
+		return
+	}
+
+	client := common.CreateClient()
+	exists, err := client.IndexExists(common.IndexRunIndexName).Do(context.TODO())
+	if err != nil || !exists {
+		log.Warn("Not storing run history, the index '%s' isn't available", common.IndexRunIndexName)
+		return
+	}
+
+	id, err := common.StoreIndexRun(client, ir.id, run)
+	if err != nil {
+		log.Warn("Failed storing run history: %s", err.Error())
+		return
+	}
+	ir.id = id
+}
+
+func (ir *indexRun) describe() string {
+	if ir.watch {
+		return strings.Join(ir.paths, "', '")
+	}
+	return ir.path
+}
+
+func collectCounters() map[string]map[string]int64 {
+	return map[string]map[string]int64{
+		"scanner": {
+			"directoriesscanned":    atomic.LoadInt64(&scanner.DirectoriesScanned),
+			"filesscanned":          atomic.LoadInt64(&scanner.FilesScanned),
+			"supportedfilesfound":   atomic.LoadInt64(&scanner.SupportedFilesFound),
+			"mediascanned":          atomic.LoadInt64(&scanner.MediaScanned),
+			"mediaremoved":          atomic.LoadInt64(&scanner.MediaRemoved),
+			"filesignored":          atomic.LoadInt64(&scanner.FilesIgnored),
+			"directoriesignored":    atomic.LoadInt64(&scanner.DirectoriesIgnored),
+			"ignoredmediaremoved":   atomic.LoadInt64(&scanner.IgnoredMediaRemoved),
+			"filesresumed":          atomic.LoadInt64(&scanner.FilesResumed),
+			"watchevents":           atomic.LoadInt64(&scanner.WatchEvents),
+			"watchfilesqueued":      atomic.LoadInt64(&scanner.WatchFilesQueued),
+			"watchdirectoriesadded": atomic.LoadInt64(&scanner.WatchDirectoriesAdded),
+		},
+		"checkindex": {
+			"checksmade":                atomic.LoadInt64(&checkindex.ChecksMade),
+			"checkfailed":               atomic.LoadInt64(&checkindex.CheckFailed),
+			"badjson":                   atomic.LoadInt64(&checkindex.BadJson),
+			"signaturegenerationfailed": atomic.LoadInt64(&checkindex.SignatureGenerationFailed),
+		},
+		"duplicates": {
+			"duplicatesignored":        atomic.LoadInt64(&helpers.DuplicatesIgnored),
+			"duplicatecheckfailed":     atomic.LoadInt64(&helpers.DuplicateCheckFailed),
+			"falseduplicatesavoided":   atomic.LoadInt64(&helpers.FalseDuplicatesAvoided),
+			"fullsignaturesgenerated":  atomic.LoadInt64(&helpers.FullSignaturesGenerated),
+			"fullsignaturesfailed":     atomic.LoadInt64(&helpers.FullSignaturesFailed),
+			"fullsignaturesbackfilled": atomic.LoadInt64(&helpers.FullSignaturesBackfilled),
+		},
+		"getexif": {
+			"exiftoolinvocations": atomic.LoadInt64(&getexif.ExifToolInvocations),
+			"exiftoolfailed":      atomic.LoadInt64(&getexif.ExifToolFailed),
+		},
+		"preparemedia": {
+			"geotagmatched":     atomic.LoadInt64(&preparemedia.GeotagMatched),
+			"geotagwritten":     atomic.LoadInt64(&preparemedia.GeotagWritten),
+			"geotagwritefailed": atomic.LoadInt64(&preparemedia.GeotagWriteFailed),
+		},
+		"probevideo": {
+			"ffprobeinvocations": atomic.LoadInt64(&probevideo.FfprobeInvocations),
+			"ffprobefailed":      atomic.LoadInt64(&probevideo.FfprobeFailed),
+		},
+		"resolveplacename": {
+			"placenamelookups": atomic.LoadInt64(&resolveplacename.PlacenameLookups),
+			"failedlookups":    atomic.LoadInt64(&resolveplacename.FailedLookups),
+			"servererrors":     atomic.LoadInt64(&resolveplacename.ServerErrors),
+			"failures":         atomic.LoadInt64(&resolveplacename.Failures),
+			"cachehits":        atomic.LoadInt64(&resolveplacename.CacheHits),
+			"cachemisses":      atomic.LoadInt64(&resolveplacename.CacheMisses),
+			"lookupretries":    atomic.LoadInt64(&resolveplacename.LookupRetries),
+			"breakeropened":    atomic.LoadInt64(&resolveplacename.BreakerOpened),
+			"lookupsdeferred":  atomic.LoadInt64(&resolveplacename.LookupsDeferred),
+			"deferredresolved": atomic.LoadInt64(&resolveplacename.DeferredResolved),
+		},
+		"generatethumbnail": {
+			"generatedimage":            atomic.LoadInt64(&generatethumbnail.GeneratedImage),
+			"failedimage":               atomic.LoadInt64(&generatethumbnail.FailedImage),
+			"generatedvideo":            atomic.LoadInt64(&generatethumbnail.GeneratedVideo),
+			"failedvideo":               atomic.LoadInt64(&generatethumbnail.FailedVideo),
+			"thumbnailscreated":         atomic.LoadInt64(&generatethumbnail.ThumbnailsCreated),
+			"failedchecks":              atomic.LoadInt64(&checkthumbnail.FailedChecks),
+			"perceptualhashesgenerated": atomic.LoadInt64(&helpers.PerceptualHashesGenerated),
+			"perceptualhashesfailed":    atomic.LoadInt64(&helpers.PerceptualHashesFailed),
+		},
+		"indexmedia": {
+			"indexedfiles":        atomic.LoadInt64(&indexmedia.IndexedFiles),
+			"changedfiles":        atomic.LoadInt64(&indexmedia.ChangedFiles),
+			"failedindexattempts": atomic.LoadInt64(&indexmedia.FailedIndexAttempts),
+		},
+	}
+}
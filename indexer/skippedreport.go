@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/steps/scanner"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+const skippedReportFilename = "findaphotoindexer-skipped.json"
+
+// Write what the ignore rules skipped during the run, replacing the previous report
+func writeSkippedReport(alias, scanPath string) {
+	report := map[string]interface{}{
+		"alias":              alias,
+		"path":               scanPath,
+		"filesIgnored":       scanner.FilesIgnored,
+		"directoriesIgnored": scanner.DirectoriesIgnored,
+		"mediaRemoved":       scanner.IgnoredMediaRemoved,
+		"skipped":            scanner.SkippedReport(),
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Error("Failed converting the skipped report to JSON: %s", err.Error())
+		return
+	}
+
+	filename := path.Join(common.LogDirectory, skippedReportFilename)
+	err = ioutil.WriteFile(filename, data, 0644)
+	if err != nil {
+		log.Warn("Failed writing the skipped report to '%s': %s", filename, err.Error())
+		return
+	}
+	log.Info("Wrote the report of skipped files to %s", filename)
+}
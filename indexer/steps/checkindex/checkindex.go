@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"io"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -16,7 +17,6 @@ import (
 	"github.com/kevintavog/findaphoto/indexer/steps/getexif"
 
 	"github.com/ian-kent/go-log/log"
-	"golang.org/x/net/context"
 	"gopkg.in/olivere/elastic.v5"
 )
 
@@ -26,11 +26,13 @@ var SignatureGenerationFailed int64
 var ChecksMade int64
 
 var ForceIndex bool
+var BackfillFullSignatures bool
 
 const numConsumers = 4
 const numBytesForSignature = 20 * 1024
+const maxBatchSize = 50
 
-var queue = make(chan *common.CandidateFile, numConsumers)
+var queue = make(chan *common.CandidateFile, numConsumers*maxBatchSize)
 var waitGroup sync.WaitGroup
 
 func Start() {
@@ -58,11 +60,27 @@ func Wait() {
 	checkthumbnail.Wait()
 }
 
-func Enqueue(fullFilename, aliasedFilename string, lengthInBytes int64) {
+func QueueLength() int {
+	return len(queue)
+}
+
+// 'sidecarPath' is the XMP sidecar paired with the file; empty if there isn't one
+func Enqueue(fullFilename, aliasedFilename string, lengthInBytes int64, sidecarPath string) {
+	enqueue(fullFilename, aliasedFilename, lengthInBytes, sidecarPath, false)
+}
+
+// For a file changed while watching
+func EnqueueWatched(fullFilename, aliasedFilename string, lengthInBytes int64, sidecarPath string) {
+	enqueue(fullFilename, aliasedFilename, lengthInBytes, sidecarPath, true)
+}
+
+func enqueue(fullFilename, aliasedFilename string, lengthInBytes int64, sidecarPath string, watched bool) {
 	candidateFile := &common.CandidateFile{
 		FullPath:      fullFilename,
 		AliasedPath:   aliasedFilename,
 		LengthInBytes: lengthInBytes,
+		SidecarPath:   sidecarPath,
+		Watched:       watched,
 	}
 	queue <- candidateFile
 }
@@ -70,7 +88,37 @@ func Enqueue(fullFilename, aliasedFilename string, lengthInBytes int64) {
 func dequeue() {
 	client := common.CreateClient()
 
-	for candidateFile := range queue {
+	for batch := nextBatch(); len(batch) > 0; batch = nextBatch() {
+		checkBatch(client, batch)
+	}
+}
+
+// Wait for a file, then take whatever else is already queued (up to 'maxBatchSize'), so the index
+// lookups for all of them are made at once.
+func nextBatch() []*common.CandidateFile {
+	candidateFile, ok := <-queue
+	if !ok {
+		return nil
+	}
+
+	batch := []*common.CandidateFile{candidateFile}
+	for len(batch) < maxBatchSize {
+		select {
+		case candidateFile, ok := <-queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, candidateFile)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func checkBatch(client *elastic.Client, batch []*common.CandidateFile) {
+	candidates := make([]*common.CandidateFile, 0, len(batch))
+	for _, candidateFile := range batch {
 
 		// We need the signature & length for further validation, below - and another step needs it
 		// if the file is added to the index. Essentially, we need it most of the time - calculate it now
@@ -81,49 +129,119 @@ func dequeue() {
 		}
 		candidateFile.Signature = signature
 
+		if candidateFile.SidecarPath != "" {
+			candidateFile.SidecarSignature, err = generateSidecarSignature(candidateFile.SidecarPath)
+			if err != nil {
+				atomic.AddInt64(&SignatureGenerationFailed, 1)
+				continue
+			}
+		}
+
 		atomic.AddInt64(&ChecksMade, 1)
 		if ChecksMade%1000 == 0 {
 			log.Info("Checking [%d] for %s", ChecksMade, candidateFile.AliasedPath)
 		}
+		candidates = append(candidates, candidateFile)
+	}
 
-		if helpers.IsDuplicate(client, candidateFile.Signature, candidateFile.AliasedPath, false) {
-			continue
+	if len(candidates) < 1 {
+		return
+	}
+
+	aliasedPaths := make([]string, len(candidates))
+	signatures := make([]string, len(candidates))
+	for idx, candidateFile := range candidates {
+		aliasedPaths[idx] = candidateFile.AliasedPath
+		signatures[idx] = candidateFile.Signature
+	}
+
+	states, err := helpers.LookupIndexedState(client, aliasedPaths, signatures)
+	if err != nil {
+		atomic.AddInt64(&CheckFailed, int64(len(candidates)))
+		log.Error("Error checking document existence for %d files (starting with '%s'): %s",
+			len(candidates), candidates[0].AliasedPath, err.Error())
+		return
+	}
+
+	for idx, candidateFile := range candidates {
+		checkFile(client, candidateFile, states[idx])
+	}
+}
+
+func checkFile(client *elastic.Client, candidateFile *common.CandidateFile, state *helpers.IndexedState) {
+	if helpers.IsDuplicate(client, state, candidateFile.Signature, &candidateFile.FullSignature, candidateFile.AliasedPath, false) {
+		helpers.CheckpointFileDone(candidateFile.AliasedPath)
+		return
+	}
+
+	if state.Err != nil {
+		atomic.AddInt64(&CheckFailed, 1)
+		log.Error("Error checking document existence for '%s': %s", candidateFile.AliasedPath, state.Err.Error())
+		return
+	}
+
+	if ForceIndex || !state.Document.Found {
+		enqueueForIndexing(candidateFile)
+		return
+	}
+
+	var media common.Media
+	err := json.Unmarshal(*state.Document.Source, &media)
+	if err != nil {
+		log.Error("Failed deserializing search result: %s", err.Error())
+		atomic.AddInt64(&BadJson, 1)
+		return
+	}
+
+	if media.Signature != candidateFile.Signature || media.LengthInBytes != candidateFile.LengthInBytes {
+		enqueueForIndexing(candidateFile)
+
+		// Because it's an update, ask to generate the thumbnail rather than check if it exists
+		generatethumbnail.Enqueue(candidateFile.FullPath, candidateFile.AliasedPath, media.MimeType)
+	} else if media.SidecarSignature != candidateFile.SidecarSignature {
+		// Only the sidecar changed (or was added or removed) - the thumbnail is still good
+		enqueueForIndexing(candidateFile)
+	} else {
+		if BackfillFullSignatures && media.FullSignature == "" {
+			helpers.BackfillFullSignature(client, &media)
+		}
+		if media.PerceptualHash == "" {
+			helpers.UpdatePerceptualHash(client, media.Path)
 		}
+		checkthumbnail.Enqueue(candidateFile.FullPath, candidateFile.AliasedPath, media.MimeType)
+		classifymedia.Enqueue(candidateFile.FullPath, candidateFile.AliasedPath, media.Tags)
+		helpers.CheckpointFileDone(candidateFile.AliasedPath)
+	}
+}
 
-		pathSearchResult, err := client.Search().
-			Index(common.MediaIndexName).
-			Type(common.MediaTypeName).
-			Query(elastic.NewTermQuery("_id", candidateFile.AliasedPath)).
-			Pretty(true).
-			Do(context.TODO())
+// Files being added or updated need the full signature, for duplicate detection
+func enqueueForIndexing(candidateFile *common.CandidateFile) {
+	if candidateFile.FullSignature == "" {
+		fullSignature, err := helpers.GenerateFullSignature(candidateFile.FullPath)
 		if err != nil {
-			atomic.AddInt64(&CheckFailed, 1)
-			log.Error("Error checking document existence for '%s': %s", candidateFile.AliasedPath, err.Error())
-			continue
+			log.Error("Failed generating full signature for '%s': %s", candidateFile.FullPath, err.Error())
 		}
+		candidateFile.FullSignature = fullSignature
+	}
+	getexif.Enqueue(candidateFile)
+}
 
-		if ForceIndex || pathSearchResult.TotalHits() != 1 {
-			getexif.Enqueue(candidateFile)
-		} else {
-			hit := pathSearchResult.Hits.Hits[0]
-			var media common.Media
-			err := json.Unmarshal(*hit.Source, &media)
-			if err != nil {
-				log.Error("Failed deserializing search result: %s", err.Error())
-				atomic.AddInt64(&BadJson, 1)
-			} else {
-				if media.Signature != candidateFile.Signature || media.LengthInBytes != candidateFile.LengthInBytes {
-					getexif.Enqueue(candidateFile)
-
-					// Because it's an update, ask to generate the thumbnail rather than check if it exists
-					generatethumbnail.Enqueue(candidateFile.FullPath, candidateFile.AliasedPath, media.MimeType)
-				} else {
-					checkthumbnail.Enqueue(candidateFile.FullPath, candidateFile.AliasedPath, media.MimeType)
-					classifymedia.Enqueue(candidateFile.FullPath, candidateFile.AliasedPath, media.Tags)
-				}
-			}
-		}
+// Sidecars are small and edits can be anywhere in them, so the whole file is used
+func generateSidecarSignature(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Error("Failed opening '%s': %s", filename, err.Error())
+		return "", err
 	}
+	defer file.Close()
+
+	sha := sha256.New()
+	_, err = io.Copy(sha, file)
+	if err != nil {
+		log.Error("Failed reading '%s': %s", filename, err.Error())
+		return "", err
+	}
+	return hex.EncodeToString(sha.Sum(nil)), nil
 }
 
 func generateSignature(filename string) (string, error) {
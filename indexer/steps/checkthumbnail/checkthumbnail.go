@@ -44,6 +44,10 @@ func Wait() {
 	generatethumbnail.Wait()
 }
 
+func QueueLength() int {
+	return len(queue)
+}
+
 func Enqueue(fullPath, aliasedPath, mimeType string) {
 	thumbnailInfo := &generatethumbnail.ThumbnailInfo{
 		FullPath:    fullPath,
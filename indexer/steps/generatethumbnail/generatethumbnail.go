@@ -7,11 +7,11 @@ import (
 	"os/exec"
 	"path"
 	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
 
 	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/helpers"
 
 	"github.com/ian-kent/go-log/log"
 	"github.com/nfnt/resize"
@@ -62,6 +62,10 @@ func Wait() {
 	waitGroup.Wait()
 }
 
+func QueueLength() int {
+	return len(queue)
+}
+
 func Enqueue(fullPath, aliasedPath, mimeType string) {
 	thumbnailInfo := &ThumbnailInfo{
 		FullPath:    fullPath,
@@ -72,15 +76,16 @@ func Enqueue(fullPath, aliasedPath, mimeType string) {
 }
 
 func dequeue() {
-	var mediaType []string
 	var thumbPath string
 	var err error
+	client := common.CreateClient()
 
 	for thumbnailInfo := range queue {
-		mediaType = strings.Split(thumbnailInfo.MimeType, "/")
 		thumbPath = common.ToThumbPath(thumbnailInfo.AliasedPath)
-		if len(mediaType) < 1 {
-			log.Error("Invalid media type: '%s' for %s", thumbnailInfo.MimeType, thumbnailInfo.FullPath)
+
+		if common.IndexMakeNoChanges {
+			log.Info("WOULD generate thumbnail for %v", thumbnailInfo.AliasedPath)
+			helpers.DryRunThumbnail(thumbnailInfo.AliasedPath)
 			continue
 		}
 
@@ -90,15 +95,17 @@ func dequeue() {
 			continue
 		}
 
-		switch strings.ToLower(mediaType[0]) {
-		case "video":
+		switch mediaType := common.MediaTypeOf(thumbnailInfo.MimeType, thumbnailInfo.FullPath); mediaType {
+		case common.MediaTypeVideo:
 			generateVideo(thumbnailInfo.FullPath, thumbPath)
-		case "image":
+		case common.MediaTypeImage:
 			generateImage(thumbnailInfo.FullPath, thumbPath)
 		default:
 			log.Error("Unhandled mediaType: %s (%s) for %s", thumbnailInfo.MimeType, mediaType, thumbnailInfo.FullPath)
 		}
 
+		helpers.UpdatePerceptualHash(client, thumbnailInfo.AliasedPath)
+
 		atomic.AddInt64(&ThumbnailsCreated, 1)
 		if ThumbnailsCreated%500 == 0 {
 			log.Info("Generated thumbnail %d [%s]", ThumbnailsCreated, thumbnailInfo.FullPath)
@@ -115,6 +122,10 @@ func generateImage(fullPath, thumbPath string) {
 		err = createNfntThumbnail(fullPath, thumbPath)
 	}
 
+	if err != nil && common.HasEmbeddedPreview(fullPath) {
+		err = generateFromEmbeddedPreview(fullPath, thumbPath)
+	}
+
 	if err != nil {
 		log.Error("Failed thumbnail generation on %s: %s", fullPath, err.Error())
 		atomic.AddInt64(&FailedImage, 1)
@@ -123,6 +134,27 @@ func generateImage(fullPath, thumbPath string) {
 	}
 }
 
+// Raw & HEIC files usually can't be decoded directly, but contain a JPEG preview that can be
+func generateFromEmbeddedPreview(fullPath, thumbPath string) error {
+	tmpFilename := path.Join(os.TempDir(), "findAPhoto", "previews", uuid.NewV4().String()+".JPG")
+	defer os.Remove(tmpFilename)
+
+	err := common.CreateDirectory(path.Dir(tmpFilename))
+	if err != nil {
+		return err
+	}
+
+	err = common.ExtractEmbeddedPreview(fullPath, tmpFilename)
+	if err != nil {
+		return err
+	}
+
+	if VipsExists {
+		return createVipsThumbnails(tmpFilename, thumbPath)
+	}
+	return createNfntThumbnail(tmpFilename, thumbPath)
+}
+
 func generateVideo(fullPath, thumbPath string) {
 	tmpFilename := path.Join(os.TempDir(), "findAPhoto", "thumbnails", uuid.NewV4().String()+".JPG")
 	defer os.Remove(tmpFilename)
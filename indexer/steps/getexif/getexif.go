@@ -59,7 +59,18 @@ func Wait() {
 	preparemedia.Wait()
 }
 
+func QueueLength() int {
+	return len(queue)
+}
+
 func Enqueue(candidate *common.CandidateFile) {
+	// The directory may have been read long before the file changed, and reading all of it again
+	// for one file is wasteful
+	if candidate.Watched {
+		exifFromFile(candidate)
+		return
+	}
+
 	directory := path.Dir(candidate.FullPath)
 
 	exifForDirectory, inQueue := getExifForDirectory(directory)
@@ -72,10 +83,19 @@ func Enqueue(candidate *common.CandidateFile) {
 	if !exifForDirectory.dequeued {
 		exifForDirectory.Files.PushBack(candidate)
 	} else {
+		// The directory was read since this was looked up, get the exif for just this file.
 		ex, err := exifForFile(exifForDirectory, path.Base(candidate.FullPath))
 		if err != nil {
+			ex, err = getFileExif(candidate.FullPath)
+		}
+		if err == nil {
 			candidate.Exif = *ex
+		} else {
+			candidate.AddWarning(common.WarningExifUnavailable, err.Error())
 		}
+
+		// The sidecar may have been edited since the directory was read, get it fresh
+		mergeSidecar(nil, candidate)
 		preparemedia.Enqueue(candidate)
 	}
 
@@ -84,6 +104,18 @@ func Enqueue(candidate *common.CandidateFile) {
 	}
 }
 
+func exifFromFile(candidate *common.CandidateFile) {
+	ex, err := getFileExif(candidate.FullPath)
+	if err == nil {
+		candidate.Exif = *ex
+	} else {
+		candidate.AddWarning(common.WarningExifUnavailable, err.Error())
+	}
+
+	mergeSidecar(nil, candidate)
+	preparemedia.Enqueue(candidate)
+}
+
 func getExifForDirectory(directory string) (*ExifForDirectory, bool) {
 
 	// Lock only to check if this has been queued & added to allDirectories
@@ -113,13 +145,13 @@ func dequeue() {
 		exifOutput, err := getDirectoryExif(exifForDirectory.Directory)
 		if err != nil {
 			atomic.AddInt64(&ExifToolFailed, 1)
+			forgetDirectory(exifForDirectory)
 			continue
 		}
 
 		exifForDirectory.Exif = exifOutput
 
 		exifForDirectory.lock.Lock()
-		defer exifForDirectory.lock.Unlock()
 
 		exifForDirectory.dequeued = true
 
@@ -129,10 +161,27 @@ func dequeue() {
 			if err == nil {
 				candidate.Exif = *ex
 			} else {
-				candidate.AddWarning(err.Error())
+				candidate.AddWarning(common.WarningExifUnavailable, err.Error())
 			}
+			mergeSidecar(exifForDirectory, candidate)
 			preparemedia.Enqueue(candidate)
 		}
+		exifForDirectory.Files.Init()
+
+		exifForDirectory.lock.Unlock()
+
+		forgetDirectory(exifForDirectory)
+	}
+}
+
+// Files from a later scan of the directory get a fresh read of it. Any that looked up this one
+// before it was forgotten are handled by Enqueue, as it's been dequeued
+func forgetDirectory(exifForDirectory *ExifForDirectory) {
+	allLock.Lock()
+	defer allLock.Unlock()
+
+	if allDirectories[exifForDirectory.Directory] == exifForDirectory {
+		delete(allDirectories, exifForDirectory.Directory)
 	}
 }
 
@@ -152,6 +201,41 @@ func exifForFile(exifForDirectory *ExifForDirectory, filename string) (*common.E
 	return nil, errors.New(fmt.Sprintf("No exif for %s", filename))
 }
 
+// Sidecar metadata is merged over the media's own; the directory exif is used if it has the
+// sidecar, otherwise exiftool is run on the sidecar itself
+func mergeSidecar(exifForDirectory *ExifForDirectory, candidate *common.CandidateFile) {
+	if candidate.SidecarPath == "" {
+		return
+	}
+
+	sidecar := sidecarExif(exifForDirectory, path.Base(candidate.SidecarPath))
+	if sidecar == nil {
+		var err error
+		sidecar, err = getFileExif(candidate.SidecarPath)
+		if err != nil {
+			candidate.AddWarning(common.WarningSidecarUnreadable,
+				fmt.Sprintf("Unable to read sidecar '%s': %s", candidate.SidecarPath, err.Error()), "sidecar", candidate.SidecarPath)
+			return
+		}
+	}
+
+	candidate.Exif.MergeSidecar(sidecar)
+}
+
+// Unlike exifForFile, the entry isn't removed - a sidecar can be shared by several media files
+func sidecarExif(exifForDirectory *ExifForDirectory, filename string) *common.ExifOutput {
+	if exifForDirectory == nil || !exifForDirectory.dequeued {
+		return nil
+	}
+
+	for _, ex := range exifForDirectory.Exif {
+		if filename == path.Base(ex.SourceFile) {
+			return ex
+		}
+	}
+	return nil
+}
+
 func getDirectoryExif(directory string) ([]*common.ExifOutput, error) {
 	out, err := exec.Command(common.ExifToolPath, "-a", "-j", "-g", "-x", "Directory", "-x", "FileAccessDate", "-x", "FileInodeChangeDate", directory).Output()
 	if err != nil {
@@ -168,3 +252,24 @@ func getDirectoryExif(directory string) ([]*common.ExifOutput, error) {
 
 	return response, nil
 }
+
+func getFileExif(filename string) (*common.ExifOutput, error) {
+	atomic.AddInt64(&ExifToolInvocations, 1)
+	out, err := exec.Command(common.ExifToolPath, "-a", "-j", "-g", "-x", "Directory", "-x", "FileAccessDate", "-x", "FileInodeChangeDate", filename).Output()
+	if err != nil {
+		atomic.AddInt64(&ExifToolFailed, 1)
+		return nil, fmt.Errorf("Failed executing exiftool for '%s': %s", filename, err.Error())
+	}
+
+	var response []*common.ExifOutput
+	err = json.Unmarshal(out, &response)
+	if err != nil {
+		atomic.AddInt64(&ExifToolFailed, 1)
+		return nil, err
+	}
+	if len(response) < 1 {
+		return nil, errors.New(fmt.Sprintf("No exif for %s", filename))
+	}
+
+	return response[0], nil
+}
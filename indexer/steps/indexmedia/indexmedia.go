@@ -1,8 +1,11 @@
 package indexmedia
 
 import (
+	"encoding/json"
+	"net/http"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/kevintavog/findaphoto/common"
 	"github.com/kevintavog/findaphoto/indexer/helpers"
@@ -17,12 +20,33 @@ var IndexedFiles int64
 var ChangedFiles int64 // Already in the repository, a file change was detected
 var FailedIndexAttempts int64
 
+// Documents are written in batches, via the bulk API; a batch is sent when it reaches 'BatchSize'
+// documents or 'FlushInterval' has passed.
+var BatchSize = 500
+var FlushInterval = 5 * time.Second
+
 const numConsumers = 8
+const maxBatchSize = 50
 
-var queue = make(chan *common.Media, numConsumers)
+var queue = make(chan *common.Media, numConsumers*maxBatchSize)
 var waitGroup sync.WaitGroup
+var bulkProcessor *elastic.BulkProcessor
 
 func Start() {
+	if !common.IndexMakeNoChanges {
+		processor, err := common.CreateClient().BulkProcessor().
+			Name("indexmedia").
+			Workers(2).
+			BulkActions(BatchSize).
+			FlushInterval(FlushInterval).
+			After(afterBulk).
+			Do(context.TODO())
+		if err != nil {
+			log.Fatalf("Unable to start the bulk processor: %s", err.Error())
+		}
+		bulkProcessor = processor
+	}
+
 	waitGroup.Add(numConsumers)
 	for idx := 0; idx < numConsumers; idx++ {
 		go func() {
@@ -36,8 +60,19 @@ func Done() {
 	close(queue)
 }
 
+// Waits for the queue to drain and for all pending documents to be written
 func Wait() {
 	waitGroup.Wait()
+	if bulkProcessor != nil {
+		err := bulkProcessor.Close()
+		if err != nil {
+			log.Error("Failed flushing the bulk processor: %s", err.Error())
+		}
+	}
+}
+
+func QueueLength() int {
+	return len(queue)
 }
 
 func Enqueue(media *common.Media) {
@@ -46,52 +81,118 @@ func Enqueue(media *common.Media) {
 
 func dequeue() {
 	var client = common.CreateClient()
-	for media := range queue {
+	for batch := nextBatch(); len(batch) > 0; batch = nextBatch() {
+		aliasedPaths := make([]string, len(batch))
+		signatures := make([]string, len(batch))
+		for idx, media := range batch {
+			aliasedPaths[idx] = media.Path
+			signatures[idx] = media.Signature
+		}
 
-		// Check to see if a duplicate item has been added (it might pass the same check in the
-		// scan and fail here - due to the queue between that check and storing the file.
-		if helpers.IsDuplicate(client, media.Signature, media.Path, true) {
-			continue
+		states, err := helpers.LookupIndexedState(client, aliasedPaths, signatures)
+		if err != nil {
+			log.Error("Error checking for duplicates for %d files (starting with '%s'): %s",
+				len(batch), batch[0].Path, err.Error())
+			states = make([]*helpers.IndexedState, len(batch))
+			for idx := range states {
+				states[idx] = &helpers.IndexedState{Err: err}
+			}
 		}
 
-		if common.IndexMakeNoChanges {
-			log.Info("WOULD index %v", media.Path)
-		} else {
-			response, err := client.Index().
-				Index(common.MediaIndexName).
-				Type(common.MediaTypeName).
-				Id(media.Path).
-				BodyJson(media).
-				Do(context.TODO())
-
-			if err != nil {
-				atomic.AddInt64(&FailedIndexAttempts, 1)
-				if elasticErr, ok := err.(*elastic.Error); ok {
-					log.Error("Failed indexing %s: status=%d; %s", media.Path, elasticErr.Status, elasticErr.Details)
-				} else {
-					log.Error("Failed indexing %s: %q", media.Path, err.Error())
+		for idx, media := range batch {
+
+			// Check to see if a duplicate item has been added (it might pass the same check in the
+			// scan and fail here - due to the queue between that check and storing the file.
+			if helpers.IsDuplicate(client, states[idx], media.Signature, &media.FullSignature, media.Path, true) {
+				helpers.CheckpointFileDone(media.Path)
+				continue
+			}
+
+			if media.PerceptualHash == "" {
+				media.PerceptualHash = helpers.PerceptualHashFromThumbnail(media.Path)
+			}
+
+			if common.IndexMakeNoChanges {
+				log.Info("WOULD index %v", media.Path)
+				var existing *json.RawMessage
+				if states[idx].Err == nil && states[idx].Document.Found {
+					existing = states[idx].Document.Source
 				}
+				helpers.DryRunIndexed(media, existing)
+				indexed(media.Path)
+				helpers.CheckpointFileDone(media.Path)
 				continue
 			}
 
-			if !response.Created {
-				atomic.AddInt64(&ChangedFiles, 1)
+			bulkProcessor.Add(elastic.NewBulkIndexRequest().
+				Index(common.MediaIndexName).
+				Type(common.MediaTypeName).
+				Id(media.Path).
+				Doc(media))
+		}
+	}
+}
+
+// Wait for an item, then take whatever else is already queued (up to 'maxBatchSize'), so the duplicate
+// checks for all of them are made at once.
+func nextBatch() []*common.Media {
+	media, ok := <-queue
+	if !ok {
+		return nil
+	}
+
+	batch := []*common.Media{media}
+	for len(batch) < maxBatchSize {
+		select {
+		case media, ok := <-queue:
+			if !ok {
+				return batch
 			}
+			batch = append(batch, media)
+		default:
+			return batch
 		}
+	}
+	return batch
+}
 
-		atomic.AddInt64(&IndexedFiles, 1)
+// Called by the bulk processor after each batch is sent; each document succeeds or fails on its own
+func afterBulk(executionId int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+	if err != nil {
+		atomic.AddInt64(&FailedIndexAttempts, int64(len(requests)))
+		if elasticErr, ok := err.(*elastic.Error); ok {
+			log.Error("Failed indexing %d documents: status=%d; %s", len(requests), elasticErr.Status, elasticErr.Details)
+		} else {
+			log.Error("Failed indexing %d documents: %q", len(requests), err.Error())
+		}
+		return
+	}
 
-		if IndexedFiles%1000 == 0 {
-			log.Info("Indexed [%d] for %s", IndexedFiles, media.Path)
+	for _, item := range response.Indexed() {
+		if item.Error != nil {
+			atomic.AddInt64(&FailedIndexAttempts, 1)
+			log.Error("Failed indexing %s: status=%d; %s: %s", item.Id, item.Status, item.Error.Type, item.Error.Reason)
+			continue
 		}
 
-		if !common.IndexMakeNoChanges {
-			fullPath, err := common.FullPathForAliasedPath(media.Path)
-			if err != nil {
-				log.Error("Failed getting full path from alias: %s: (%s)", media.Path, err)
-			} else {
-				classifymedia.Enqueue(fullPath, media.Path, nil)
-			}
+		if item.Status != http.StatusCreated {
+			atomic.AddInt64(&ChangedFiles, 1)
+		}
+		indexed(item.Id)
+		helpers.CheckpointFileDone(item.Id)
+
+		fullPath, err := common.FullPathForAliasedPath(item.Id)
+		if err != nil {
+			log.Error("Failed getting full path from alias: %s: (%s)", item.Id, err)
+		} else {
+			classifymedia.Enqueue(fullPath, item.Id, nil)
 		}
 	}
 }
+
+func indexed(aliasedPath string) {
+	count := atomic.AddInt64(&IndexedFiles, 1)
+	if count%1000 == 0 {
+		log.Info("Indexed [%d] for %s", count, aliasedPath)
+	}
+}
@@ -0,0 +1,120 @@
+package preparemedia
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kevintavog/findaphoto/common"
+)
+
+var leadingNumberRegex = regexp.MustCompile(`^\s*(-?[0-9]+(\.[0-9]+)?)`)
+
+// Many cameras fill unused fields with spaces or placeholders rather than leaving them out
+var placeholderText = map[string]bool{
+	"":        true,
+	"0":       true,
+	"unknown": true,
+	"none":    true,
+}
+
+// The descriptive fields and serial numbers that aren't used for anything other than searching
+func populateDetails(media *common.Media, candidate *common.CandidateFile) {
+	media.CameraSerialNumber = firstText(candidate.Exif.EXIF.SerialNumber)
+	media.LensSerialNumber = firstText(candidate.Exif.EXIF.LensSerialNumber)
+	media.Orientation = firstText(candidate.Exif.EXIF.Orientation)
+	media.Artist = firstText(candidate.Exif.EXIF.Artist, candidate.Exif.XMP.Creator, candidate.Exif.IPTC.ByLine)
+	media.Copyright = firstText(candidate.Exif.EXIF.Copyright, candidate.Exif.XMP.Rights, candidate.Exif.IPTC.CopyrightNotice)
+	media.Software = firstText(candidate.Exif.EXIF.Software)
+
+	populateAltitude(media, candidate)
+	populateImageDirection(media, candidate)
+}
+
+// The composite altitude includes the reference ("52.3 m Above Sea Level"), the EXIF altitude
+// has it separately
+func populateAltitude(media *common.Media, candidate *common.CandidateFile) {
+	altitude := exifText(candidate.Exif.Composite.GPSAltitude)
+	reference := altitude
+	if altitude == "" {
+		altitude = exifText(candidate.Exif.EXIF.GPSAltitude)
+		reference = candidate.Exif.EXIF.GPSAltitudeRef
+	}
+	if altitude == "" {
+		return
+	}
+
+	value, err := parseLeadingNumber(altitude)
+	if err != nil {
+		candidate.AddWarning(common.WarningUnparseableAltitude, fmt.Sprintf("Unable to parse altitude (%s): %s", altitude, err.Error()), "altitude", altitude)
+		return
+	}
+
+	if value > 0 && (strings.Contains(reference, "Below") || strings.TrimSpace(reference) == "1") {
+		value = -value
+	}
+	media.Altitude = &value
+}
+
+func populateImageDirection(media *common.Media, candidate *common.CandidateFile) {
+	direction := exifText(candidate.Exif.EXIF.GPSImgDirection)
+	if direction == "" {
+		return
+	}
+
+	value, err := parseLeadingNumber(direction)
+	if err != nil {
+		candidate.AddWarning(common.WarningUnparseableImageDirection,
+			fmt.Sprintf("Unable to parse image direction (%s): %s", direction, err.Error()), "direction", direction)
+		return
+	}
+	media.ImageDirection = &value
+}
+
+func parseLeadingNumber(text string) (float64, error) {
+	match := leadingNumberRegex.FindStringSubmatch(text)
+	if match == nil {
+		return 0, fmt.Errorf("no number found")
+	}
+	return strconv.ParseFloat(match[1], 64)
+}
+
+// Exiftool returns strings, numbers or lists depending on the content; the first value
+// with real text is used, lists are joined
+func firstText(values ...interface{}) string {
+	for _, v := range values {
+		var text string
+		switch value := v.(type) {
+		case nil:
+			continue
+		case []interface{}:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				if s := exifText(item); s != "" {
+					items = append(items, s)
+				}
+			}
+			text = strings.Join(items, ", ")
+		default:
+			text = exifText(value)
+		}
+
+		if !placeholderText[strings.ToLower(text)] {
+			return text
+		}
+	}
+	return ""
+}
+
+// JSON numbers are float64, which fmt would print in exponent form (1.234567e+06)
+func exifText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return strings.TrimSpace(fmt.Sprint(v))
+	}
+}
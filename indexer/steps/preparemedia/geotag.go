@@ -0,0 +1,321 @@
+package preparemedia
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+var GeotagMatched int64
+var GeotagWritten int64
+var GeotagWriteFailed int64
+
+// Track points further apart than this aren't interpolated between; media captured within this
+// much of either end of a track gets the position of that end
+var GpxMaxGap = 5 * time.Minute
+
+// How far ahead of the track logger's clock the camera's clock is, negative if it's behind
+var GpxTimeOffset time.Duration
+
+// When true, a location from a track is written to the sidecar, which is created if there isn't one
+var GpxWriteBack = false
+
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []struct {
+				Latitude  float64 `xml:"lat,attr"`
+				Longitude float64 `xml:"lon,attr"`
+				Time      string  `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+type trackPoint struct {
+	time      time.Time
+	latitude  float64
+	longitude float64
+}
+
+// The points of each segment are sorted by time. The position between two segments isn't known
+type trackSegment []trackPoint
+
+var trackSegments []trackSegment
+var trackLock sync.RWMutex
+
+// Loads the tracks of all the GPX files in the folder (and the folders in it), replacing those
+// loaded before. Returns the number of track points loaded
+func LoadGpxTracks(directory string) (int, error) {
+	if _, err := os.Stat(directory); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	segments := []trackSegment{}
+	count := 0
+	err := filepath.Walk(directory, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.ToLower(filepath.Ext(filename)) != ".gpx" {
+			return nil
+		}
+
+		fileSegments, err := readGpxFile(filename)
+		if err != nil {
+			log.Warn("Ignoring GPX file '%s': %s", filename, err.Error())
+			return nil
+		}
+		for _, segment := range fileSegments {
+			count += len(segment)
+		}
+		segments = append(segments, fileSegments...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	trackLock.Lock()
+	defer trackLock.Unlock()
+	trackSegments = segments
+	return count, nil
+}
+
+func readGpxFile(filename string) ([]trackSegment, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var gpx gpxFile
+	if err := xml.Unmarshal(data, &gpx); err != nil {
+		return nil, err
+	}
+
+	segments := []trackSegment{}
+	for _, track := range gpx.Tracks {
+		for _, gpxSegment := range track.Segments {
+			segment := trackSegment{}
+			for _, point := range gpxSegment.Points {
+				// Points without a time can't be matched to media
+				pointTime, err := time.Parse(time.RFC3339, strings.TrimSpace(point.Time))
+				if err != nil {
+					continue
+				}
+				segment = append(segment, trackPoint{time: pointTime, latitude: point.Latitude, longitude: point.Longitude})
+			}
+			if len(segment) > 0 {
+				sort.Slice(segment, func(i, j int) bool { return segment[i].time.Before(segment[j].time) })
+				segments = append(segments, segment)
+			}
+		}
+	}
+	return segments, nil
+}
+
+// Returns where the tracks say the camera was at the time, nil if they don't cover it
+func TrackLocation(captured time.Time) *common.GeoPoint {
+	return trackLocation(captured.UTC(), func(point trackPoint) time.Time { return captured })
+}
+
+// Returns where the tracks say the camera was when its clock read the local time, nil if they don't
+// cover it. The zone of the track point nearest in time turns the local time into an instant
+func TrackLocationForLocalTime(local time.Time) *common.GeoPoint {
+	wallClock := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.UTC)
+	return trackLocation(wallClock, func(point trackPoint) time.Time { return instantAt(wallClock, point) })
+}
+
+// Local time is within this much of UTC everywhere
+const maxZoneOffset = 14 * time.Hour
+
+// 'instantNear' gives the capture instant, were the camera at the point; 'approximate' is within a
+// zone offset of every such instant, to skip segments that can't match
+func trackLocation(approximate time.Time, instantNear func(point trackPoint) time.Time) *common.GeoPoint {
+	trackLock.RLock()
+	defer trackLock.RUnlock()
+
+	approximate = approximate.Add(-GpxTimeOffset)
+	var closest *trackPoint
+	closestGap := GpxMaxGap + 1
+	for _, segment := range trackSegments {
+		first, last := segment[0], segment[len(segment)-1]
+		if approximate.Before(first.time.Add(-GpxMaxGap-maxZoneOffset)) || approximate.After(last.time.Add(GpxMaxGap+maxZoneOffset)) {
+			continue
+		}
+
+		// The instant from the start of the segment picks a point; the instant from that point is used
+		instant := instantNear(first).Add(-GpxTimeOffset)
+		index := searchSegment(segment, instant)
+		if index >= len(segment) {
+			index = len(segment) - 1
+		}
+		instant = instantNear(segment[index]).Add(-GpxTimeOffset)
+		if instant.Before(first.time.Add(-GpxMaxGap)) || instant.After(last.time.Add(GpxMaxGap)) {
+			continue
+		}
+
+		index = searchSegment(segment, instant)
+		switch {
+		case index == 0:
+			if gap := first.time.Sub(instant); gap < closestGap {
+				closest, closestGap = &segment[0], gap
+			}
+		case index == len(segment):
+			if gap := instant.Sub(last.time); gap < closestGap {
+				closest, closestGap = &segment[len(segment)-1], gap
+			}
+		default:
+			before, after := segment[index-1], segment[index]
+			if after.time.Sub(before.time) <= GpxMaxGap {
+				return interpolate(before, after, instant)
+			}
+		}
+	}
+
+	if closest == nil {
+		return nil
+	}
+	return &common.GeoPoint{Latitude: closest.latitude, Longitude: closest.longitude}
+}
+
+// The first point at or after the instant
+func searchSegment(segment trackSegment, instant time.Time) int {
+	return sort.Search(len(segment), func(i int) bool { return !segment[i].time.Before(instant) })
+}
+
+// The instant the wall clock reads the time in the zone of the point; the indexer's zone if it's not known
+func instantAt(wallClock time.Time, point trackPoint) time.Time {
+	zone, ok := common.TimeZoneForLocation(point.latitude, point.longitude)
+	if !ok {
+		zone = time.Local
+	}
+	return time.Date(wallClock.Year(), wallClock.Month(), wallClock.Day(), wallClock.Hour(), wallClock.Minute(), wallClock.Second(), wallClock.Nanosecond(), zone)
+}
+
+func interpolate(before, after trackPoint, instant time.Time) *common.GeoPoint {
+	fraction := 0.0
+	if span := after.time.Sub(before.time); span > 0 {
+		fraction = float64(instant.Sub(before.time)) / float64(span)
+	}
+
+	// The short way around, should the track cross the antimeridian
+	longitudeDelta := after.longitude - before.longitude
+	if longitudeDelta > 180 {
+		longitudeDelta -= 360
+	} else if longitudeDelta < -180 {
+		longitudeDelta += 360
+	}
+	longitude := before.longitude + fraction*longitudeDelta
+	if longitude > 180 {
+		longitude -= 360
+	} else if longitude < -180 {
+		longitude += 360
+	}
+
+	return &common.GeoPoint{
+		Latitude:  before.latitude + fraction*(after.latitude-before.latitude),
+		Longitude: longitude,
+	}
+}
+
+// Media without a location gets one from the tracks, if they cover when it was captured. The file
+// timestamp isn't trusted for this. A QuickTime CreateDate is UTC, so its instant is known whatever the zone
+func populateLocationFromTracks(media *common.Media, candidate *common.CandidateFile) {
+	if media.Location != nil || media.DateTime.IsZero() {
+		return
+	}
+	for _, w := range candidate.Warnings {
+		if w.Code == common.WarningNoExifDate {
+			return
+		}
+	}
+
+	instantKnown := media.TimeZoneMethod != common.TimeZoneMethodIndexer || candidate.Exif.Quicktime.CreateDate != ""
+	location := LocateFromTracks(media, instantKnown)
+	if location == nil {
+		return
+	}
+
+	if GpxWriteBack {
+		if err := WriteGpxLocation(candidate.FullPath, candidate.SidecarPath, location); err != nil {
+			candidate.AddWarning(common.WarningGpxWriteFailed, fmt.Sprintf("Failed writing the track location: %s", err.Error()))
+		}
+	}
+}
+
+// Sets the location of the media from the tracks, returning it; nil if the tracks don't cover the
+// capture time. Unless the instant is known, the time zone wasn't, and the capture time is only the
+// local time: it's matched in the zone of the tracks, then the date fields are re-derived in the zone
+// of the location
+func LocateFromTracks(media *common.Media, instantKnown bool) *common.GeoPoint {
+	var location *common.GeoPoint
+	if instantKnown {
+		location = TrackLocation(media.DateTime)
+	} else {
+		location = TrackLocationForLocalTime(media.DateTime)
+	}
+	if location == nil {
+		return nil
+	}
+	atomic.AddInt64(&GeotagMatched, 1)
+	media.Location = location
+	media.LocationSource = common.LocationSourceGpx
+
+	if media.TimeZoneMethod == common.TimeZoneMethodIndexer {
+		if zone, ok := common.TimeZoneForLocation(location.Latitude, location.Longitude); ok {
+			dateTime := media.DateTime.In(zone)
+			if !instantKnown {
+				local := media.DateTime
+				dateTime = time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), zone)
+			}
+			setDateTime(media, dateTime, common.TimeZoneMethodLocation)
+		}
+	}
+	return location
+}
+
+// Writes the location with exiftool to the sidecar, creating one ('IMG_1234.JPG.xmp') if there isn't
+// one - the media file itself isn't changed, later steps may be reading it. The sidecar changes, so the
+// media is indexed again on the next scan, with the location from the sidecar
+func WriteGpxLocation(fullPath, sidecarPath string, location *common.GeoPoint) error {
+	args := []string{"-P", "-overwrite_original"}
+	target := sidecarPath
+	if sidecarPath == "" {
+		// Without a source file, exiftool creates the XMP file with only the tags given
+		target = fullPath + ".xmp"
+		args = []string{"-o", target}
+	}
+
+	if common.IndexMakeNoChanges {
+		log.Info("WOULD write the location %f, %f to %s", location.Latitude, location.Longitude, target)
+		return nil
+	}
+
+	// XMP takes signed values, negative for south & west
+	args = append(args,
+		fmt.Sprintf("-XMP:GPSLatitude=%f", location.Latitude),
+		fmt.Sprintf("-XMP:GPSLongitude=%f", location.Longitude))
+	if sidecarPath != "" {
+		args = append(args, sidecarPath)
+	}
+	out, err := exec.Command(common.ExifToolPath, args...).CombinedOutput()
+	if err != nil {
+		atomic.AddInt64(&GeotagWriteFailed, 1)
+		return fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(string(out)))
+	}
+	atomic.AddInt64(&GeotagWritten, 1)
+	return nil
+}
@@ -13,7 +13,7 @@ import (
 
 	"github.com/kevintavog/findaphoto/common"
 	"github.com/kevintavog/findaphoto/indexer/steps/checkthumbnail"
-	"github.com/kevintavog/findaphoto/indexer/steps/resolveplacename"
+	"github.com/kevintavog/findaphoto/indexer/steps/probevideo"
 )
 
 const numConsumers = 8
@@ -22,7 +22,7 @@ var queue = make(chan *common.CandidateFile, numConsumers)
 var waitGroup sync.WaitGroup
 
 func Start() {
-	resolveplacename.Start()
+	probevideo.Start()
 
 	waitGroup.Add(numConsumers)
 	for idx := 0; idx < numConsumers; idx++ {
@@ -39,8 +39,12 @@ func Done() {
 
 func Wait() {
 	waitGroup.Wait()
-	resolveplacename.Done()
-	resolveplacename.Wait()
+	probevideo.Done()
+	probevideo.Wait()
+}
+
+func QueueLength() int {
+	return len(queue)
 }
 
 func Enqueue(candidate *common.CandidateFile) {
@@ -50,7 +54,7 @@ func Enqueue(candidate *common.CandidateFile) {
 func dequeue() {
 	for candidate := range queue {
 		media := populate(candidate)
-		resolveplacename.Enqueue(media)
+		probevideo.Enqueue(media, candidate.FullPath)
 		checkthumbnail.Enqueue(candidate.FullPath, candidate.AliasedPath, media.MimeType)
 	}
 }
@@ -60,8 +64,11 @@ func populate(candidate *common.CandidateFile) *common.Media {
 		Filename:      path.Base(candidate.FullPath),
 		Path:          candidate.AliasedPath,
 		Signature:     candidate.Signature,
+		FullSignature: candidate.FullSignature,
 		LengthInBytes: candidate.LengthInBytes,
 
+		SidecarSignature: candidate.SidecarSignature,
+
 		MimeType: candidate.Exif.File.MIMEType,
 
 		ApertureValue:   float32(candidate.Exif.EXIF.ApertureValue),
@@ -77,10 +84,13 @@ func populate(candidate *common.CandidateFile) *common.Media {
 	populateFocalLength(media, candidate)
 	populateExposureTime(media, candidate)
 	populateKeywords(media, candidate)
+	populateLocation(media, candidate) // Before the date, the location may decide the time zone
 	populateDateTime(media, candidate)
-	populateLocation(media, candidate)
+	populateLocationFromTracks(media, candidate) // After the date, the capture time picks the track point
 	populateDimensions(media, candidate)
 	populateCameraMakeAndModel(media, candidate)
+	populateRatingAndCaptions(media, candidate)
+	populateDetails(media, candidate)
 
 	media.Warnings = candidate.Warnings
 
@@ -96,17 +106,20 @@ func populateFocalLength(media *common.Media, candidate *common.CandidateFile) {
 	tokens := strings.Split(candidate.Exif.EXIF.FocalLength, " ")
 	if len(tokens) == 2 {
 		if tokens[1] != "mm" {
-			candidate.AddWarning(fmt.Sprintf("Unexpected format for FocalLength (%s)", candidate.Exif.EXIF.FocalLength))
+			candidate.AddWarning(common.WarningUnparseableFocalLength,
+				fmt.Sprintf("Unexpected format for FocalLength (%s)", candidate.Exif.EXIF.FocalLength), "focallength", candidate.Exif.EXIF.FocalLength)
 		} else {
 			v, err := strconv.ParseFloat(tokens[0], 32)
 			if err == nil {
 				media.FocalLengthMm = float32(v)
 			} else {
-				candidate.AddWarning(fmt.Sprintf("Failed converting FocalLength (%s) to a float (%s)", candidate.Exif.EXIF.FocalLength, tokens[0]))
+				candidate.AddWarning(common.WarningUnparseableFocalLength,
+					fmt.Sprintf("Failed converting FocalLength (%s) to a float (%s)", candidate.Exif.EXIF.FocalLength, tokens[0]), "focallength", candidate.Exif.EXIF.FocalLength)
 			}
 		}
 	} else {
-		candidate.AddWarning(fmt.Sprintf("Unexpected format for FocalLength (%s)", candidate.Exif.EXIF.FocalLength))
+		candidate.AddWarning(common.WarningUnparseableFocalLength,
+			fmt.Sprintf("Unexpected format for FocalLength (%s)", candidate.Exif.EXIF.FocalLength), "focallength", candidate.Exif.EXIF.FocalLength)
 	}
 
 }
@@ -121,33 +134,52 @@ func populateDimensions(media *common.Media, candidate *common.CandidateFile) {
 	}
 
 	if candidate.Exif.Quicktime.Duration != "" {
-		// '10.15 s' OR '0:00:35'
-		tokens := strings.Split(candidate.Exif.Quicktime.Duration, ":")
-		if len(tokens) == 3 {
-			hours, err := strconv.Atoi(tokens[0])
-			if err == nil {
-				minutes, err := strconv.Atoi(tokens[1])
-				if err == nil {
-					seconds, err := strconv.Atoi(tokens[2])
-					if err == nil {
-						media.DurationSeconds = float32(hours*60*60 + minutes*60 + seconds)
-					}
-				}
-			}
-
-			if err != nil {
-				fmt.Printf("TEMP: Unable to parse %s (%v)\n", candidate.Exif.Quicktime.Duration, tokens)
-			}
+		seconds, err := parseDuration(candidate.Exif.Quicktime.Duration)
+		if err != nil {
+			candidate.AddWarning(common.WarningUnparseableDuration,
+				fmt.Sprintf("Unable to parse duration '%s': %s", candidate.Exif.Quicktime.Duration, err.Error()), "duration", candidate.Exif.Quicktime.Duration)
 		} else {
-			tokens = strings.Split(candidate.Exif.Quicktime.Duration, " ")
-			if len(tokens) >= 1 {
-				v, err := strconv.ParseFloat(tokens[0], 32)
-				if err == nil {
-					media.DurationSeconds = float32(v)
-				}
-			}
+			media.DurationSeconds = float32(seconds)
+		}
+	}
+}
+
+// Exiftool formats durations as '10.15 s' (under 30 seconds), '0:00:35', '0:01:23.45', '1 days 2:03:04'
+// and appends ' (approx)' when it's estimated
+func parseDuration(duration string) (float64, error) {
+	duration = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(duration), "(approx)"))
+
+	days := 0.0
+	if tokens := strings.SplitN(duration, " days ", 2); len(tokens) == 2 {
+		d, err := strconv.ParseFloat(tokens[0], 64)
+		if err != nil {
+			return 0, err
+		}
+		days = d
+		duration = tokens[1]
+	}
+
+	if strings.HasSuffix(duration, " s") || !strings.Contains(duration, ":") {
+		seconds, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(duration, " s")), 64)
+		return days*24*60*60 + seconds, err
+	}
+
+	// Hours and minutes are optional, working back from the seconds
+	total := 0.0
+	multiplier := 1.0
+	tokens := strings.Split(duration, ":")
+	if len(tokens) > 3 {
+		return 0, errors.New("too many ':' separated values")
+	}
+	for idx := len(tokens) - 1; idx >= 0; idx-- {
+		v, err := strconv.ParseFloat(tokens[idx], 64)
+		if err != nil {
+			return 0, err
 		}
+		total += v * multiplier
+		multiplier *= 60
 	}
+	return days*24*60*60 + total, nil
 }
 
 func populateKeywords(media *common.Media, candidate *common.CandidateFile) {
@@ -156,13 +188,13 @@ func populateKeywords(media *common.Media, candidate *common.CandidateFile) {
 
 	switch keyType := candidate.Exif.IPTC.Keywords.(type) {
 	default:
-		candidate.AddWarning(fmt.Sprintf("Unexpected keyword type %T (%q)", keyType, candidate.Exif.IPTC.Keywords))
+		candidate.AddWarning(common.WarningUnexpectedKeywords, fmt.Sprintf("Unexpected keyword type %T (%q)", keyType, candidate.Exif.IPTC.Keywords))
 	case []interface{}:
 		for _, s := range candidate.Exif.IPTC.Keywords.([]interface{}) {
-			keywordMap[s.(string)] = true
+			keywordMap[fmt.Sprint(s)] = true
 		}
 	case interface{}:
-		for _, s := range []string{candidate.Exif.IPTC.Keywords.(string)} {
+		for _, s := range []string{fmt.Sprint(candidate.Exif.IPTC.Keywords)} {
 			keywordMap[s] = true
 		}
 	case nil:
@@ -172,28 +204,65 @@ func populateKeywords(media *common.Media, candidate *common.CandidateFile) {
 	// And the keywords in XMP.Subject
 	switch subjectType := candidate.Exif.XMP.Subject.(type) {
 	default:
-		candidate.AddWarning(fmt.Sprintf("Unexpected subject type %T (%q)", subjectType, candidate.Exif.XMP.Subject))
+		candidate.AddWarning(common.WarningUnexpectedKeywords, fmt.Sprintf("Unexpected subject type %T (%q)", subjectType, candidate.Exif.XMP.Subject))
 	case []interface{}:
 		for _, s := range candidate.Exif.XMP.Subject.([]interface{}) {
-			keywordMap[s.(string)] = true
+			keywordMap[fmt.Sprint(s)] = true
 		}
 	case interface{}:
-		for _, s := range []string{candidate.Exif.XMP.Subject.(string)} {
+		for _, s := range []string{fmt.Sprint(candidate.Exif.XMP.Subject)} {
 			keywordMap[s] = true
 		}
 	case nil:
 		// Nothing to do, subject not present
 	}
 
+	// And XMP.Keywords, which sidecars may have
+	switch keywords := candidate.Exif.XMP.Keywords.(type) {
+	case []interface{}:
+		for _, s := range keywords {
+			keywordMap[fmt.Sprint(s)] = true
+		}
+	case nil:
+		// Nothing to do, keywords not present
+	default:
+		for _, s := range strings.Split(fmt.Sprint(keywords), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				keywordMap[s] = true
+			}
+		}
+	}
+
 	for k, _ := range keywordMap {
 		media.Keywords = append(media.Keywords, k)
 	}
 }
 
+// Rating, title and description usually come from an XMP sidecar written by an editor; title and
+// description fall back to IPTC and EXIF
+func populateRatingAndCaptions(media *common.Media, candidate *common.CandidateFile) {
+	if candidate.Exif.XMP.Rating != nil {
+		rating, err := strconv.ParseFloat(fmt.Sprint(candidate.Exif.XMP.Rating), 64)
+		if err != nil {
+			candidate.AddWarning(common.WarningUnparseableRating,
+				fmt.Sprintf("Unable to parse rating (%q): %s", candidate.Exif.XMP.Rating, err.Error()), "rating", fmt.Sprint(candidate.Exif.XMP.Rating))
+		} else {
+			media.Rating = int(rating)
+		}
+	}
+
+	// XMP first, as that's where editors write (and sidecars have already been merged into it)
+	media.Title = firstText(candidate.Exif.XMP.Title, candidate.Exif.IPTC.ObjectName)
+	media.Description = firstText(
+		candidate.Exif.XMP.Description,
+		candidate.Exif.IPTC.CaptionAbstract,
+		candidate.Exif.EXIF.ImageDescription)
+}
+
 func populateIso(media *common.Media, candidate *common.CandidateFile) {
 	switch isoType := candidate.Exif.EXIF.ISO.(type) {
 	default:
-		candidate.AddWarning(fmt.Sprintf("Unexpected ISO type: %T (%q)", isoType, candidate.Exif.EXIF.ISO))
+		candidate.AddWarning(common.WarningUnparseableISO, fmt.Sprintf("Unexpected ISO type: %T (%q)", isoType, candidate.Exif.EXIF.ISO))
 	case int:
 		media.Iso = candidate.Exif.EXIF.ISO.(int)
 	case float64:
@@ -204,7 +273,8 @@ func populateIso(media *common.Media, candidate *common.CandidateFile) {
 		var err error
 		media.Iso, err = strconv.Atoi(re.FindString(s))
 		if err != nil {
-			candidate.AddWarning(fmt.Sprintf("ISO string (%s) failed to convert to an int: %s", candidate.Exif.EXIF.ISO, err))
+			candidate.AddWarning(common.WarningUnparseableISO,
+				fmt.Sprintf("ISO string (%s) failed to convert to an int: %s", candidate.Exif.EXIF.ISO, err), "iso", s)
 		}
 	case nil:
 		// Nothing to do, no value present
@@ -215,7 +285,7 @@ func populateExposureTime(media *common.Media, candidate *common.CandidateFile)
 	valueSet := false
 	switch etType := candidate.Exif.EXIF.ExposureTime.(type) {
 	default:
-		candidate.AddWarning(fmt.Sprintf("Unexpected ExposureTime type: %T", etType))
+		candidate.AddWarning(common.WarningUnparseableExposureTime, fmt.Sprintf("Unexpected ExposureTime type: %T", etType))
 	case float64:
 		media.ExposureTimeString = strconv.FormatFloat(candidate.Exif.EXIF.ExposureTime.(float64), 'f', -1, 64)
 		valueSet = true
@@ -252,7 +322,8 @@ func populateExposureTime(media *common.Media, candidate *common.CandidateFile)
 		}
 
 		if !converted {
-			candidate.AddWarning(fmt.Sprintf("Unable to convert ExposureTimeString to decimal: %s", media.ExposureTimeString))
+			candidate.AddWarning(common.WarningUnparseableExposureTime,
+				fmt.Sprintf("Unable to convert ExposureTimeString to decimal: %s", media.ExposureTimeString), "exposuretime", media.ExposureTimeString)
 		}
 	}
 }
@@ -260,23 +331,29 @@ func populateExposureTime(media *common.Media, candidate *common.CandidateFile)
 func populateDateTime(media *common.Media, candidate *common.CandidateFile) {
 	var dateTime time.Time
 	var err error
+	method := common.TimeZoneMethodIndexer
 
 	if dateTime.IsZero() && candidate.Exif.Quicktime.CreateDate != "" {
 		// UTC according to spec - no timezone like there is for 'ContentCreateDate'
 		dateTime, err = time.Parse("2006:01:02 15:04:05", candidate.Exif.Quicktime.CreateDate)
 		if err != nil {
-			candidate.AddWarning(fmt.Sprintf(
-				"Failed parsing CreateDate '%s': %s (in %s)", candidate.Exif.Quicktime.CreateDate, err.Error(), candidate.FullPath))
+			candidate.AddWarning(common.WarningUnparseableDate, fmt.Sprintf(
+				"Failed parsing CreateDate '%s': %s (in %s)", candidate.Exif.Quicktime.CreateDate, err.Error(), candidate.FullPath),
+				"field", "CreateDate", "value", candidate.Exif.Quicktime.CreateDate)
 		}
-		dateTime = dateTime.In(time.Local)
+		var zone *time.Location
+		zone, method = captureTimeZone(media, candidate)
+		dateTime = dateTime.In(zone)
 	}
 
 	if dateTime.IsZero() && candidate.Exif.Quicktime.ContentCreateDate != "" {
 		dateTime, err = time.Parse("2006:01:02 15:04:05-07:00", candidate.Exif.Quicktime.ContentCreateDate)
 		if err != nil {
-			candidate.AddWarning(fmt.Sprintf(
-				"Failed parsing ContentCreateDate '%s': %s (in %s)", candidate.Exif.Quicktime.ContentCreateDate, err.Error(), candidate.FullPath))
+			candidate.AddWarning(common.WarningUnparseableDate, fmt.Sprintf(
+				"Failed parsing ContentCreateDate '%s': %s (in %s)", candidate.Exif.Quicktime.ContentCreateDate, err.Error(), candidate.FullPath),
+				"field", "ContentCreateDate", "value", candidate.Exif.Quicktime.ContentCreateDate)
 		}
+		method = common.TimeZoneMethodOffset
 	}
 
 	if dateTime.IsZero() {
@@ -288,45 +365,96 @@ func populateDateTime(media *common.Media, candidate *common.CandidateFile) {
 			exifDateTime = candidate.Exif.EXIF.ModifyDate
 		}
 		if exifDateTime != "" {
-			// No timezone - and the spec doesn't specify.
-			dateTime, err = time.ParseInLocation("2006:01:02 15:04:05", exifDateTime, time.Local)
+			// No timezone in the date itself - newer cameras record the offset separately
+			var zone *time.Location
+			zone, method = captureTimeZone(media, candidate)
+			dateTime, err = time.ParseInLocation("2006:01:02 15:04:05", exifDateTime, zone)
 			if err != nil {
-				candidate.AddWarning(fmt.Sprintf(
-					"Failed parsing '%s': %s (in %s)", exifDateTime, err.Error(), candidate.FullPath))
+				candidate.AddWarning(common.WarningUnparseableDate, fmt.Sprintf(
+					"Failed parsing '%s': %s (in %s)", exifDateTime, err.Error(), candidate.FullPath),
+					"value", exifDateTime)
 			}
 		}
 	}
 
 	if dateTime.IsZero() {
-		candidate.AddWarning("No usable date in EXIF, using file timestamp")
+		candidate.AddWarning(common.WarningNoExifDate, "No usable date in EXIF, using file timestamp")
 		dateTime, err = time.Parse("2006:01:02 15:04:05-07:00", candidate.Exif.File.FileModifyDate)
 		if err != nil {
-			candidate.AddWarning(fmt.Sprintf(
-				"Failed parsing File.FileModifyDate '%s': %s (in %s)", candidate.Exif.File.FileModifyDate, err.Error(), candidate.FullPath))
+			candidate.AddWarning(common.WarningUnparseableDate, fmt.Sprintf(
+				"Failed parsing File.FileModifyDate '%s': %s (in %s)", candidate.Exif.File.FileModifyDate, err.Error(), candidate.FullPath),
+				"field", "FileModifyDate", "value", candidate.Exif.File.FileModifyDate)
 		}
+		method = common.TimeZoneMethodIndexer
 	}
 
 	if candidate.Exif.File.FileModifyDate != "" {
 		fileModifyDateTime, err := time.Parse("2006:01:02 15:04:05-07:00", candidate.Exif.File.FileModifyDate)
 		if err != nil {
-			candidate.AddWarning(fmt.Sprintf(
-				"Failed parsing File.FileModifyDate '%s': %s (in %s)", candidate.Exif.File.FileModifyDate, err.Error(), candidate.FullPath))
+			candidate.AddWarning(common.WarningUnparseableDate, fmt.Sprintf(
+				"Failed parsing File.FileModifyDate '%s': %s (in %s)", candidate.Exif.File.FileModifyDate, err.Error(), candidate.FullPath),
+				"field", "FileModifyDate", "value", candidate.Exif.File.FileModifyDate)
 		} else {
 			// Allow a small amount of difference to account for somefile systems (FAT) that have poor timestamp granularity
 			if math.Abs(fileModifyDateTime.Sub(dateTime).Seconds()) > 2 {
-				candidate.AddWarning(fmt.Sprintf(
-					"File modify date does not match media date (%q - %q)", fileModifyDateTime, dateTime))
+				candidate.AddWarning(common.WarningFileDateMismatch, fmt.Sprintf(
+					"File modify date does not match media date (%q - %q)", fileModifyDateTime, dateTime),
+					"filedate", fileModifyDateTime.Format(time.RFC3339), "mediadate", dateTime.Format(time.RFC3339))
 			}
 		}
 	}
 
+	setDateTime(media, dateTime, method)
+}
+
+// The date fields are all from the wall-clock time where the media was captured
+func setDateTime(media *common.Media, dateTime time.Time, method string) {
 	media.Date = dateTime.Format("20060102")
 	media.DateTime = dateTime
+	media.LocalDateTime = dateTime.Format("2006-01-02T15:04:05")
+	media.UTCDateTime = dateTime.UTC()
+	media.TimeZone = timeZoneName(dateTime)
+	media.TimeZoneMethod = method
 	media.MonthName = dateTime.Month().String() + " " + dateTime.Month().String()[:3]
 	media.DayName = dateTime.Weekday().String() + " " + dateTime.Weekday().String()[:3]
 	media.DayOfYear = common.DayOfYearFromDate(dateTime)
 }
 
+// The offset the camera recorded is preferred, then the zone the location is in. The location must
+// be populated before this is called
+func captureTimeZone(media *common.Media, candidate *common.CandidateFile) (*time.Location, string) {
+	for _, offset := range []string{candidate.Exif.EXIF.OffsetTimeOriginal, candidate.Exif.EXIF.OffsetTimeDigitized, candidate.Exif.EXIF.OffsetTime} {
+		if offset == "" {
+			continue
+		}
+		zone, err := time.Parse("-07:00", strings.TrimSpace(offset))
+		if err != nil {
+			candidate.AddWarning(common.WarningUnparseableTimeZone,
+				fmt.Sprintf("Failed parsing time zone offset '%s': %s", offset, err.Error()), "offset", offset)
+			continue
+		}
+		_, seconds := zone.Zone()
+		return time.FixedZone(offset, seconds), common.TimeZoneMethodOffset
+	}
+
+	if media.Location != nil {
+		if zone, ok := common.TimeZoneForLocation(media.Location.Latitude, media.Location.Longitude); ok {
+			return zone, common.TimeZoneMethodLocation
+		}
+	}
+
+	return time.Local, common.TimeZoneMethodIndexer
+}
+
+// The IANA name if it's known, otherwise the offset ("+02:00")
+func timeZoneName(dateTime time.Time) string {
+	name := dateTime.Location().String()
+	if name == "" || name == "Local" || name == "UTC" || common.IsTimeZoneOffset(name) {
+		return dateTime.Format("-07:00")
+	}
+	return name
+}
+
 func populateLocation(media *common.Media, candidate *common.CandidateFile) {
 	if candidate.Exif.Composite.GPSPosition != "" {
 		if populateWithGpsPosition(media, candidate, candidate.Exif.Composite.GPSPosition) {
@@ -342,22 +470,23 @@ func populateWithGpsPosition(media *common.Media, candidate *common.CandidateFil
 	// 47 deg 35' 50.66" N, 122 deg 19' 59.50" W == 47.597389 -122.333194
 	latAndLongTokens := strings.Split(gpsPosition, ",")
 	if len(latAndLongTokens) != 2 {
-		candidate.AddWarning(fmt.Sprintf("Unsupported GPSPosition: '%s'", gpsPosition))
+		candidate.AddWarning(common.WarningUnparseableGPS, fmt.Sprintf("Unsupported GPSPosition: '%s'", gpsPosition), "position", gpsPosition)
 		return false
 	}
 
 	latitudeValue := strings.Trim(latAndLongTokens[0], " ")
 	latitudeTokens := strings.Split(latitudeValue, " ")
 	if len(latitudeTokens) != 5 {
-		candidate.AddWarning(fmt.Sprintf("Unsupported GPSPosition (latitude): '%s'", gpsPosition))
+		candidate.AddWarning(common.WarningUnparseableGPS, fmt.Sprintf("Unsupported GPSPosition (latitude): '%s'", gpsPosition), "position", gpsPosition)
 		return false
 	}
 
 	longitudeValue := strings.Trim(latAndLongTokens[1], " ")
 	longitudeTokens := strings.Split(longitudeValue, " ")
 	if len(longitudeTokens) != 5 {
-		candidate.AddWarning(fmt.Sprintf(
-			"Unsupported GPSPosition (longitude): '%s' - %s - %s", gpsPosition, latAndLongTokens[1], strings.Join(longitudeTokens, ", ")))
+		candidate.AddWarning(common.WarningUnparseableGPS, fmt.Sprintf(
+			"Unsupported GPSPosition (longitude): '%s' - %s - %s", gpsPosition, latAndLongTokens[1], strings.Join(longitudeTokens, ", ")),
+			"position", gpsPosition)
 		return false
 	}
 
@@ -368,7 +497,7 @@ func populateWithGpsPosition(media *common.Media, candidate *common.CandidateFil
 	case "S":
 		latRef = "South"
 	default:
-		candidate.AddWarning(fmt.Sprintf("Unsupported GPSPosition (latitude ref): '%s'", gpsPosition))
+		candidate.AddWarning(common.WarningUnparseableGPS, fmt.Sprintf("Unsupported GPSPosition (latitude ref): '%s'", gpsPosition), "position", gpsPosition)
 		return false
 	}
 	var lonRef string
@@ -378,7 +507,7 @@ func populateWithGpsPosition(media *common.Media, candidate *common.CandidateFil
 	case "E":
 		lonRef = "East"
 	default:
-		candidate.AddWarning(fmt.Sprintf("Unsupported GPSPosition (longitude ref): '%s'", gpsPosition))
+		candidate.AddWarning(common.WarningUnparseableGPS, fmt.Sprintf("Unsupported GPSPosition (longitude ref): '%s'", gpsPosition), "position", gpsPosition)
 		return false
 	}
 
@@ -393,18 +522,20 @@ func populateWithGpsAndRef(media *common.Media, candidate *common.CandidateFile,
 
 	location := fmt.Sprintf("%s %s, %s %s", gpsLatitude, gpsLatitudeRef, gpsLongitude, gpsLongitudeRef)
 	if gpsLatitude == "" || gpsLatitudeRef == "" || gpsLongitude == "" || gpsLongitudeRef == "" {
-		candidate.AddWarning(fmt.Sprintf("Ignoring poorly formed location: %s", location))
+		candidate.AddWarning(common.WarningUnparseableGPS, fmt.Sprintf("Ignoring poorly formed location: %s", location), "location", location)
 		return false
 	}
 	if (gpsLatitudeRef != "North" && gpsLatitudeRef != "South") || (gpsLongitudeRef != "West" && gpsLongitudeRef != "East") {
-		candidate.AddWarning(fmt.Sprintf("Ignoring poorly formed location - invalid reference: '%s', '%s' (%s)", gpsLatitudeRef, gpsLongitudeRef, location))
+		candidate.AddWarning(common.WarningUnparseableGPS,
+			fmt.Sprintf("Ignoring poorly formed location - invalid reference: '%s', '%s' (%s)", gpsLatitudeRef, gpsLongitudeRef, location), "location", location)
 		return false
 	}
 
 	latFloat, laErr := dmsToFloat(gpsLatitude)
 	lonFloat, loErr := dmsToFloat(gpsLongitude)
 	if laErr != nil || loErr != nil {
-		candidate.AddWarning(fmt.Sprintf("Ignoring location, unable to parse lat/lon %q, %q (%s)", laErr, loErr, location))
+		candidate.AddWarning(common.WarningUnparseableGPS,
+			fmt.Sprintf("Ignoring location, unable to parse lat/lon %q, %q (%s)", laErr, loErr, location), "location", location)
 		return false
 	}
 
@@ -416,6 +547,7 @@ func populateWithGpsAndRef(media *common.Media, candidate *common.CandidateFile,
 	}
 
 	media.Location = &common.GeoPoint{Latitude: latFloat, Longitude: lonFloat}
+	media.LocationSource = common.LocationSourceExif
 	return true
 }
 
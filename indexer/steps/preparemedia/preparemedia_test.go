@@ -1,7 +1,10 @@
 package preparemedia
 
 import (
+	"io/ioutil"
 	"math"
+	"os"
+	"path"
 	"strings"
 	"testing"
 	"time"
@@ -148,6 +151,23 @@ func TestVideoDuration(t *testing.T) {
 	if media.DurationSeconds != 42 {
 		t.Fatalf("Sub-second duration failed: %f", media.DurationSeconds)
 	}
+
+	durations := map[string]float64{
+		"0:01:23.5":        83.5,
+		"12:34":            754,
+		"0:00:35 (approx)": 35,
+		"1 days 0:00:10":   86410,
+		"28.27 s (approx)": 28.27,
+		"2.5":              2.5,
+	}
+	for duration, expected := range durations {
+		candidate.Exif.Quicktime.Duration = duration
+		media.DurationSeconds = 0
+		populateDimensions(media, candidate)
+		if !floatEquals(float64(media.DurationSeconds), float64(float32(expected))) {
+			t.Fatalf("'%s' became %f, expected %f", duration, media.DurationSeconds, expected)
+		}
+	}
 }
 
 func TestFocalLength(t *testing.T) {
@@ -167,3 +187,331 @@ func floatEquals(a, b float64) bool {
 	}
 	return false
 }
+
+func TestPopulateDetails(t *testing.T) {
+	media := &common.Media{}
+	candidate := &common.CandidateFile{}
+
+	candidate.Exif.EXIF.GPSAltitude = "12.5 m"
+	candidate.Exif.EXIF.GPSAltitudeRef = "Below Sea Level"
+	candidate.Exif.EXIF.GPSImgDirection = float64(0)
+	candidate.Exif.EXIF.SerialNumber = float64(1234567)
+	candidate.Exif.EXIF.Artist = "   "
+	candidate.Exif.XMP.Creator = []interface{}{"Kevin", "Someone Else"}
+
+	populateDetails(media, candidate)
+	if media.Altitude == nil || !floatEquals(*media.Altitude, -12.5) {
+		t.Fatalf("Wrong altitude: %v", media.Altitude)
+	}
+	if media.ImageDirection == nil || *media.ImageDirection != 0 {
+		t.Fatalf("Expected an image direction of 0 (north), got %v", media.ImageDirection)
+	}
+	if media.CameraSerialNumber != "1234567" {
+		t.Fatalf("Wrong serial number: %s", media.CameraSerialNumber)
+	}
+	if media.Artist != "Kevin, Someone Else" {
+		t.Fatalf("Wrong artist: %s", media.Artist)
+	}
+}
+
+func TestOffsetTimeOriginal(t *testing.T) {
+	media := &common.Media{}
+	candidate := &common.CandidateFile{}
+
+	// Just before midnight in Tokyo is the previous day in UTC (and in the US)
+	candidate.Exif.EXIF.DateTimeOriginal = "2017:03:04 23:30:00"
+	candidate.Exif.EXIF.OffsetTimeOriginal = "+09:00"
+
+	populateDateTime(media, candidate)
+	if media.Date != "20170304" || media.LocalDateTime != "2017-03-04T23:30:00" {
+		t.Fatalf("Wrong local date: %s, %s", media.Date, media.LocalDateTime)
+	}
+	if media.UTCDateTime.Format("2006-01-02T15:04:05") != "2017-03-04T14:30:00" {
+		t.Fatalf("Wrong UTC date/time: %v", media.UTCDateTime)
+	}
+	if media.TimeZone != "+09:00" || media.TimeZoneMethod != common.TimeZoneMethodOffset {
+		t.Fatalf("Wrong time zone: %s (%s)", media.TimeZone, media.TimeZoneMethod)
+	}
+}
+
+func TestTimeZoneName(t *testing.T) {
+	tests := []struct {
+		zone     string
+		offset   int
+		expected string
+	}{
+		{"+09:00", 9 * 60 * 60, "+09:00"},
+		{"-0330", -(3*60 + 30) * 60, "-03:30"},
+		{"UTC", 0, "+00:00"},
+		{"Asia/Tokyo", 0, "Asia/Tokyo"},
+		{"America/Port-au-Prince", 0, "America/Port-au-Prince"},
+		{"Etc/GMT+5", 0, "Etc/GMT+5"},
+	}
+
+	for _, test := range tests {
+		location, err := time.LoadLocation(test.zone)
+		if err != nil {
+			location = time.FixedZone(test.zone, test.offset)
+		}
+		dateTime := time.Date(2017, 3, 4, 23, 30, 0, 0, location)
+		if name := timeZoneName(dateTime); name != test.expected {
+			t.Errorf("'%s' should be named '%s', not '%s'", test.zone, test.expected, name)
+		}
+	}
+}
+
+func TestFileDateMismatchWarning(t *testing.T) {
+	media := &common.Media{}
+	candidate := &common.CandidateFile{}
+
+	candidate.Exif.EXIF.DateTimeOriginal = "2017:03:04 23:30:00"
+	candidate.Exif.EXIF.OffsetTimeOriginal = "+09:00"
+	candidate.Exif.File.FileModifyDate = "2017:03:05 10:00:00-08:00"
+
+	populateDateTime(media, candidate)
+	if len(candidate.Warnings) != 1 || candidate.Warnings[0].Code != common.WarningFileDateMismatch {
+		t.Fatalf("Expected a file date mismatch warning: %v", candidate.Warnings)
+	}
+	if candidate.Warnings[0].Params["mediadate"] != "2017-03-04T23:30:00+09:00" {
+		t.Fatalf("Wrong media date param: %v", candidate.Warnings[0].Params)
+	}
+}
+
+func TestTrackLocation(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	gpx := `<?xml version="1.0"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><trkseg>
+    <trkpt lat="47.60" lon="-122.30"><time>2017-03-04T20:00:00Z</time></trkpt>
+    <trkpt lat="47.70" lon="-122.40"><time>2017-03-04T20:01:00Z</time></trkpt>
+    <trkpt lat="48.00" lon="-123.00"><time>2017-03-04T21:00:00Z</time></trkpt>
+  </trkseg></trk>
+</gpx>`
+	if err := ioutil.WriteFile(path.Join(directory, "track.gpx"), []byte(gpx), 0644); err != nil {
+		t.Fatal(err)
+	}
+	count, err := LoadGpxTracks(directory)
+	if err != nil || count != 3 {
+		t.Fatalf("Loading tracks failed: %d, %v", count, err)
+	}
+
+	captured := time.Date(2017, 3, 4, 20, 0, 30, 0, time.UTC)
+	location := TrackLocation(captured)
+	if location == nil || !floatEquals(location.Latitude, 47.65) || !floatEquals(location.Longitude, -122.35) {
+		t.Fatalf("Wrong interpolated location: %v", location)
+	}
+
+	// The camera clock is two minutes ahead of the track
+	GpxTimeOffset = 2 * time.Minute
+	location = TrackLocation(captured.Add(2 * time.Minute))
+	GpxTimeOffset = 0
+	if location == nil || !floatEquals(location.Latitude, 47.65) {
+		t.Fatalf("Wrong location with a time offset: %v", location)
+	}
+
+	// The points either side are further apart than the max gap
+	if location = TrackLocation(time.Date(2017, 3, 4, 20, 30, 0, 0, time.UTC)); location != nil {
+		t.Fatalf("Expected no location between distant points: %v", location)
+	}
+
+	// Shortly after the end of the track
+	location = TrackLocation(time.Date(2017, 3, 4, 21, 2, 0, 0, time.UTC))
+	if location == nil || !floatEquals(location.Latitude, 48.0) {
+		t.Fatalf("Expected the end of the track: %v", location)
+	}
+}
+
+func TestTrackLocationForLocalTime(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	zones := `{"type":"FeatureCollection","features":[
+{"type":"Feature","properties":{"tzid":"Asia/Tokyo"},"geometry":{"type":"Polygon","coordinates":[[[129,30],[146,30],[146,46],[129,46],[129,30]]]}}]}`
+	common.TimeZoneBoundaryFilename = path.Join(directory, "timezones.geojson")
+	if err := ioutil.WriteFile(common.TimeZoneBoundaryFilename, []byte(zones), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gpx := `<?xml version="1.0"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><trkseg>
+    <trkpt lat="35.60" lon="139.70"><time>2017-03-05T01:00:00Z</time></trkpt>
+    <trkpt lat="35.70" lon="139.80"><time>2017-03-05T01:01:00Z</time></trkpt>
+  </trkseg></trk>
+</gpx>`
+	if err := ioutil.WriteFile(path.Join(directory, "track.gpx"), []byte(gpx), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadGpxTracks(directory); err != nil {
+		t.Fatal(err)
+	}
+
+	// No offset, so the time is only known as Tokyo's wall clock - 10:00:30 there is 01:00:30 UTC
+	media := &common.Media{}
+	candidate := &common.CandidateFile{}
+	candidate.Exif.EXIF.DateTimeOriginal = "2017:03:05 10:00:30"
+	populateDateTime(media, candidate)
+	populateLocationFromTracks(media, candidate)
+
+	if media.Location == nil || !floatEquals(media.Location.Latitude, 35.65) || !floatEquals(media.Location.Longitude, 139.75) {
+		t.Fatalf("Wrong location: %v", media.Location)
+	}
+	if media.TimeZone != "Asia/Tokyo" || media.TimeZoneMethod != common.TimeZoneMethodLocation {
+		t.Fatalf("Wrong time zone: %s (%s)", media.TimeZone, media.TimeZoneMethod)
+	}
+	if media.LocalDateTime != "2017-03-05T10:00:30" || !media.UTCDateTime.Equal(time.Date(2017, 3, 5, 1, 0, 30, 0, time.UTC)) {
+		t.Fatalf("Wrong date: %s (%v)", media.LocalDateTime, media.UTCDateTime)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	tests := []struct {
+		before    trackPoint
+		after     trackPoint
+		clock     string
+		latitude  float64
+		longitude float64
+	}{
+		{trackAt("20:00:00", 47.60, -122.30), trackAt("20:01:00", 47.70, -122.40), "20:00:30", 47.65, -122.35},
+		{trackAt("20:00:00", 47.60, -122.30), trackAt("20:01:00", 47.70, -122.40), "20:00:15", 47.625, -122.325},
+		{trackAt("20:00:00", 47.60, -122.30), trackAt("20:01:00", 47.70, -122.40), "20:00:00", 47.60, -122.30},
+		{trackAt("20:00:00", 47.60, -122.30), trackAt("20:01:00", 47.70, -122.40), "20:01:00", 47.70, -122.40},
+		{trackAt("20:00:00", 47.60, -122.30), trackAt("20:00:00", 47.70, -122.40), "20:00:00", 47.60, -122.30},
+
+		// Across the antimeridian, both ways
+		{trackAt("20:00:00", -17.0, 179.5), trackAt("20:01:00", -17.0, -179.5), "20:00:15", -17.0, 179.75},
+		{trackAt("20:00:00", -17.0, 179.5), trackAt("20:01:00", -17.0, -179.5), "20:00:45", -17.0, -179.75},
+		{trackAt("20:00:00", -17.0, -179.5), trackAt("20:01:00", -17.0, 179.5), "20:00:15", -17.0, -179.75},
+		{trackAt("20:00:00", -17.0, -179.5), trackAt("20:01:00", -17.0, 179.5), "20:00:45", -17.0, 179.75},
+	}
+
+	for _, test := range tests {
+		location := interpolate(test.before, test.after, trackTime(test.clock))
+		if !floatEquals(location.Latitude, test.latitude) || !floatEquals(location.Longitude, test.longitude) {
+			t.Errorf("%v to %v at %s should be %f,%f, not %f,%f", test.before, test.after, test.clock,
+				test.latitude, test.longitude, location.Latitude, location.Longitude)
+		}
+	}
+}
+
+func TestTrackLocationGaps(t *testing.T) {
+	useTrackSegments(
+		trackSegment{trackAt("20:00:00", 47.60, -122.30), trackAt("20:01:00", 47.70, -122.40), trackAt("21:00:00", 48.00, -123.00)},
+		trackSegment{trackAt("21:04:00", 49.00, -124.00), trackAt("21:05:00", 49.10, -124.10)})
+	defer useTrackSegments()
+
+	tests := []struct {
+		clock    string
+		offset   time.Duration
+		expected *common.GeoPoint
+	}{
+		{"20:00:30", 0, &common.GeoPoint{Latitude: 47.65, Longitude: -122.35}},
+		{"20:01:00", 0, &common.GeoPoint{Latitude: 47.70, Longitude: -122.40}},
+
+		// The points either side are further apart than the max gap, however close one of them is
+		{"20:30:00", 0, nil},
+		{"20:02:00", 0, nil},
+
+		// Within the max gap of either end of a segment, the nearest end
+		{"19:55:00", 0, &common.GeoPoint{Latitude: 47.60, Longitude: -122.30}},
+		{"19:54:59", 0, nil},
+		{"21:01:00", 0, &common.GeoPoint{Latitude: 48.00, Longitude: -123.00}},
+		{"21:03:00", 0, &common.GeoPoint{Latitude: 49.00, Longitude: -124.00}},
+		{"21:04:30", 0, &common.GeoPoint{Latitude: 49.05, Longitude: -124.05}},
+		{"21:10:00", 0, &common.GeoPoint{Latitude: 49.10, Longitude: -124.10}},
+		{"21:10:01", 0, nil},
+
+		// The camera clock ahead of, or behind, the track logger
+		{"20:02:30", 2 * time.Minute, &common.GeoPoint{Latitude: 47.65, Longitude: -122.35}},
+		{"19:59:30", -time.Minute, &common.GeoPoint{Latitude: 47.65, Longitude: -122.35}},
+		{"19:56:00", 2 * time.Minute, nil},
+		{"21:15:00", 5 * time.Minute, &common.GeoPoint{Latitude: 49.10, Longitude: -124.10}},
+	}
+
+	for _, test := range tests {
+		GpxTimeOffset = test.offset
+		location := TrackLocation(trackTime(test.clock))
+		GpxTimeOffset = 0
+		if !locationEquals(location, test.expected) {
+			t.Errorf("%s with an offset of %s should be %v, not %v", test.clock, test.offset, test.expected, location)
+		}
+	}
+}
+
+func TestTrackLocationForUnknownZone(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	// Only Tokyo's zone is known, so the tracks near Seattle are matched by the indexer's zone
+	zones := `{"type":"FeatureCollection","features":[
+{"type":"Feature","properties":{"tzid":"Asia/Tokyo"},"geometry":{"type":"Polygon","coordinates":[[[129,30],[146,30],[146,46],[129,46],[129,30]]]}}]}`
+	common.TimeZoneBoundaryFilename = path.Join(directory, "timezones.geojson")
+	if err := ioutil.WriteFile(common.TimeZoneBoundaryFilename, []byte(zones), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	useTrackSegments(trackSegment{trackAt("20:00:00", 47.60, -122.30), trackAt("20:01:00", 47.70, -122.40), trackAt("21:00:00", 48.00, -123.00)})
+	defer useTrackSegments()
+
+	tests := []struct {
+		clock    string
+		offset   time.Duration
+		expected *common.GeoPoint
+	}{
+		{"20:00:30", 0, &common.GeoPoint{Latitude: 47.65, Longitude: -122.35}},
+		{"19:57:00", 0, &common.GeoPoint{Latitude: 47.60, Longitude: -122.30}},
+		{"21:03:00", 0, &common.GeoPoint{Latitude: 48.00, Longitude: -123.00}},
+		{"20:30:00", 0, nil},
+		{"21:06:00", 0, nil},
+		{"20:03:30", 3 * time.Minute, &common.GeoPoint{Latitude: 47.65, Longitude: -122.35}},
+	}
+
+	for _, test := range tests {
+		// The camera's clock reads the indexer's local time
+		local := trackTime(test.clock).In(time.Local)
+		GpxTimeOffset = test.offset
+		location := TrackLocationForLocalTime(local)
+		GpxTimeOffset = 0
+		if !locationEquals(location, test.expected) {
+			t.Errorf("%s (%s local) with an offset of %s should be %v, not %v", test.clock, local.Format("15:04:05"),
+				test.offset, test.expected, location)
+		}
+	}
+}
+
+// A point on the day the track tests use, at the UTC clock time
+func trackAt(clock string, latitude, longitude float64) trackPoint {
+	return trackPoint{time: trackTime(clock), latitude: latitude, longitude: longitude}
+}
+
+func trackTime(clock string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04:05", "2017-03-04 "+clock)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func useTrackSegments(segments ...trackSegment) {
+	trackLock.Lock()
+	defer trackLock.Unlock()
+	trackSegments = segments
+}
+
+func locationEquals(location, expected *common.GeoPoint) bool {
+	if location == nil || expected == nil {
+		return location == expected
+	}
+	return floatEquals(location.Latitude, expected.Latitude) && floatEquals(location.Longitude, expected.Longitude)
+}
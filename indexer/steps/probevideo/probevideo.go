@@ -0,0 +1,242 @@
+package probevideo
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/steps/resolveplacename"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+var FfprobeInvocations int64
+var FfprobeFailed int64
+
+// Set by main; when false videos pass through without being probed
+var FfprobeExists bool
+
+type probeRequest struct {
+	media    *common.Media
+	fullPath string
+}
+
+type ffprobeOutput struct {
+	Streams []ffprobeStream `json:"streams"`
+	Format  ffprobeFormat   `json:"format"`
+}
+
+type ffprobeStream struct {
+	CodecType    string            `json:"codec_type"`
+	CodecName    string            `json:"codec_name"`
+	Width        int               `json:"width"`
+	Height       int               `json:"height"`
+	AvgFrameRate string            `json:"avg_frame_rate"` // "30000/1001"
+	RFrameRate   string            `json:"r_frame_rate"`
+	Channels     int               `json:"channels"`
+	Tags         map[string]string `json:"tags"`
+	SideDataList []struct {
+		Rotation float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+type ffprobeFormat struct {
+	FormatName string `json:"format_name"` // "mov,mp4,m4a,3gp,3g2,mj2"
+	Duration   string `json:"duration"`
+	BitRate    string `json:"bit_rate"`
+}
+
+const numConsumers = 4
+
+var queue = make(chan *probeRequest, numConsumers)
+var waitGroup sync.WaitGroup
+
+func Start() {
+	resolveplacename.Start()
+
+	waitGroup.Add(numConsumers)
+	for idx := 0; idx < numConsumers; idx++ {
+		go func() {
+			dequeue()
+			waitGroup.Done()
+		}()
+	}
+}
+
+func Done() {
+	close(queue)
+}
+
+func Wait() {
+	waitGroup.Wait()
+	resolveplacename.Done()
+	resolveplacename.Wait()
+}
+
+func QueueLength() int {
+	return len(queue)
+}
+
+// Every media item goes through this step; only videos are probed
+func Enqueue(media *common.Media, fullPath string) {
+	queue <- &probeRequest{media: media, fullPath: fullPath}
+}
+
+func dequeue() {
+	for request := range queue {
+		if FfprobeExists && request.media.MediaType() == common.MediaTypeVideo {
+			probe(request.media, request.fullPath)
+		}
+		resolveplacename.Enqueue(request.media)
+	}
+}
+
+func probe(media *common.Media, fullPath string) {
+	atomic.AddInt64(&FfprobeInvocations, 1)
+	out, err := exec.Command(common.FfprobePath, "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", fullPath).Output()
+	if err != nil {
+		atomic.AddInt64(&FfprobeFailed, 1)
+		log.Error("Failed executing ffprobe for '%s': %s", fullPath, err.Error())
+		media.Warnings = append(media.Warnings, common.NewWarning(common.WarningFfprobeFailed, fmt.Sprintf("Failed executing ffprobe: %s", err.Error())))
+		return
+	}
+
+	var output ffprobeOutput
+	err = json.Unmarshal(out, &output)
+	if err != nil {
+		atomic.AddInt64(&FfprobeFailed, 1)
+		media.Warnings = append(media.Warnings, common.NewWarning(common.WarningFfprobeFailed, fmt.Sprintf("Unable to parse ffprobe output: %s", err.Error())))
+		return
+	}
+
+	populate(media, &output, fullPath)
+}
+
+func populate(media *common.Media, output *ffprobeOutput, fullPath string) {
+	media.Container = containerName(output.Format.FormatName, fullPath)
+	if bitRate, err := strconv.ParseInt(output.Format.BitRate, 10, 64); err == nil {
+		media.BitRate = bitRate
+	}
+	if media.DurationSeconds == 0 {
+		if duration, err := strconv.ParseFloat(output.Format.Duration, 64); err == nil {
+			media.DurationSeconds = float32(duration)
+		}
+	}
+
+	// The first of each stream type is the one players use by default
+	videoFound, audioFound := false, false
+	for _, stream := range output.Streams {
+		switch stream.CodecType {
+		case "video":
+			if videoFound {
+				continue
+			}
+			videoFound = true
+
+			media.VideoCodec = stream.CodecName
+			media.Rotation = rotation(&stream)
+			if media.Width == 0 || media.Height == 0 {
+				media.Width = stream.Width
+				media.Height = stream.Height
+			}
+			media.VideoResolution = resolutionName(stream.Width, stream.Height)
+
+			frameRate := parseFrameRate(stream.AvgFrameRate)
+			if frameRate == 0 {
+				frameRate = parseFrameRate(stream.RFrameRate)
+			}
+			if frameRate > 0 {
+				media.FrameRate = float32(frameRate)
+				media.FrameRateName = fmt.Sprintf("%d fps", int(math.Floor(frameRate+0.5)))
+			}
+
+		case "audio":
+			if audioFound {
+				continue
+			}
+			audioFound = true
+
+			media.AudioCodec = stream.CodecName
+			media.AudioChannels = stream.Channels
+		}
+	}
+}
+
+// ffprobe gives a list of the formats the demuxer handles; the file's extension picks the right one
+func containerName(formatName, fullPath string) string {
+	names := strings.Split(formatName, ",")
+	extension := strings.ToLower(strings.TrimPrefix(filepath.Ext(fullPath), "."))
+	for _, name := range names {
+		if name == extension {
+			return name
+		}
+	}
+	return names[0]
+}
+
+// Older files have a 'rotate' tag, newer ones a display matrix (rotated the opposite direction).
+// The result is clockwise degrees, 0-359
+func rotation(stream *ffprobeStream) int {
+	degrees := 0
+	if rotate, ok := stream.Tags["rotate"]; ok {
+		degrees, _ = strconv.Atoi(rotate)
+	} else {
+		for _, sideData := range stream.SideDataList {
+			if sideData.Rotation != 0 {
+				degrees = -int(sideData.Rotation)
+				break
+			}
+		}
+	}
+	return ((degrees % 360) + 360) % 360
+}
+
+// Named by the short side, so portrait videos are named the same as landscape
+func resolutionName(width, height int) string {
+	short, long := width, height
+	if short > long {
+		short, long = long, short
+	}
+
+	switch {
+	case short == 0:
+		return ""
+	case short >= 4320 || long >= 7680:
+		return "8K"
+	case short >= 2160 || long >= 3840:
+		return "4K"
+	case short >= 1440:
+		return "1440p"
+	case short >= 1080:
+		return "1080p"
+	case short >= 720:
+		return "720p"
+	default:
+		return "SD"
+	}
+}
+
+// "30000/1001" or "30/1"; "0/0" when unknown
+func parseFrameRate(rate string) float64 {
+	tokens := strings.Split(rate, "/")
+	numerator, err := strconv.ParseFloat(tokens[0], 64)
+	if err != nil {
+		return 0
+	}
+	if len(tokens) < 2 {
+		return numerator
+	}
+
+	denominator, err := strconv.ParseFloat(tokens[1], 64)
+	if err != nil || denominator == 0 {
+		return 0
+	}
+	return numerator / denominator
+}
@@ -0,0 +1,115 @@
+package probevideo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseFrameRate(t *testing.T) {
+	tests := []struct {
+		rate     string
+		expected float64
+	}{
+		{"30/1", 30},
+		{"30000/1001", 29.97},
+		{"24000/1001", 23.976},
+		{"25", 25},
+		{"0/0", 0},
+		{"30/0", 0},
+		{"", 0},
+		{"fast/1", 0},
+		{"30/slow", 0},
+	}
+
+	for _, test := range tests {
+		if rate := parseFrameRate(test.rate); math.Abs(rate-test.expected) > 0.001 {
+			t.Errorf("'%s' should be %f, not %f", test.rate, test.expected, rate)
+		}
+	}
+}
+
+func TestResolutionName(t *testing.T) {
+	tests := []struct {
+		width    int
+		height   int
+		expected string
+	}{
+		{7680, 4320, "8K"},
+		{3840, 2160, "4K"},
+		{2160, 3840, "4K"}, // Portrait
+		{4096, 2160, "4K"},
+		{3840, 1600, "4K"}, // Wider than 16:9
+		{2560, 1440, "1440p"},
+		{1920, 1080, "1080p"},
+		{1080, 1920, "1080p"},
+		{1920, 800, "720p"},
+		{1280, 720, "720p"},
+		{640, 480, "SD"},
+		{0, 0, ""},
+		{1920, 0, ""},
+	}
+
+	for _, test := range tests {
+		if name := resolutionName(test.width, test.height); name != test.expected {
+			t.Errorf("%dx%d should be '%s', not '%s'", test.width, test.height, test.expected, name)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	tests := []struct {
+		name      string
+		rotateTag string
+		sideData  []float64
+		expected  int
+	}{
+		{"none", "", nil, 0},
+		{"tag", "90", nil, 90},
+		{"tag, full turn", "360", nil, 0},
+		{"tag, negative", "-90", nil, 270},
+		{"tag, not a number", "sideways", nil, 0},
+		{"display matrix", "", []float64{-90}, 90},
+		{"display matrix, counterclockwise", "", []float64{90}, 270},
+		{"display matrix, upside down", "", []float64{180}, 180},
+		{"display matrix, first rotation", "", []float64{0, -270}, 270},
+		{"tag before display matrix", "180", []float64{-90}, 180},
+	}
+
+	for _, test := range tests {
+		stream := &ffprobeStream{Tags: map[string]string{}}
+		if test.rotateTag != "" {
+			stream.Tags["rotate"] = test.rotateTag
+		}
+		for _, degrees := range test.sideData {
+			stream.SideDataList = append(stream.SideDataList, struct {
+				Rotation float64 `json:"rotation"`
+			}{degrees})
+		}
+
+		if degrees := rotation(stream); degrees != test.expected {
+			t.Errorf("%s: should be %d, not %d", test.name, test.expected, degrees)
+		}
+	}
+}
+
+func TestContainerName(t *testing.T) {
+	tests := []struct {
+		formatName string
+		fullPath   string
+		expected   string
+	}{
+		{"mov,mp4,m4a,3gp,3g2,mj2", "/videos/clip.mp4", "mp4"},
+		{"mov,mp4,m4a,3gp,3g2,mj2", "/videos/clip.MOV", "mov"},
+		{"mov,mp4,m4a,3gp,3g2,mj2", "/videos/clip.3gp", "3gp"},
+		{"mov,mp4,m4a,3gp,3g2,mj2", "/videos/clip.m4v", "mov"},
+		{"mov,mp4,m4a,3gp,3g2,mj2", "/videos/clip", "mov"},
+		{"avi", "/videos/clip.avi", "avi"},
+		{"mpegts", "/videos/clip.mts", "mpegts"},
+	}
+
+	for _, test := range tests {
+		if name := containerName(test.formatName, test.fullPath); name != test.expected {
+			t.Errorf("'%s' for %s should be '%s', not '%s'", test.formatName, test.fullPath, test.expected, name)
+		}
+	}
+}
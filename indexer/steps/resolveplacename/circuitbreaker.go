@@ -0,0 +1,66 @@
+package resolveplacename
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+var BreakerOpened int64
+
+// After this many lookups in a row fail, lookups are paused for BreakerPause
+var BreakerThreshold = 5
+var BreakerPause = time.Minute
+
+// Stops calling a service that's clearly down. Once the pause is over the breaker is half-open:
+// a single lookup is let through as a probe, the others are turned away until it succeeds. If it
+// fails, the breaker opens again right away
+type circuitBreaker struct {
+	lock                sync.Mutex
+	consecutiveFailures int
+	open                bool // Open or half-open; lookups resume once it closes
+	openUntil           time.Time
+	probing             bool
+}
+
+// Returns false while the breaker is open, or half-open with the probe in progress. A lookup
+// that's allowed must report how it went, with 'succeeded' or 'failed'
+func (cb *circuitBreaker) allow() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if !cb.open {
+		return true
+	}
+	if cb.probing || time.Now().Before(cb.openUntil) {
+		return false
+	}
+	cb.probing = true
+	return true
+}
+
+func (cb *circuitBreaker) succeeded() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.consecutiveFailures = 0
+	if cb.open {
+		cb.open = false
+		cb.probing = false
+		log.Info("Location lookups have resumed")
+		signalBreakerClosed()
+	}
+}
+
+func (cb *circuitBreaker) failed() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.consecutiveFailures++
+	if cb.probing || (!cb.open && cb.consecutiveFailures >= BreakerThreshold) {
+		atomic.AddInt64(&BreakerOpened, 1)
+		cb.open = true
+		cb.probing = false
+		cb.openUntil = time.Now().Add(BreakerPause)
+		log.Warn("Location lookups are paused for %s after %d failures in a row", BreakerPause, cb.consecutiveFailures)
+	}
+}
@@ -0,0 +1,133 @@
+package resolveplacename
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/steps/indexmedia"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+var LookupsDeferred int64
+var DeferredResolved int64
+
+// How often media waiting on the lookup service is tried again
+var DeferredRetryInterval = 30 * time.Second
+
+// Once this much media is waiting, more is indexed with a warning rather than held in memory
+var MaxDeferredLookups = 10000
+
+// Media whose lookup failed because the service is unavailable waits here, rather than being
+// indexed without a placename
+var deferredMedia []*common.Media
+var deferredLock sync.Mutex
+var deferredFull bool
+var stopRetrying chan bool
+var retryWaitGroup sync.WaitGroup
+
+// The waiting media is tried again as soon as the service is back, rather than at the next interval
+var breakerClosed = make(chan bool, 1)
+
+func DeferredCount() int {
+	deferredLock.Lock()
+	defer deferredLock.Unlock()
+	return len(deferredMedia)
+}
+
+func deferLookup(media *common.Media) {
+	deferredLock.Lock()
+	full := len(deferredMedia) >= MaxDeferredLookups
+	if full && !deferredFull {
+		log.Warn("%d media are waiting on the location lookup service, more is indexed without a placename", len(deferredMedia))
+	}
+	deferredFull = full
+	if !full {
+		deferredMedia = append(deferredMedia, media)
+	}
+	deferredLock.Unlock()
+
+	if full {
+		addUnavailableWarning(media)
+		indexmedia.Enqueue(media)
+		return
+	}
+	atomic.AddInt64(&LookupsDeferred, 1)
+}
+
+func signalBreakerClosed() {
+	select {
+	case breakerClosed <- true:
+	default:
+	}
+}
+
+func startRetrying() {
+	stopRetrying = make(chan bool)
+	retryWaitGroup.Add(1)
+	go func() {
+		defer retryWaitGroup.Done()
+		ticker := time.NewTicker(DeferredRetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopRetrying:
+				return
+			case <-ticker.C:
+				retryDeferred()
+			case <-breakerClosed:
+				retryDeferred()
+			}
+		}
+	}()
+}
+
+// Tries the deferred lookups again, in the order they were deferred; the first that's still
+// unavailable stops the attempt, so a service that's still down isn't called for each one
+func retryDeferred() {
+	deferredLock.Lock()
+	pending := deferredMedia
+	deferredMedia = nil
+	deferredLock.Unlock()
+
+	for index, media := range pending {
+		status := resolvePlacename(media)
+		if status == placenameDeferred {
+			deferredLock.Lock()
+			deferredMedia = append(pending[index:], deferredMedia...)
+			deferredLock.Unlock()
+			return
+		}
+
+		if status == placenameResolved {
+			atomic.AddInt64(&DeferredResolved, 1)
+		}
+		indexmedia.Enqueue(media)
+	}
+}
+
+// Called once everything has gone through the step. Media that still can't be resolved is
+// indexed with a warning, so resolving the placenames again will pick it up
+func finishRetrying() {
+	close(stopRetrying)
+	retryWaitGroup.Wait()
+	retryDeferred()
+
+	deferredLock.Lock()
+	pending := deferredMedia
+	deferredMedia = nil
+	deferredLock.Unlock()
+
+	for _, media := range pending {
+		addUnavailableWarning(media)
+		indexmedia.Enqueue(media)
+	}
+}
+
+func addUnavailableWarning(media *common.Media) {
+	addWarning(media, common.WarningPlacenameUnavailable, fmt.Sprintf("Failed getting placename (%f, %f): %s",
+		media.Location.Latitude, media.Location.Longitude, ErrLookupUnavailable.Error()))
+}
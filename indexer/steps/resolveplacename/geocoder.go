@@ -0,0 +1,199 @@
+package resolveplacename
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+
+	"github.com/Jeffail/gabs"
+	"github.com/ian-kent/go-log/log"
+)
+
+// The parts of a placename a geocoder resolves a location to; any may be empty
+type Placename struct {
+	CountryCode string `json:"countrycode,omitempty"`
+	CountryName string `json:"countryname,omitempty"`
+	State       string `json:"state,omitempty"`
+	City        string `json:"city,omitempty"`
+	Sites       string `json:"sites,omitempty"` // Comma separated
+}
+
+// Reverse geocodes a location. The error is recorded as a warning on the media, so it should
+// include the location
+type Geocoder interface {
+	Name() string
+	Lookup(latitude, longitude float64) (*Placename, error)
+
+	// Separates the cached placenames of geocoders whose results differ
+	CacheKey() string
+}
+
+// Set by main; when nil, placenames aren't resolved
+var ActiveGeocoder Geocoder
+
+// The codes of the warnings failed lookups leave, so they can be found and cleared when the
+// placename is resolved again
+var PlacenameWarningCodes = []string{
+	common.WarningPlacenameLookupFailed,
+	common.WarningPlacenameUnavailable,
+}
+
+func IsPlacenameWarning(warning common.Warning) bool {
+	for _, code := range PlacenameWarningCodes {
+		if warning.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Returned when the lookup service can't be reached, or keeps failing; the lookup should be
+// tried again later rather than recorded as a failure
+var ErrLookupUnavailable = errors.New("The location lookup service is unavailable")
+
+var LookupRetries int64
+
+// Each request is given up on after LookupTimeout. Network errors and server (5xx) errors are
+// tried up to LookupAttempts times, waiting LookupBackoff before the first retry and twice as
+// long before each one after that
+var LookupTimeout = 10 * time.Second
+var LookupAttempts = 4
+var LookupBackoff = time.Second
+
+// Uses the ReverseNameLookup service
+type httpGeocoder struct {
+	url     string
+	client  *http.Client
+	breaker circuitBreaker
+}
+
+func NewHTTPGeocoder(url string) Geocoder {
+	return &httpGeocoder{url: url, client: &http.Client{Timeout: LookupTimeout}}
+}
+
+func (hg *httpGeocoder) Name() string {
+	return hg.url
+}
+
+// The cache was only for this geocoder at first, so its entries have no key
+func (hg *httpGeocoder) CacheKey() string {
+	return ""
+}
+
+func (hg *httpGeocoder) Lookup(latitude, longitude float64) (*Placename, error) {
+	if !hg.breaker.allow() {
+		return nil, ErrLookupUnavailable
+	}
+
+	// A lookup is counted once, however many of its attempts the server failed
+	serverFailed := false
+	defer func() {
+		if serverFailed {
+			atomic.AddInt64(&ServerErrors, 1)
+		}
+	}()
+
+	url := fmt.Sprintf("%s/api/v1/name?country=true&lat=%f&lon=%f", hg.url, latitude, longitude)
+	backoff := LookupBackoff
+	for attempt := 1; ; attempt++ {
+		body, transient, err := hg.get(url)
+		if _, ok := err.(*serverError); ok {
+			serverFailed = true
+		}
+		if err == nil {
+			hg.breaker.succeeded()
+			return placenameFromText(body, latitude, longitude)
+		}
+
+		if !transient {
+			// The service answered, it just didn't like the request
+			hg.breaker.succeeded()
+			atomic.AddInt64(&Failures, 1)
+			return nil, fmt.Errorf("Failed getting placename (%f, %f): %s", latitude, longitude, err.Error())
+		}
+
+		if attempt >= LookupAttempts {
+			atomic.AddInt64(&FailedLookups, 1)
+			hg.breaker.failed()
+			log.Warn("Failed getting placename (%f, %f) after %d attempts: %s", latitude, longitude, attempt, err.Error())
+			return nil, ErrLookupUnavailable
+		}
+
+		atomic.AddInt64(&LookupRetries, 1)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Returns the response body; on failure, whether the failure is likely to go away on its own
+func (hg *httpGeocoder) get(url string) ([]byte, bool, error) {
+	response, err := hg.client.Get(url)
+	if err != nil {
+		return nil, true, err
+	}
+
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("Failed reading body of response: %s", err.Error())
+	}
+
+	if response.StatusCode >= 500 {
+		return nil, true, &serverError{fmt.Errorf("%s: %s", response.Status, body)}
+	}
+	if response.StatusCode >= 400 {
+		return nil, false, fmt.Errorf("%s: %s", response.Status, body)
+	}
+	return body, false, nil
+}
+
+// A 5xx response
+type serverError struct {
+	error
+}
+
+func placenameFromText(blob []byte, latitude, longitude float64) (*Placename, error) {
+	json, err := gabs.ParseJSON(blob)
+	if err != nil {
+		atomic.AddInt64(&Failures, 1)
+		return nil, fmt.Errorf("Failed deserializing json: %s: ('%s', %f, %f)", err.Error(), blob, latitude, longitude)
+	}
+
+	if !json.Exists("fullDescription") {
+		atomic.AddInt64(&ServerErrors, 1)
+		return nil, fmt.Errorf("Reverse lookup didn't return a description: %v", json)
+	}
+
+	placename := &Placename{}
+	val, ok := json.Path("countryCode").Data().(string)
+	if ok {
+		placename.CountryCode = val
+	}
+	val, ok = json.Path("countryName").Data().(string)
+	if ok {
+		placename.CountryName = val
+	}
+	val, ok = json.Path("state").Data().(string)
+	if ok {
+		placename.State = val
+	}
+	val, ok = json.Path("city").Data().(string)
+	if ok {
+		placename.City = val
+	}
+	sites := []string{}
+	jsonSites, err := json.Search("sites").Children()
+	for _, s := range jsonSites {
+		sites = append(sites, s.Data().(string))
+	}
+	placename.Sites = strings.Join(sites, ", ")
+
+	return placename, nil
+}
@@ -0,0 +1,209 @@
+package resolveplacename
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kevintavog/findaphoto/common"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+// The GeoNames dumps (https://download.geonames.org/export/dump/) are looked for in this order,
+// the first found is used. Smaller files load faster but have fewer cities
+var geoNamesCityFilenames = []string{"cities500.txt", "cities1000.txt", "cities5000.txt", "cities15000.txt"}
+
+const geoNamesAdmin1Filename = "admin1CodesASCII.txt"
+
+// A city further than this isn't used for the city name, though its state & country still are
+var GeoNamesCityRadiusKm = 25.0
+
+// Nothing is returned if the nearest city is further than this
+var GeoNamesMaxDistanceKm = 250.0
+
+type geoNamesCity struct {
+	name        string
+	latitude    float64
+	longitude   float64
+	countryCode string
+	admin1Code  string
+}
+
+// Cities are bucketed into one degree cells
+type geoNamesCell struct {
+	latitude  int
+	longitude int
+}
+
+type geoNamesGeocoder struct {
+	filename    string
+	cells       map[geoNamesCell][]*geoNamesCity
+	admin1Names map[string]string // "US.WA" -> "Washington"
+}
+
+func DefaultGeoNamesDirectory() string {
+	return path.Join(common.LocationCacheDirectory, "geonames")
+}
+
+// Loads a GeoNames cities dump and the admin1 (state) names from the directory
+func NewGeoNamesGeocoder(directory string) (Geocoder, error) {
+	gg := &geoNamesGeocoder{
+		cells:       make(map[geoNamesCell][]*geoNamesCity),
+		admin1Names: make(map[string]string),
+	}
+
+	for _, name := range geoNamesCityFilenames {
+		filename := path.Join(directory, name)
+		if _, err := os.Stat(filename); err == nil {
+			gg.filename = filename
+			break
+		}
+	}
+	if gg.filename == "" {
+		return nil, fmt.Errorf("No GeoNames cities file (%s) in '%s'", strings.Join(geoNamesCityFilenames, ", "), directory)
+	}
+
+	count := 0
+	err := readTabSeparated(gg.filename, func(fields []string) {
+		// geonameid, name, asciiname, alternatenames, latitude, longitude, feature class, feature code,
+		// country code, cc2, admin1 code, ...
+		if len(fields) < 11 {
+			return
+		}
+		latitude, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return
+		}
+		longitude, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return
+		}
+
+		city := &geoNamesCity{
+			name:        fields[1],
+			latitude:    latitude,
+			longitude:   longitude,
+			countryCode: fields[8],
+			admin1Code:  fields[10],
+		}
+		cell := geoNamesCellFor(latitude, longitude)
+		gg.cells[cell] = append(gg.cells[cell], city)
+		count++
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The state names are optional; without them only the city & country are returned
+	admin1Filename := path.Join(directory, geoNamesAdmin1Filename)
+	err = readTabSeparated(admin1Filename, func(fields []string) {
+		if len(fields) >= 2 {
+			gg.admin1Names[fields[0]] = fields[1]
+		}
+	})
+	if err != nil {
+		log.Warn("State names won't be available: %s", err.Error())
+	}
+
+	log.Info("Loaded %d GeoNames cities from %s", count, gg.filename)
+	return gg, nil
+}
+
+func (gg *geoNamesGeocoder) Name() string {
+	return gg.filename
+}
+
+// Only the nearest city is known, so these placenames are coarser than the lookup service's
+func (gg *geoNamesGeocoder) CacheKey() string {
+	return "geonames"
+}
+
+func (gg *geoNamesGeocoder) Lookup(latitude, longitude float64) (*Placename, error) {
+	city, distanceKm := gg.nearestCity(latitude, longitude)
+	if city == nil {
+		atomic.AddInt64(&FailedLookups, 1)
+		return nil, fmt.Errorf("No GeoNames city within %01.0f km (%f, %f)", GeoNamesMaxDistanceKm, latitude, longitude)
+	}
+
+	placename := &Placename{
+		CountryCode: city.countryCode,
+		CountryName: common.ConvertToCountryName(city.countryCode, city.countryCode),
+		State:       gg.admin1Names[city.countryCode+"."+city.admin1Code],
+	}
+	if distanceKm <= GeoNamesCityRadiusKm {
+		placename.City = city.name
+	}
+	return placename, nil
+}
+
+func geoNamesCellFor(latitude, longitude float64) geoNamesCell {
+	return geoNamesCell{latitude: int(math.Floor(latitude)), longitude: int(math.Floor(longitude))}
+}
+
+// Checks the cells in rings around the location, stopping once a ring can't have anything closer
+func (gg *geoNamesGeocoder) nearestCity(latitude, longitude float64) (*geoNamesCity, float64) {
+	center := geoNamesCellFor(latitude, longitude)
+	kmPerLongitudeDegree := 111.32 * math.Max(math.Cos(latitude*math.Pi/180), 0.01)
+	maxRing := int(math.Ceil(GeoNamesMaxDistanceKm/kmPerLongitudeDegree)) + 1
+	if maxRing > 180 {
+		maxRing = 180
+	}
+
+	var nearest *geoNamesCity
+	nearestKm := math.MaxFloat64
+	for ring := 0; ring <= maxRing; ring++ {
+		// Everything in this ring is at least (ring - 1) cells away
+		if nearest != nil && float64(ring-1)*math.Min(111.32, kmPerLongitudeDegree) > nearestKm {
+			break
+		}
+
+		for lat := center.latitude - ring; lat <= center.latitude+ring; lat++ {
+			for lon := center.longitude - ring; lon <= center.longitude+ring; lon++ {
+				if lat != center.latitude-ring && lat != center.latitude+ring &&
+					lon != center.longitude-ring && lon != center.longitude+ring {
+					continue
+				}
+
+				// Wrap around the antimeridian
+				wrappedLon := ((lon+180)%360+360)%360 - 180
+				for _, city := range gg.cells[geoNamesCell{latitude: lat, longitude: wrappedLon}] {
+					km := calcDistance(latitude, longitude, city.latitude, city.longitude) / 1000
+					if km < nearestKm {
+						nearest = city
+						nearestKm = km
+					}
+				}
+			}
+		}
+	}
+
+	if nearestKm > GeoNamesMaxDistanceKm {
+		return nil, 0
+	}
+	return nearest, nearestKm
+}
+
+func readTabSeparated(filename string, handler func(fields []string)) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // Some lines have many alternate names
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		handler(strings.Split(line, "\t"))
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,140 @@
+package resolveplacename
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// geonameid, name, asciiname, alternatenames, latitude, longitude, feature class, feature code,
+// country code, cc2, admin1 code, ...
+var testCities = []string{
+	"# A comment",
+	"5809844\tSeattle\tSeattle\tSeattle,Sijatl\t47.60621\t-122.33207\tP\tPPLA2\tUS\t\tWA\t033",
+	"5812944\tTacoma\tTacoma\t\t47.25288\t-122.44429\tP\tPPLA2\tUS\t\tWA\t053",
+	"2198148\tWaiyevo\tWaiyevo\t\t-16.80000\t179.98333\tP\tPPL\tFJ\t\t03\t",
+	"4034188\tWest of the line\tWest of the line\t\t-17.50000\t-179.90000\tP\tPPL\tFJ\t\t03\t",
+	"1\tToo short\tToo short\t\t10.0\t10.0",
+	"2\tBad latitude\tBad latitude\t\tnorth\t10.0\tP\tPPL\tUS\t\tWA\t",
+}
+
+var testAdmin1 = []string{
+	"US.WA\tWashington\tWashington\t5815135",
+	"FJ.03\tNorthern\tNorthern\t2194370",
+}
+
+func TestGeoNamesLookup(t *testing.T) {
+	geocoder := newTestGeoNames(t, true)
+
+	placename, err := geocoder.Lookup(47.61, -122.33)
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if placename.City != "Seattle" || placename.State != "Washington" || placename.CountryCode != "US" || placename.CountryName != "USA" {
+		t.Fatalf("Wrong placename: %+v", placename)
+	}
+
+	// Beyond GeoNamesCityRadiusKm of the nearest city only its state & country are used
+	placename, err = geocoder.Lookup(47.25, -121.80)
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if placename.City != "" || placename.State != "Washington" || placename.CountryName != "USA" {
+		t.Fatalf("Wrong placename away from a city: %+v", placename)
+	}
+
+	// Beyond GeoNamesMaxDistanceKm nothing is returned
+	if placename, err := geocoder.Lookup(10.0, 10.0); err == nil {
+		t.Fatalf("Expected a failure for a location without cities, got %+v", placename)
+	}
+}
+
+func TestGeoNamesWithoutStates(t *testing.T) {
+	geocoder := newTestGeoNames(t, false)
+
+	placename, err := geocoder.Lookup(47.61, -122.33)
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if placename.City != "Seattle" || placename.State != "" || placename.CountryName != "USA" {
+		t.Fatalf("Wrong placename: %+v", placename)
+	}
+}
+
+func TestGeoNamesMissingCities(t *testing.T) {
+	directory, err := ioutil.TempDir("", "geonames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	if _, err := NewGeoNamesGeocoder(directory); err == nil {
+		t.Fatalf("Expected a failure without a cities file")
+	}
+}
+
+func TestNearestCity(t *testing.T) {
+	geocoder := newTestGeoNames(t, true).(*geoNamesGeocoder)
+
+	checkNearestCity(t, geocoder, 47.60621, -122.33207, "Seattle", 0, 0.01)
+	checkNearestCity(t, geocoder, 47.30, -122.40, "Tacoma", 5, 7)
+
+	// The nearest city is on the other side of the antimeridian, both ways
+	checkNearestCity(t, geocoder, -16.80, -179.99, "Waiyevo", 2, 4)
+	checkNearestCity(t, geocoder, -17.50, 179.95, "West of the line", 15, 17)
+
+	if city, _ := geocoder.nearestCity(10.0, 10.0); city != nil {
+		t.Fatalf("Expected no city, got %s", city.name)
+	}
+	if city, _ := geocoder.nearestCity(0, 0); city != nil {
+		t.Fatalf("Expected no city, got %s", city.name)
+	}
+}
+
+func checkNearestCity(t *testing.T, geocoder *geoNamesGeocoder, latitude, longitude float64, name string, minKm, maxKm float64) {
+	city, km := geocoder.nearestCity(latitude, longitude)
+	if city == nil {
+		t.Fatalf("No city near %f, %f, expected %s", latitude, longitude, name)
+	}
+	if city.name != name || km < minKm || km > maxKm {
+		t.Fatalf("Near %f, %f: %s at %f km, expected %s within %f-%f km", latitude, longitude, city.name, km, name, minKm, maxKm)
+	}
+}
+
+func newTestGeoNames(t *testing.T, withStates bool) Geocoder {
+	directory, err := ioutil.TempDir("", "geonames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	writeLines(t, path.Join(directory, "cities1000.txt"), testCities)
+	if withStates {
+		writeLines(t, path.Join(directory, geoNamesAdmin1Filename), testAdmin1)
+	}
+
+	geocoder, err := NewGeoNamesGeocoder(directory)
+	if err != nil {
+		t.Fatalf("Loading GeoNames failed: %s", err)
+	}
+	if count := countCities(geocoder.(*geoNamesGeocoder)); count != 4 {
+		t.Fatalf("Wrong number of cities loaded: %d", count)
+	}
+	return geocoder
+}
+
+func countCities(geocoder *geoNamesGeocoder) int {
+	count := 0
+	for _, cities := range geocoder.cells {
+		count += len(cities)
+	}
+	return count
+}
+
+func writeLines(t *testing.T, filename string, lines []string) {
+	if err := ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,195 @@
+package resolveplacename
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/kevintavog/findaphoto/common"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+var CacheHits int64
+var CacheMisses int64
+
+// A lookup within this distance of a previous lookup reuses its placename
+var CacheRadiusMeters = 50.0
+
+// When set, every location is looked up; the results are still cached
+var BypassCache bool
+
+const locationCacheFilename = "placename-cache.jsonl"
+
+// The cache is bucketed into cells of this size, so only nearby entries are compared
+const cacheCellDegrees = 0.01
+const metersPerDegree = 111320.0
+
+// Stored one per line, so new entries are appended rather than rewriting the file
+type cachedPlacename struct {
+	Geocoder  string  `json:"geocoder,omitempty"` // The CacheKey of the geocoder that resolved it
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	Placename
+}
+
+// Each geocoder has its own cells; a placename is only reused for the geocoder that resolved it
+type cacheCell struct {
+	geocoder  string
+	latitude  int
+	longitude int
+}
+
+var cacheCells = make(map[cacheCell][]*cachedPlacename)
+var cacheLock sync.RWMutex
+var cacheLoadOnce sync.Once
+
+// Held while looking up a location in the cell, counting those waiting so it can be removed
+type cellLookupLock struct {
+	sync.Mutex
+	users int
+}
+
+var cellLookupLocks = make(map[cacheCell]*cellLookupLock)
+var cellLookupLocksLock sync.Mutex
+
+func locationCachePath() string {
+	return path.Join(common.LocationCacheDirectory, locationCacheFilename)
+}
+
+func cellFor(geocoder string, latitude, longitude float64) cacheCell {
+	return cacheCell{
+		geocoder:  geocoder,
+		latitude:  int(math.Floor(latitude / cacheCellDegrees)),
+		longitude: int(math.Floor(longitude / cacheCellDegrees)),
+	}
+}
+
+// Returns the closest placename cached for the geocoder within CacheRadiusMeters, and how far away it is
+func findCachedPlacename(geocoder string, latitude, longitude float64) (*cachedPlacename, float64) {
+	cacheLoadOnce.Do(loadLocationCache)
+
+	cacheLock.RLock()
+	defer cacheLock.RUnlock()
+
+	// Degrees of longitude shrink towards the poles, more cells need to be checked there
+	latitudeSpan := int(math.Ceil(CacheRadiusMeters / metersPerDegree / cacheCellDegrees))
+	longitudeSpan := latitudeSpan * 100
+	if cos := math.Cos(latitude * math.Pi / 180); cos > 0.01 {
+		longitudeSpan = int(math.Ceil(float64(latitudeSpan) / cos))
+	}
+
+	center := cellFor(geocoder, latitude, longitude)
+	var closest *cachedPlacename
+	closestDistance := math.MaxFloat64
+	for lat := center.latitude - latitudeSpan; lat <= center.latitude+latitudeSpan; lat++ {
+		for lon := center.longitude - longitudeSpan; lon <= center.longitude+longitudeSpan; lon++ {
+			for _, cp := range cacheCells[cacheCell{geocoder: geocoder, latitude: lat, longitude: lon}] {
+				distance := calcDistance(latitude, longitude, cp.Latitude, cp.Longitude)
+				if distance <= CacheRadiusMeters && distance < closestDistance {
+					closest = cp
+					closestDistance = distance
+				}
+			}
+		}
+	}
+
+	return closest, closestDistance
+}
+
+// A dry run keeps the placename in memory only
+func addCachedPlacename(cp *cachedPlacename) {
+	cacheLoadOnce.Do(loadLocationCache)
+
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	cell := cellFor(cp.Geocoder, cp.Latitude, cp.Longitude)
+	cacheCells[cell] = append(cacheCells[cell], cp)
+	if common.IndexMakeNoChanges {
+		return
+	}
+
+	file, err := os.OpenFile(locationCachePath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Warn("Unable to open the placename cache '%s': %s", locationCachePath(), err.Error())
+		return
+	}
+	defer file.Close()
+
+	line, err := json.Marshal(cp)
+	if err == nil {
+		_, err = file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		log.Warn("Unable to write to the placename cache '%s': %s", locationCachePath(), err.Error())
+	}
+}
+
+// Waits for any lookup in the location's cell to finish. Returns the function that unlocks the cell
+func lockCacheCell(geocoder string, latitude, longitude float64) func() {
+	cell := cellFor(geocoder, latitude, longitude)
+
+	cellLookupLocksLock.Lock()
+	lock, ok := cellLookupLocks[cell]
+	if !ok {
+		lock = &cellLookupLock{}
+		cellLookupLocks[cell] = lock
+	}
+	lock.users++
+	cellLookupLocksLock.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		cellLookupLocksLock.Lock()
+		defer cellLookupLocksLock.Unlock()
+		lock.users--
+		if lock.users == 0 {
+			delete(cellLookupLocks, cell)
+		}
+	}
+}
+
+// The CacheKey of the active geocoder
+func activeCacheKey() string {
+	if ActiveGeocoder == nil {
+		return ""
+	}
+	return ActiveGeocoder.CacheKey()
+}
+
+func loadLocationCache() {
+	file, err := os.Open(locationCachePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Unable to open the placename cache '%s': %s", locationCachePath(), err.Error())
+		}
+		return
+	}
+	defer file.Close()
+
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		cp := &cachedPlacename{}
+		if err := json.Unmarshal(scanner.Bytes(), cp); err != nil {
+			// Most likely a partially written line from an interrupted run
+			continue
+		}
+		cell := cellFor(cp.Geocoder, cp.Latitude, cp.Longitude)
+		cacheCells[cell] = append(cacheCells[cell], cp)
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		log.Warn("Failed reading the placename cache '%s': %s", locationCachePath(), err.Error())
+	}
+	log.Info("Loaded %d cached placenames from %s", count, locationCachePath())
+}
@@ -0,0 +1,159 @@
+package resolveplacename
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+)
+
+// Seattle; 0.0001 degrees of latitude is about 11 meters
+const cacheTestLatitude = 47.6062
+const cacheTestLongitude = -122.3321
+
+func TestCacheHitWithinRadius(t *testing.T) {
+	defer useTestCache(t, 50)()
+	addCachedPlacename(&cachedPlacename{Latitude: cacheTestLatitude, Longitude: cacheTestLongitude, Placename: Placename{City: "Seattle"}})
+
+	cached, distance := findCachedPlacename("", cacheTestLatitude+0.0003, cacheTestLongitude)
+	if cached == nil || cached.City != "Seattle" {
+		t.Fatalf("Expected a hit 33 meters away, got %v", cached)
+	}
+	if distance < 30 || distance > 36 {
+		t.Fatalf("Wrong distance: %f", distance)
+	}
+
+	if cached, distance := findCachedPlacename("", cacheTestLatitude+0.0006, cacheTestLongitude); cached != nil {
+		t.Fatalf("Expected a miss 67 meters away, got %v at %f meters", cached, distance)
+	}
+
+	CacheRadiusMeters = 100
+	if cached, _ := findCachedPlacename("", cacheTestLatitude+0.0006, cacheTestLongitude); cached == nil {
+		t.Fatalf("Expected a hit 67 meters away, with a 100 meter radius")
+	}
+}
+
+func TestCacheClosestHit(t *testing.T) {
+	defer useTestCache(t, 50)()
+	addCachedPlacename(&cachedPlacename{Latitude: cacheTestLatitude, Longitude: cacheTestLongitude, Placename: Placename{Sites: "first"}})
+	addCachedPlacename(&cachedPlacename{Latitude: cacheTestLatitude + 0.0003, Longitude: cacheTestLongitude, Placename: Placename{Sites: "second"}})
+
+	cached, _ := findCachedPlacename("", cacheTestLatitude+0.0002, cacheTestLongitude)
+	if cached == nil || cached.Sites != "second" {
+		t.Fatalf("Expected the closest placename, got %v", cached)
+	}
+}
+
+func TestCacheAcrossCells(t *testing.T) {
+	defer useTestCache(t, 50)()
+
+	// Cells are 0.01 degrees; these are 22 meters apart, on either side of a cell boundary
+	addCachedPlacename(&cachedPlacename{Latitude: 47.6099, Longitude: cacheTestLongitude, Placename: Placename{City: "Seattle"}})
+	if cached, _ := findCachedPlacename("", 47.6101, cacheTestLongitude); cached == nil {
+		t.Fatalf("Expected a hit in the neighboring cell")
+	}
+}
+
+func TestCacheByGeocoder(t *testing.T) {
+	defer useTestCache(t, 50)()
+	addCachedPlacename(&cachedPlacename{Geocoder: "geonames", Latitude: cacheTestLatitude, Longitude: cacheTestLongitude})
+
+	if cached, _ := findCachedPlacename("", cacheTestLatitude, cacheTestLongitude); cached != nil {
+		t.Fatalf("A placename cached for another geocoder shouldn't be used")
+	}
+	if cached, _ := findCachedPlacename("geonames", cacheTestLatitude, cacheTestLongitude); cached == nil {
+		t.Fatalf("Expected a hit for the same geocoder")
+	}
+}
+
+func TestCachePersisted(t *testing.T) {
+	defer useTestCache(t, 50)()
+	addCachedPlacename(&cachedPlacename{Latitude: cacheTestLatitude, Longitude: cacheTestLongitude, Placename: Placename{City: "Seattle"}})
+
+	resetCache()
+	if cached, _ := findCachedPlacename("", cacheTestLatitude, cacheTestLongitude); cached == nil || cached.City != "Seattle" {
+		t.Fatalf("Expected the placename to be loaded from the cache file, got %v", cached)
+	}
+}
+
+func TestCacheNotPersistedOnDryRun(t *testing.T) {
+	defer useTestCache(t, 50)()
+	common.IndexMakeNoChanges = true
+	defer func() { common.IndexMakeNoChanges = false }()
+
+	addCachedPlacename(&cachedPlacename{Latitude: cacheTestLatitude, Longitude: cacheTestLongitude})
+	if cached, _ := findCachedPlacename("", cacheTestLatitude, cacheTestLongitude); cached == nil {
+		t.Fatalf("Expected a hit during the dry run")
+	}
+	if _, err := os.Stat(locationCachePath()); !os.IsNotExist(err) {
+		t.Fatalf("The cache file shouldn't be written on a dry run: %v", err)
+	}
+}
+
+// A geocoder that takes a while, counting its lookups
+type countingGeocoder struct {
+	lookups int64
+}
+
+func (cg *countingGeocoder) Name() string     { return "counting" }
+func (cg *countingGeocoder) CacheKey() string { return "counting" }
+
+func (cg *countingGeocoder) Lookup(latitude, longitude float64) (*Placename, error) {
+	atomic.AddInt64(&cg.lookups, 1)
+	time.Sleep(50 * time.Millisecond)
+	return &Placename{City: "Seattle"}, nil
+}
+
+func TestSingleLookupPerCell(t *testing.T) {
+	defer useTestCache(t, 50)()
+	geocoder := &countingGeocoder{}
+	ActiveGeocoder = geocoder
+	defer func() { ActiveGeocoder = nil }()
+
+	var waitGroup sync.WaitGroup
+	for index := 0; index < numConsumers; index++ {
+		waitGroup.Add(1)
+		go func(index int) {
+			defer waitGroup.Done()
+			media := &common.Media{Location: &common.GeoPoint{Latitude: cacheTestLatitude + float64(index)*0.00001, Longitude: cacheTestLongitude}}
+			if resolvePlacename(media) != placenameResolved || media.LocationCityName != "Seattle" {
+				t.Errorf("Placename wasn't resolved: %v", media)
+			}
+		}(index)
+	}
+	waitGroup.Wait()
+
+	if geocoder.lookups != 1 {
+		t.Fatalf("Expected a single lookup, there were %d", geocoder.lookups)
+	}
+	if len(cellLookupLocks) != 0 {
+		t.Fatalf("The cell locks weren't removed: %d", len(cellLookupLocks))
+	}
+}
+
+// Returns the function that restores the cache
+func useTestCache(t *testing.T, radiusMeters float64) func() {
+	directory, err := ioutil.TempDir("", "placenames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousDirectory, previousRadius := common.LocationCacheDirectory, CacheRadiusMeters
+	common.LocationCacheDirectory = directory
+	CacheRadiusMeters = radiusMeters
+	resetCache()
+
+	return func() {
+		os.RemoveAll(directory)
+		common.LocationCacheDirectory, CacheRadiusMeters = previousDirectory, previousRadius
+		resetCache()
+	}
+}
+
+func resetCache() {
+	cacheCells = make(map[cacheCell][]*cachedPlacename)
+	cacheLoadOnce = sync.Once{}
+}
@@ -2,9 +2,7 @@ package resolveplacename
 
 import (
 	"fmt"
-	"io/ioutil"
 	"math"
-	"net/http"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -12,7 +10,6 @@ import (
 	"github.com/kevintavog/findaphoto/common"
 	"github.com/kevintavog/findaphoto/indexer/steps/indexmedia"
 
-	"github.com/Jeffail/gabs"
 	"github.com/ian-kent/go-log/log"
 )
 
@@ -21,8 +18,6 @@ var FailedLookups int64
 var Failures int64
 var ServerErrors int64
 
-var LocationLookupUrl = ""
-
 const numConsumers = 8
 
 var queue = make(chan *common.Media, numConsumers)
@@ -30,6 +25,7 @@ var waitGroup sync.WaitGroup
 
 func Start() {
 	indexmedia.Start()
+	startRetrying()
 
 	waitGroup.Add(numConsumers)
 	for idx := 0; idx < numConsumers; idx++ {
@@ -46,95 +42,119 @@ func Done() {
 
 func Wait() {
 	waitGroup.Wait()
+	finishRetrying()
 	indexmedia.Done()
 	indexmedia.Wait()
 }
 
+func QueueLength() int {
+	return len(queue)
+}
+
 func Enqueue(media *common.Media) {
 	queue <- media
 }
 
 func dequeue() {
 	for media := range queue {
-		resolvePlacename(media)
+		if resolvePlacename(media) == placenameDeferred {
+			deferLookup(media)
+			continue
+		}
 		indexmedia.Enqueue(media)
 	}
 }
 
-func addWarning(media *common.Media, warning string) {
-	log.Warn("%q - %q; %f, %f", media.Path, warning, media.Location.Latitude, media.Location.Longitude)
-	media.Warnings = append(media.Warnings, warning)
+func addWarning(media *common.Media, code, message string) {
+	log.Warn("%q - %q; %f, %f", media.Path, message, media.Location.Latitude, media.Location.Longitude)
+	media.Warnings = append(media.Warnings, common.NewWarning(code, message,
+		"latitude", fmt.Sprintf("%f", media.Location.Latitude),
+		"longitude", fmt.Sprintf("%f", media.Location.Longitude)))
 }
 
-func resolvePlacename(media *common.Media) {
+// Resolves the placename of media that's already indexed, outside of the pipeline. Returns
+// true if the placename was set
+func Resolve(media *common.Media) bool {
+	switch resolvePlacename(media) {
+	case placenameResolved:
+		return true
+	case placenameDeferred:
+		addUnavailableWarning(media)
+	}
+	return false
+}
+
+const (
+	placenameResolved = iota
+	placenameUnresolved
+	placenameDeferred // The lookup service is unavailable, the lookup should be tried again later
+)
+
+func resolvePlacename(media *common.Media) int {
 	if media.Location == nil {
-		return
+		return placenameUnresolved
 	}
 	if media.Location.Latitude == 0 && media.Location.Longitude == 0 {
-		return
+		return placenameUnresolved
 	}
 
-	atomic.AddInt64(&PlacenameLookups, 1)
-	url := fmt.Sprintf("%s/api/v1/name?country=true&lat=%f&lon=%f", LocationLookupUrl, media.Location.Latitude, media.Location.Longitude)
-	response, err := http.Get(url)
-	if err != nil {
-		atomic.AddInt64(&FailedLookups, 1)
-		addWarning(media, fmt.Sprintf("Failed getting placename (%f, %f): %s", media.Location.Latitude, media.Location.Longitude, err.Error()))
-		return
-	}
-
-	defer response.Body.Close()
+	cacheKey := activeCacheKey()
+	if !BypassCache {
+		if useCachedPlacename(media, cacheKey) {
+			return placenameResolved
+		}
 
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		atomic.AddInt64(&Failures, 1)
-		addWarning(media, fmt.Sprintf("Failed reading body of response (%f, %f): %s", media.Location.Latitude, media.Location.Longitude, err.Error()))
-		return
+		// Media taken together is looked up once; the others wait, then use the cached placename
+		unlock := lockCacheCell(cacheKey, media.Location.Latitude, media.Location.Longitude)
+		defer unlock()
+		if useCachedPlacename(media, cacheKey) {
+			return placenameResolved
+		}
+		atomic.AddInt64(&CacheMisses, 1)
 	}
 
-	placenameFromText(media, body)
-}
-
-func placenameFromText(media *common.Media, blob []byte) {
-	json, err := gabs.ParseJSON(blob)
-	if err != nil {
-		atomic.AddInt64(&Failures, 1)
-		addWarning(media, fmt.Sprintf(
-			"Failed deserializing json: %s: ('%s', %f, %f in %s)", err.Error(), blob, media.Location.Latitude, media.Location.Longitude, media.Path))
-		return
+	if ActiveGeocoder == nil {
+		return placenameUnresolved
 	}
 
-	if !json.Exists("fullDescription") {
-		atomic.AddInt64(&ServerErrors, 1)
-		addWarning(media, fmt.Sprintf("Reverse lookup didn't return a description: %v", json))
-		return
+	atomic.AddInt64(&PlacenameLookups, 1)
+	placename, err := ActiveGeocoder.Lookup(media.Location.Latitude, media.Location.Longitude)
+	if err == ErrLookupUnavailable {
+		return placenameDeferred
+	}
+	if err != nil {
+		addWarning(media, common.WarningPlacenameLookupFailed, err.Error())
+		return placenameUnresolved
 	}
 
-	atomic.AddInt64(&PlacenameLookups, 1)
+	setPlacename(media, placename)
 	media.CachedLocationDistanceMeters = 0
+	addCachedPlacename(&cachedPlacename{
+		Geocoder:  cacheKey,
+		Latitude:  media.Location.Latitude,
+		Longitude: media.Location.Longitude,
+		Placename: *placename,
+	})
+	return placenameResolved
+}
 
-	val, ok := json.Path("countryCode").Data().(string)
-	if ok {
-		media.LocationCountryCode = val
-	}
-	val, ok = json.Path("countryName").Data().(string)
-	if ok {
-		media.LocationCountryName = val
-	}
-	val, ok = json.Path("state").Data().(string)
-	if ok {
-		media.LocationStateName = val
-	}
-	val, ok = json.Path("city").Data().(string)
-	if ok {
-		media.LocationCityName = val
+func useCachedPlacename(media *common.Media, cacheKey string) bool {
+	cached, distance := findCachedPlacename(cacheKey, media.Location.Latitude, media.Location.Longitude)
+	if cached == nil {
+		return false
 	}
-	sites := []string{}
-	jsonSites, err := json.Search("sites").Children()
-	for _, s := range jsonSites {
-		sites = append(sites, s.Data().(string))
-	}
-	media.LocationSiteName = strings.Join(sites, ", ")
+	atomic.AddInt64(&CacheHits, 1)
+	setPlacename(media, &cached.Placename)
+	media.CachedLocationDistanceMeters = int(distance + 0.5)
+	return true
+}
+
+func setPlacename(media *common.Media, placename *Placename) {
+	media.LocationCountryCode = placename.CountryCode
+	media.LocationCountryName = placename.CountryName
+	media.LocationStateName = placename.State
+	media.LocationCityName = placename.City
+	media.LocationSiteName = placename.Sites
 
 	media.LocationHierarchicalName = joinSkipEmpty(",", media.LocationSiteName, media.LocationCityName, media.LocationStateName, media.LocationCountryName)
 	media.LocationPlaceName = media.LocationHierarchicalName
@@ -0,0 +1,285 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+// Any directory may have an ignore file, with gitignore-style patterns that apply to that directory
+// and everything below it.
+const IgnoreFilename = ".findaphotoignore"
+
+var FilesIgnored int64
+var DirectoriesIgnored int64
+var IgnoredMediaRemoved int64
+
+// Limits the size of the skipped report; the counters include everything
+const maxSkippedReported = 10000
+
+type ignorePattern struct {
+	pattern       string // As written
+	source        string // The ignore file it came from, or 'configuration'
+	directory     string // The directory of the ignore file; empty for the configured patterns (the alias path is used)
+	negate        bool
+	directoryOnly bool
+	anchored      bool // Matched against the path relative to 'directory', rather than the name
+	regex         *regexp.Regexp
+}
+
+type SkippedPath struct {
+	Path      string `json:"path"`
+	Directory bool   `json:"directory"`
+	Pattern   string `json:"pattern"`
+	Source    string `json:"source"`
+}
+
+var excludePatterns []*ignorePattern
+
+// Patterns from the ignore file in each directory, loaded as needed. nil is cached for directories
+// without an ignore file.
+var directoryPatterns = make(map[string][]*ignorePattern)
+var directoryPatternsLock sync.Mutex
+
+var skipped []SkippedPath
+var skippedLock sync.Mutex
+
+// Exclude patterns that apply to every alias path, in addition to the ignore files. Patterns without a
+// '/' match a name anywhere under the alias path, others are relative to the alias path.
+func SetExcludePatterns(patterns []string) {
+	excludePatterns = parseIgnorePatterns(patterns, "", "configuration")
+}
+
+// The skipped files and directories since the last call
+func SkippedReport() []SkippedPath {
+	skippedLock.Lock()
+	defer skippedLock.Unlock()
+
+	report := skipped
+	skipped = make([]SkippedPath, 0)
+	return report
+}
+
+// Ignore files are re-read for each scan, so changes are picked up
+func resetIgnoreFiles() {
+	directoryPatternsLock.Lock()
+	defer directoryPatternsLock.Unlock()
+	directoryPatterns = make(map[string][]*ignorePattern)
+}
+
+func forgetIgnoreFile(directory string) {
+	directoryPatternsLock.Lock()
+	defer directoryPatternsLock.Unlock()
+	delete(directoryPatterns, directory)
+}
+
+// The patterns that apply to the items in 'directory', in the order they're evaluated - the last match wins.
+func patternsFor(rootPath, directory string) []*ignorePattern {
+	patterns := append([]*ignorePattern{}, excludePatterns...)
+
+	rootPath = path.Clean(rootPath)
+	directory = path.Clean(directory)
+	patterns = append(patterns, loadIgnoreFile(rootPath)...)
+	if directory == rootPath {
+		return patterns
+	}
+
+	current := rootPath
+	for _, name := range strings.Split(strings.TrimPrefix(directory, rootPath+"/"), "/") {
+		current = path.Join(current, name)
+		patterns = append(patterns, loadIgnoreFile(current)...)
+	}
+	return patterns
+}
+
+// Returns the matching pattern when the item in the directory the patterns are for is ignored; nil otherwise
+func matchIgnored(patterns []*ignorePattern, rootPath, fullPath string, isDirectory bool) *ignorePattern {
+	var matched *ignorePattern
+	for _, p := range patterns {
+		if p.directoryOnly && !isDirectory {
+			continue
+		}
+
+		var subject string
+		if p.anchored {
+			base := p.directory
+			if base == "" {
+				base = rootPath
+			}
+			base = path.Clean(base)
+			if !strings.HasPrefix(fullPath, base+"/") {
+				continue
+			}
+			subject = fullPath[len(base)+1:]
+		} else {
+			subject = path.Base(fullPath)
+		}
+
+		if p.regex.MatchString(subject) {
+			if p.negate {
+				matched = nil
+			} else {
+				matched = p
+			}
+		}
+	}
+	return matched
+}
+
+// Checks the item and each directory it's in, up to the alias path. Used for items found other than
+// by scanning (which doesn't descend into ignored directories).
+func isIgnoredPath(rootPath, fullPath string, isDirectory bool) bool {
+	rootPath = path.Clean(rootPath)
+	fullPath = path.Clean(fullPath)
+	if !strings.HasPrefix(fullPath, rootPath+"/") {
+		return false
+	}
+
+	current := rootPath
+	names := strings.Split(fullPath[len(rootPath)+1:], "/")
+	for idx, name := range names {
+		itemPath := path.Join(current, name)
+		itemIsDirectory := isDirectory || idx < len(names)-1
+		if matchIgnored(patternsFor(rootPath, current), rootPath, itemPath, itemIsDirectory) != nil {
+			return true
+		}
+		current = itemPath
+	}
+	return false
+}
+
+func recordSkipped(fullPath string, isDirectory bool, p *ignorePattern) {
+	if isDirectory {
+		atomic.AddInt64(&DirectoriesIgnored, 1)
+	} else {
+		atomic.AddInt64(&FilesIgnored, 1)
+	}
+
+	skippedLock.Lock()
+	defer skippedLock.Unlock()
+	if len(skipped) < maxSkippedReported {
+		skipped = append(skipped, SkippedPath{Path: fullPath, Directory: isDirectory, Pattern: p.pattern, Source: p.source})
+	}
+}
+
+func loadIgnoreFile(directory string) []*ignorePattern {
+	directoryPatternsLock.Lock()
+	patterns, loaded := directoryPatterns[directory]
+	directoryPatternsLock.Unlock()
+	if loaded {
+		return patterns
+	}
+
+	filename := path.Join(directory, IgnoreFilename)
+	file, err := os.Open(filename)
+	if err == nil {
+		defer file.Close()
+
+		lines := make([]string, 0)
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			log.Warn("Failed reading '%s': %s", filename, err.Error())
+		}
+		patterns = parseIgnorePatterns(lines, directory, filename)
+	} else if !os.IsNotExist(err) {
+		log.Warn("Failed opening '%s': %s", filename, err.Error())
+	}
+
+	directoryPatternsLock.Lock()
+	directoryPatterns[directory] = patterns
+	directoryPatternsLock.Unlock()
+	return patterns
+}
+
+func parseIgnorePatterns(lines []string, directory, source string) []*ignorePattern {
+	patterns := make([]*ignorePattern, 0)
+	for _, line := range lines {
+		text := strings.TrimSpace(line)
+		if len(text) < 1 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		p := &ignorePattern{pattern: text, source: source, directory: directory}
+		if strings.HasPrefix(text, "!") {
+			p.negate = true
+			text = text[1:]
+		}
+		if strings.HasSuffix(text, "/") {
+			p.directoryOnly = true
+			text = strings.TrimRight(text, "/")
+		}
+		if strings.Contains(text, "/") {
+			p.anchored = true
+			text = strings.TrimPrefix(text, "/")
+		}
+		if len(text) < 1 {
+			continue
+		}
+
+		regex, err := regexp.Compile(globToRegex(text))
+		if err != nil {
+			log.Warn("Ignoring invalid pattern '%s' in %s: %s", p.pattern, source, err.Error())
+			continue
+		}
+		p.regex = regex
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// Converts a gitignore-style glob to a regular expression: '*' and '?' don't match '/',
+// '**' matches across directories and '[...]' is a character class.
+func globToRegex(glob string) string {
+	var sb bytes.Buffer
+	sb.WriteString("^")
+	for idx := 0; idx < len(glob); idx++ {
+		c := glob[idx]
+		switch c {
+		case '*':
+			if idx+1 < len(glob) && glob[idx+1] == '*' {
+				idx++
+				if idx+1 < len(glob) && glob[idx+1] == '/' {
+					idx++
+					sb.WriteString("(.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[idx:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta(string(c)))
+			} else {
+				class := glob[idx+1 : idx+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				sb.WriteString("[" + class + "]")
+				idx += end
+			}
+		case '\\':
+			if idx+1 < len(glob) {
+				idx++
+				sb.WriteString(regexp.QuoteMeta(string(glob[idx])))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
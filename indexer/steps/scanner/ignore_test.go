@@ -0,0 +1,106 @@
+package scanner
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGlobToRegex(t *testing.T) {
+	tests := []struct {
+		glob     string
+		subject  string
+		expected bool
+	}{
+		{"*.tmp", "file.tmp", true},
+		{"*.tmp", "file.tmp.jpg", false},
+		{"*.tmp", "dir/file.tmp", false},
+		{"img?.jpg", "img1.jpg", true},
+		{"img?.jpg", "img12.jpg", false},
+		{"img?.jpg", "img/.jpg", false},
+		{"**/cache", "cache", true},
+		{"**/cache", "a/b/cache", true},
+		{"**/cache", "a/bcache", false},
+		{"raw/**", "raw/a/b.nef", true},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"img[0-9].jpg", "img5.jpg", true},
+		{"img[0-9].jpg", "imgx.jpg", false},
+		{"img[!0-9].jpg", "imgx.jpg", true},
+		{"img[!0-9].jpg", "img5.jpg", false},
+		{"img[0-9.jpg", "img[0-9.jpg", true},
+		{`\*.jpg`, "*.jpg", true},
+		{`\*.jpg`, "a.jpg", false},
+		{`what\?.jpg`, "what?.jpg", true},
+		{`what\?.jpg`, "whatx.jpg", false},
+		{`\[draft].jpg`, "[draft].jpg", true},
+		{"a+b (1).jpg", "a+b (1).jpg", true},
+		{"a+b (1).jpg", "aab 1.jpg", false},
+	}
+
+	for _, test := range tests {
+		regex, err := regexp.Compile(globToRegex(test.glob))
+		if err != nil {
+			t.Errorf("'%s' failed to compile: %s", test.glob, err)
+			continue
+		}
+		if matched := regex.MatchString(test.subject); matched != test.expected {
+			t.Errorf("'%s' matching '%s' should be %t, not %t", test.glob, test.subject, test.expected, matched)
+		}
+	}
+}
+
+func TestMatchIgnored(t *testing.T) {
+	tests := []struct {
+		patterns    []string
+		directory   string // Of the ignore file; empty for the configured patterns
+		path        string
+		isDirectory bool
+		expected    bool
+	}{
+		// Without a '/', the name matches anywhere
+		{[]string{"*.tmp"}, "", "/photos/2017/a.tmp", false, true},
+		{[]string{"*.tmp"}, "", "/photos/2017/a.jpg", false, false},
+
+		// A leading '/' anchors to the alias path, or the directory of the ignore file
+		{[]string{"/foo"}, "", "/photos/foo", true, true},
+		{[]string{"/foo"}, "", "/photos/2017/foo", true, false},
+		{[]string{"/foo"}, "/photos/2017", "/photos/2017/foo", true, true},
+		{[]string{"/foo"}, "/photos/2017", "/photos/foo", true, false},
+		{[]string{"2017/foo"}, "", "/photos/2017/foo", false, true},
+		{[]string{"2017/foo"}, "", "/photos/old/2017/foo", false, false},
+
+		// '**/' matches in any directory, including the top
+		{[]string{"**/cache"}, "", "/photos/cache", true, true},
+		{[]string{"**/cache"}, "", "/photos/2017/trip/cache", true, true},
+		{[]string{"**/cache"}, "", "/photos/2017/trip/cached", true, false},
+
+		// A trailing '/' only matches directories
+		{[]string{"cache/"}, "", "/photos/2017/cache", true, true},
+		{[]string{"cache/"}, "", "/photos/2017/cache", false, false},
+		{[]string{"/cache/"}, "", "/photos/cache", true, true},
+		{[]string{"/cache/"}, "", "/photos/2017/cache", true, false},
+
+		// The last match wins, so a negation re-includes what an earlier pattern ignored
+		{[]string{"*.jpg", "!keep.jpg"}, "", "/photos/keep.jpg", false, false},
+		{[]string{"*.jpg", "!keep.jpg"}, "", "/photos/other.jpg", false, true},
+		{[]string{"!keep.jpg", "*.jpg"}, "", "/photos/keep.jpg", false, true},
+		{[]string{"!keep.jpg"}, "", "/photos/keep.jpg", false, false},
+
+		// Escaped characters are literal
+		{[]string{`\!important.jpg`}, "", "/photos/!important.jpg", false, true},
+		{[]string{`\#notes.txt`}, "", "/photos/#notes.txt", false, true},
+		{[]string{`\*.jpg`}, "", "/photos/a.jpg", false, false},
+
+		// Comments and blank lines are skipped
+		{[]string{"# *.jpg", "", "   "}, "", "/photos/a.jpg", false, false},
+	}
+
+	for _, test := range tests {
+		patterns := parseIgnorePatterns(test.patterns, test.directory, "test")
+		matched := matchIgnored(patterns, "/photos", test.path, test.isDirectory) != nil
+		if matched != test.expected {
+			t.Errorf("%v in '%s' ignoring '%s' (directory: %t) should be %t, not %t",
+				test.patterns, test.directory, test.path, test.isDirectory, test.expected, matched)
+		}
+	}
+}
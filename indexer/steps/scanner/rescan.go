@@ -0,0 +1,81 @@
+package scanner
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/ian-kent/go-log/log"
+)
+
+// Directories to scan again while watching - new or moved directories, and those with changed
+// ignore rules. They're scanned by a single worker, never alongside a full scan, so the watch loop
+// isn't held up while the pipeline is full and the same files aren't queued twice.
+type rescanDirectory struct {
+	basePath string
+	alias    string
+}
+
+var rescanLock sync.Mutex
+var rescanPending = make(map[string]rescanDirectory)
+var rescanSignal = make(chan bool, 1)
+var rescanStopped = make(chan bool)
+
+// Held while scanning, by full scans and the rescan worker
+var scanLock sync.Mutex
+
+func queueRescan(basePath, alias, directory string) {
+	rescanLock.Lock()
+	rescanPending[directory] = rescanDirectory{basePath: basePath, alias: alias}
+	rescanLock.Unlock()
+
+	select {
+	case rescanSignal <- true:
+	default:
+	}
+}
+
+// Runs until the scanner is stopped, dropping whatever is pending - it's found by the next full scan
+func rescanWorker() {
+	defer close(rescanStopped)
+	for range rescanSignal {
+		if Stopped() {
+			return
+		}
+
+		rescanLock.Lock()
+		directories := rescanPending
+		rescanPending = make(map[string]rescanDirectory)
+		rescanLock.Unlock()
+
+		scanLock.Lock()
+		for directory, rd := range directories {
+			if Stopped() {
+				break
+			}
+			if hasPendingParent(directories, directory) {
+				continue
+			}
+			log.Info("Scanning '%s' for changes", directory)
+			scan(rd.basePath, rd.alias, directory)
+		}
+		scanLock.Unlock()
+	}
+}
+
+// A directory within another being scanned is covered by that scan
+func hasPendingParent(directories map[string]rescanDirectory, directory string) bool {
+	for other := range directories {
+		if strings.HasPrefix(directory, strings.TrimSuffix(other, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func stopRescanWorker() {
+	select {
+	case rescanSignal <- true:
+	default:
+	}
+	<-rescanStopped
+}
@@ -4,22 +4,26 @@ import (
 	"encoding/json"
 	"io"
 	"os"
+	"strings"
+	"sync/atomic"
 
 	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/helpers"
 
 	"github.com/ian-kent/go-log/log"
 	"golang.org/x/net/context"
+	"gopkg.in/olivere/elastic.v5"
 )
 
 var MediaScanned int64
 var MediaRemoved int64
 
-// Walk through the index, removing any items no longer on the file system
+// Walk through the index, removing any items no longer on the file system or now ignored
 func RemoveFiles() {
 	client := common.CreateClient()
 
 	scrollService := client.Scroll(common.MediaIndexName).Type(common.MediaTypeName).Size(100)
-	for {
+	for !Stopped() {
 		results, err := scrollService.Do(context.TODO())
 		if err == io.EOF {
 			break
@@ -37,7 +41,7 @@ func RemoveFiles() {
 				continue
 			}
 
-			MediaScanned++
+			atomic.AddInt64(&MediaScanned, 1)
 			removeDocument := false
 			if !common.IsValidAliasedPath(media.Path) {
 				removeDocument = true
@@ -50,26 +54,45 @@ func RemoveFiles() {
 
 				if _, err = os.Stat(fullPath); os.IsNotExist(err) {
 					removeDocument = true
+				} else if isIgnoredMedia(media.Path, fullPath) {
+					atomic.AddInt64(&IgnoredMediaRemoved, 1)
+					removeDocument = true
 				}
 			}
 
 			if removeDocument {
-				MediaRemoved++
-				if common.IndexMakeNoChanges {
-					log.Info("WOULD remove %v", media.Path)
-				} else {
-					deleteResponse, err := client.Delete().
-						Index(common.MediaIndexName).
-						Type(common.MediaTypeName).
-						Id(media.Path).
-						Do(context.TODO())
-					if err != nil {
-						log.Error("Failed removing document '%s' from index: %s", media.Path, err.Error())
-					} else if deleteResponse.Found != true {
-						log.Error("Delete of document '%s' failed", media.Path)
-					}
-				}
+				removeFromIndex(client, media.Path)
 			}
 		}
 	}
 }
+
+func removeFromIndex(client *elastic.Client, aliasedPath string) {
+	atomic.AddInt64(&MediaRemoved, 1)
+	if common.IndexMakeNoChanges {
+		log.Info("WOULD remove %v", aliasedPath)
+		helpers.DryRunRemoved(aliasedPath)
+		return
+	}
+
+	deleteResponse, err := client.Delete().
+		Index(common.MediaIndexName).
+		Type(common.MediaTypeName).
+		Id(aliasedPath).
+		Do(context.TODO())
+	if err != nil {
+		log.Error("Failed removing document '%s' from index: %s", aliasedPath, err.Error())
+	} else if deleteResponse.Found != true {
+		log.Error("Delete of document '%s' failed", aliasedPath)
+	}
+}
+
+func isIgnoredMedia(aliasedPath, fullPath string) bool {
+	alias := strings.SplitN(aliasedPath, "\\", 2)[0]
+	rootPath, err := common.PathForAlias(alias)
+	if err != nil {
+		log.Error("Unable to get the path for alias %s: %s", alias, err.Error())
+		return false
+	}
+	return isIgnoredPath(rootPath, fullPath, false)
+}
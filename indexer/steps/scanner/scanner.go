@@ -2,10 +2,14 @@ package scanner
 
 import (
 	"io/ioutil"
+	"os"
 	"path"
 	"strings"
 	"sync"
+	"sync/atomic"
 
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/helpers"
 	"github.com/kevintavog/findaphoto/indexer/steps/checkindex"
 
 	"github.com/ian-kent/go-log/log"
@@ -14,21 +18,39 @@ import (
 var FilesScanned int64
 var SupportedFilesFound int64
 var DirectoriesScanned int64
+var FilesResumed int64 // Skipped, their directory was completed by an earlier, interrupted, run
 
-var supportedFileExtensions = map[string]bool{
-	".BMP":  true,
-	".GIF":  true,
-	".JPEG": true,
-	".JPG":  true,
-	".M4V":  true,
-	".MP4":  true,
-	".PNG":  true,
-	".TIF":  true,
-	".TIFF": true,
+var scanCompleted int32
+var stopRequested int32
+
+var supportedFileExtensions = defaultSupportedExtensions()
+
+func defaultSupportedExtensions() map[string]bool {
+	extensions := make(map[string]bool)
+	for ext := range common.DefaultSupportedExtensions {
+		extensions[ext] = true
+	}
+	return extensions
+}
+
+// Replace the default list of extensions to index; each may be given with or without the leading '.'
+func SetSupportedExtensions(extensions []string) {
+	supportedFileExtensions = make(map[string]bool)
+	for _, ext := range extensions {
+		ext = strings.ToUpper(strings.TrimSpace(ext))
+		if len(ext) < 1 {
+			continue
+		}
+		if ext[0] != '.' {
+			ext = "." + ext
+		}
+		supportedFileExtensions[ext] = true
+	}
 }
 
 func Scan(scanPath, alias string) {
 	checkindex.Start()
+	resetIgnoreFiles()
 
 	var waitGroup sync.WaitGroup
 	waitGroup.Add(1)
@@ -40,6 +62,7 @@ func Scan(scanPath, alias string) {
 	go func() {
 		scan(scanPath, alias, scanPath)
 		log.Debug("scan completed")
+		atomic.StoreInt32(&scanCompleted, 1)
 		checkindex.Done()
 	}()
 
@@ -47,6 +70,20 @@ func Scan(scanPath, alias string) {
 	checkindex.Wait()
 }
 
+// Stop looking for files; the files already queued are still processed
+func Stop() {
+	atomic.StoreInt32(&stopRequested, 1)
+}
+
+func Stopped() bool {
+	return atomic.LoadInt32(&stopRequested) != 0
+}
+
+// True once all files have been found and queued; the file counts are then final
+func ScanCompleted() bool {
+	return atomic.LoadInt32(&scanCompleted) != 0
+}
+
 func scan(basePath, alias, scanPath string) {
 	dirReader, err := ioutil.ReadDir(scanPath)
 	if err != nil {
@@ -55,31 +92,81 @@ func scan(basePath, alias, scanPath string) {
 	}
 
 	subDirectories := []string{}
+	patterns := patternsFor(basePath, scanPath)
+	aliasedDirectory := toAliasedPath(basePath, alias, scanPath)
+	directoryCompleted := helpers.IsDirectoryCompleted(aliasedDirectory)
+	sidecars := sidecarsIn(dirReader)
 
-	baseLength := len(basePath)
 	for _, fileInfo := range dirReader {
+		if Stopped() {
+			return
+		}
+
+		fullPath := path.Join(scanPath, fileInfo.Name())
 		if fileInfo.IsDir() {
-			DirectoriesScanned += 1
+			if p := matchIgnored(patterns, basePath, fullPath, true); p != nil {
+				recordSkipped(fullPath, true, p)
+				continue
+			}
+			atomic.AddInt64(&DirectoriesScanned, 1)
 			subDirectories = append(subDirectories, fileInfo.Name())
 		} else {
-			FilesScanned += 1
-			var ok bool
-			if _, ok = supportedFileExtensions[strings.ToUpper(path.Ext(fileInfo.Name()))]; ok {
-				SupportedFilesFound += 1
-				fullPath := path.Join(scanPath, fileInfo.Name())
-
-				relativePath := fullPath[baseLength:len(fullPath)]
-				if len(relativePath) > 0 && relativePath[0] == '/' {
-					relativePath = relativePath[1:len(relativePath)]
+			atomic.AddInt64(&FilesScanned, 1)
+			if isSupportedFile(fileInfo.Name()) {
+				if p := matchIgnored(patterns, basePath, fullPath, false); p != nil {
+					recordSkipped(fullPath, false, p)
+					continue
 				}
-				aliasedPath := strings.Replace(path.Join(alias, relativePath), "/", "\\", -1)
-
-				checkindex.Enqueue(fullPath, aliasedPath, fileInfo.Size())
+				if directoryCompleted {
+					atomic.AddInt64(&FilesResumed, 1)
+					continue
+				}
+				atomic.AddInt64(&SupportedFilesFound, 1)
+				aliasedPath := toAliasedPath(basePath, alias, fullPath)
+				helpers.CheckpointFileQueued(aliasedPath)
+				checkindex.Enqueue(fullPath, aliasedPath, fileInfo.Size(), pairedSidecar(scanPath, fileInfo.Name(), sidecars))
 			}
 		}
 	}
 
+	if !directoryCompleted {
+		helpers.CheckpointDirectoryScanned(aliasedDirectory)
+	}
+
 	for _, directory := range subDirectories {
 		scan(basePath, alias, path.Join(scanPath, directory))
 	}
 }
+
+// The sidecars in a directory, by upper case name
+func sidecarsIn(files []os.FileInfo) map[string]string {
+	sidecars := make(map[string]string)
+	for _, fileInfo := range files {
+		if !fileInfo.IsDir() && common.IsSidecar(fileInfo.Name()) {
+			sidecars[strings.ToUpper(fileInfo.Name())] = fileInfo.Name()
+		}
+	}
+	return sidecars
+}
+
+func pairedSidecar(directory, mediaFilename string, sidecars map[string]string) string {
+	for _, name := range common.SidecarNames(mediaFilename) {
+		if sidecar, ok := sidecars[name]; ok {
+			return path.Join(directory, sidecar)
+		}
+	}
+	return ""
+}
+
+func isSupportedFile(filename string) bool {
+	_, ok := supportedFileExtensions[strings.ToUpper(path.Ext(filename))]
+	return ok
+}
+
+func toAliasedPath(basePath, alias, fullPath string) string {
+	relativePath := fullPath[len(basePath):len(fullPath)]
+	if len(relativePath) > 0 && relativePath[0] == '/' {
+		relativePath = relativePath[1:len(relativePath)]
+	}
+	return strings.Replace(path.Join(alias, relativePath), "/", "\\", -1)
+}
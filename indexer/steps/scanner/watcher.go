@@ -0,0 +1,294 @@
+package scanner
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/helpers"
+	"github.com/kevintavog/findaphoto/indexer/steps/checkindex"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ian-kent/go-log/log"
+	"golang.org/x/net/context"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+var WatchEvents int64
+var WatchFilesQueued int64
+var WatchDirectoriesAdded int64
+var FullScans int64
+
+// Changes to a file are held until it's been quiet for this long - copying a large video
+// results in a long stream of write events, only the last one matters.
+const watchSettleDuration = 3 * time.Second
+const watchFlushInterval = 1 * time.Second
+
+var fullScanRunning int32
+
+// Watch every alias path for changes, pushing created, modified and deleted files through
+// the pipeline as they settle. A full scan of every path is run at startup and then every
+// 'fullScanInterval' as a safety net for missed events. Watch returns after 'Stop' is called,
+// once the queued files have been processed.
+// The 'scanComplete' callback is invoked after each full scan.
+func Watch(fullScanInterval time.Duration, scanComplete func()) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal("Unable to create file system watcher: %s", err.Error())
+	}
+	defer watcher.Close()
+
+	checkindex.Start()
+
+	common.VisitAllPaths(func(alias common.AliasDocument) {
+		log.Info("Watching '%s' (alias %s)", alias.Path, alias.Alias)
+		addWatches(watcher, alias.Path)
+	})
+
+	go rescanWorker()
+	go fullScan(scanComplete)
+
+	client := common.CreateClient()
+	pending := make(map[string]time.Time)
+	flushTicker := time.NewTicker(watchFlushInterval)
+	defer flushTicker.Stop()
+	fullScanTicker := time.NewTicker(fullScanInterval)
+	defer fullScanTicker.Stop()
+
+	for {
+		select {
+		case event := <-watcher.Events:
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			atomic.AddInt64(&WatchEvents, 1)
+			pending[event.Name] = time.Now()
+
+		case err := <-watcher.Errors:
+			log.Error("File system watcher error: %s", err.Error())
+
+		case <-flushTicker.C:
+			if Stopped() {
+				waitForStop()
+				return
+			}
+
+			settled := time.Now().Add(-watchSettleDuration)
+			for fullPath, lastChange := range pending {
+				if lastChange.Before(settled) {
+					delete(pending, fullPath)
+					processChange(client, watcher, fullPath)
+				}
+			}
+
+		case <-fullScanTicker.C:
+			go fullScan(scanComplete)
+		}
+	}
+}
+
+// Pending changes are dropped; they're found by the next full scan
+func waitForStop() {
+	for atomic.LoadInt32(&fullScanRunning) != 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+	stopRescanWorker()
+	checkindex.Done()
+	checkindex.Wait()
+}
+
+func fullScan(scanComplete func()) {
+	if !atomic.CompareAndSwapInt32(&fullScanRunning, 0, 1) {
+		log.Warn("Skipping full scan, the previous one is still running")
+		return
+	}
+	defer atomic.StoreInt32(&fullScanRunning, 0)
+	if Stopped() {
+		return
+	}
+
+	atomic.AddInt64(&FullScans, 1)
+	atomic.StoreInt32(&scanCompleted, 0)
+	log.Info("Starting full scan")
+
+	scanLock.Lock()
+	resetIgnoreFiles()
+	RemoveFiles()
+	common.VisitAllPaths(func(alias common.AliasDocument) {
+		scan(alias.Path, alias.Alias, alias.Path)
+	})
+	atomic.StoreInt32(&scanCompleted, 1)
+	scanLock.Unlock()
+
+	scanComplete()
+}
+
+func processChange(client *elastic.Client, watcher *fsnotify.Watcher, fullPath string) {
+	alias, ok := aliasForFullPath(fullPath)
+	if !ok {
+		log.Warn("Ignoring change to '%s', it isn't under an alias path", fullPath)
+		return
+	}
+	aliasedPath := toAliasedPath(alias.Path, alias.Alias, fullPath)
+
+	// Changed ignore rules are applied to what's already indexed by the next full scan
+	if path.Base(fullPath) == IgnoreFilename {
+		forgetIgnoreFile(path.Dir(fullPath))
+		queueRescan(alias.Path, alias.Alias, path.Dir(fullPath))
+		return
+	}
+
+	fileInfo, err := os.Stat(fullPath)
+	if os.IsNotExist(err) {
+		if isSupportedFile(fullPath) {
+			removeFromIndex(client, aliasedPath)
+		} else if common.IsSidecar(fullPath) {
+			// The media paired with a deleted sidecar is re-indexed without it
+			for _, mediaPath := range mediaForSidecar(fullPath) {
+				processChange(client, watcher, mediaPath)
+			}
+		} else {
+			removeDirectoryFromIndex(client, aliasedPath)
+		}
+		return
+	}
+	if err != nil {
+		log.Warn("Unable to get info for changed file '%s': %s", fullPath, err.Error())
+		return
+	}
+
+	if isIgnoredPath(alias.Path, fullPath, fileInfo.IsDir()) {
+		return
+	}
+
+	// The media paired with a changed sidecar is re-indexed
+	if !fileInfo.IsDir() && common.IsSidecar(fullPath) {
+		for _, mediaPath := range mediaForSidecar(fullPath) {
+			processChange(client, watcher, mediaPath)
+		}
+		return
+	}
+
+	if fileInfo.IsDir() {
+		// A new (or moved) directory - watch it and pick up whatever it already contains
+		addWatches(watcher, fullPath)
+		queueRescan(alias.Path, alias.Alias, fullPath)
+		return
+	}
+
+	if isSupportedFile(fileInfo.Name()) {
+		atomic.AddInt64(&WatchFilesQueued, 1)
+		checkindex.EnqueueWatched(fullPath, aliasedPath, fileInfo.Size(), common.FindSidecar(fullPath))
+	}
+}
+
+// The supported files in the same directory that pair with the sidecar
+func mediaForSidecar(sidecarPath string) []string {
+	media := make([]string, 0)
+	files, err := ioutil.ReadDir(path.Dir(sidecarPath))
+	if err != nil {
+		log.Warn("Failed reading files in '%s': %s", path.Dir(sidecarPath), err.Error())
+		return media
+	}
+
+	sidecarName := strings.ToUpper(path.Base(sidecarPath))
+	for _, fileInfo := range files {
+		if fileInfo.IsDir() || !isSupportedFile(fileInfo.Name()) {
+			continue
+		}
+		for _, name := range common.SidecarNames(fileInfo.Name()) {
+			if name == sidecarName {
+				media = append(media, path.Join(path.Dir(sidecarPath), fileInfo.Name()))
+				break
+			}
+		}
+	}
+	return media
+}
+
+func addWatches(watcher *fsnotify.Watcher, basePath string) {
+	filepath.Walk(basePath, func(walkPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Warn("Failed walking '%s': %s", walkPath, err.Error())
+			return nil
+		}
+		if info.IsDir() {
+			if alias, ok := aliasForFullPath(walkPath); ok && isIgnoredPath(alias.Path, walkPath, true) {
+				return filepath.SkipDir
+			}
+			if err := watcher.Add(walkPath); err != nil {
+				log.Error("Unable to watch '%s' (check fs.inotify.max_user_watches): %s", walkPath, err.Error())
+			} else {
+				atomic.AddInt64(&WatchDirectoriesAdded, 1)
+			}
+		}
+		return nil
+	})
+}
+
+// Everything in the index under the given directory is removed
+func removeDirectoryFromIndex(client *elastic.Client, aliasedDirectory string) {
+	prefix := aliasedDirectory + "\\"
+	if common.IndexMakeNoChanges {
+		log.Info("WOULD remove everything under %v", prefix)
+		dryRunRemoveDirectory(client, prefix)
+		return
+	}
+
+	response, err := client.DeleteByQuery().
+		Index(common.MediaIndexName).
+		Type(common.MediaTypeName).
+		Query(elastic.NewPrefixQuery("path.value", prefix)).
+		Do(context.TODO())
+	if err != nil {
+		log.Error("Failed removing documents under '%s' from index: %s", prefix, err.Error())
+		return
+	}
+	atomic.AddInt64(&MediaRemoved, response.Deleted)
+}
+
+// Lists each document that would be removed in the dry run report
+func dryRunRemoveDirectory(client *elastic.Client, prefix string) {
+	scrollService := client.Scroll(common.MediaIndexName).
+		Type(common.MediaTypeName).
+		Query(elastic.NewPrefixQuery("path.value", prefix)).
+		FetchSource(false).
+		Size(100)
+	for {
+		results, err := scrollService.Do(context.TODO())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Error("Failed searching for documents under '%s': %s", prefix, err.Error())
+			break
+		}
+
+		for _, hit := range results.Hits.Hits {
+			atomic.AddInt64(&MediaRemoved, 1)
+			helpers.DryRunRemoved(hit.Id)
+		}
+	}
+}
+
+func aliasForFullPath(fullPath string) (common.AliasDocument, bool) {
+	var found common.AliasDocument
+	foundLength := -1
+	common.VisitAllPaths(func(alias common.AliasDocument) {
+		base := path.Clean(alias.Path)
+		if fullPath == base || strings.HasPrefix(fullPath, base+"/") {
+			// Prefer the most specific path when paths are nested
+			if len(base) > foundLength {
+				found = alias
+				foundLength = len(base)
+			}
+		}
+	})
+	return found, foundLength >= 0
+}
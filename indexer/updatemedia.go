@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/kevintavog/findaphoto/common"
+	"github.com/kevintavog/findaphoto/indexer/helpers"
+	"github.com/kevintavog/findaphoto/indexer/steps/scanner"
+
+	"github.com/ian-kent/go-log/log"
+	"golang.org/x/net/context"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// Scrolls through the indexed media matching the query, updating each in place with the fields
+// 'update' returns - nil if there's nothing to update. 'what' names the fields in log messages.
+// A dry run reports the changes rather than making them. Returns the number of failed updates
+func updateIndexedMedia(what string, query elastic.Query, pageSize int, update func(media *common.Media) map[string]interface{}) int64 {
+	client := common.CreateClient()
+	var failed int64
+
+	scrollService := client.Scroll(common.MediaIndexName).
+		Type(common.MediaTypeName).
+		Query(query).
+		Size(pageSize)
+	defer scrollService.Clear(context.TODO())
+
+	for !scanner.Stopped() {
+		results, err := scrollService.Do(context.TODO())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Error("Failed searching for media to update the %s of: %s", what, err.Error())
+			break
+		}
+
+		bulk := client.Bulk()
+		for _, hit := range results.Hits.Hits {
+			var media common.Media
+			err := json.Unmarshal(*hit.Source, &media)
+			if err != nil {
+				log.Error("Failed deserializing search result: %s", err.Error())
+				continue
+			}
+
+			doc := update(&media)
+			if doc == nil {
+				continue
+			}
+			if common.IndexMakeNoChanges {
+				helpers.DryRunIndexed(&media, hit.Source)
+				continue
+			}
+
+			bulk.Add(elastic.NewBulkUpdateRequest().
+				Index(common.MediaIndexName).
+				Type(common.MediaTypeName).
+				Id(hit.Id).
+				Doc(doc))
+		}
+		failed += bulkUpdate(what, bulk)
+	}
+	return failed
+}
+
+// Returns the number of failed updates
+func bulkUpdate(what string, bulk *elastic.BulkService) int64 {
+	count := bulk.NumberOfActions()
+	if count < 1 {
+		return 0
+	}
+
+	response, err := bulk.Do(context.TODO())
+	if err != nil {
+		log.Error("Failed updating %s: %s", what, err.Error())
+		return int64(count)
+	}
+
+	var failed int64
+	for _, item := range response.Failed() {
+		failed++
+		if item.Error != nil {
+			log.Error("Failed updating the %s of %s: %s", what, item.Id, item.Error.Reason)
+		}
+	}
+	return failed
+}
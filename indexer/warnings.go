@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/kevintavog/findaphoto/common"
+
+	"github.com/ian-kent/go-log/log"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+var warningsMigrated int64
+var warningMigrationsFailed int64
+
+const warningsScrollSize = 500
+
+// Gives codes to the warnings of media indexed before warnings had them. The media is updated
+// in place, so nothing needs to be indexed again
+func migrateWarnings() {
+	startTime := time.Now()
+	warningMigrationsFailed = updateIndexedMedia("warnings", elastic.NewExistsQuery("warnings"), warningsScrollSize, migrateMediaWarnings)
+
+	log.Info("[%01.3f seconds] Migrated the warnings of %d media, %d failed",
+		time.Now().Sub(startTime).Seconds(), warningsMigrated, warningMigrationsFailed)
+}
+
+// Returns the fields to update, nil if there's nothing to update
+func migrateMediaWarnings(media *common.Media) map[string]interface{} {
+	if !media.MigrateLegacyWarnings() {
+		return nil
+	}
+	atomic.AddInt64(&warningsMigrated, 1)
+
+	if common.IndexMakeNoChanges {
+		for _, w := range media.Warnings {
+			log.Info("WOULD give %s the warning code '%s': %s", media.Path, w.Code, w.Message)
+		}
+	}
+	return map[string]interface{}{
+		"warningentries": media.Warnings,
+		"warnings":       nil,
+	}
+}